@@ -8,6 +8,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/datewu/gtea/handler"
 	"github.com/datewu/gtea/jsonlog"
 )
 
@@ -26,6 +27,7 @@ type App struct {
 	Logger         *jsonlog.Logger
 	shutdownStream chan error
 	clearWG        sync.WaitGroup
+	clearLock      sync.Mutex
 	clearFns       []func()
 	bgLock         *sync.Mutex
 	bgWG           sync.WaitGroup
@@ -67,6 +69,12 @@ type Config struct {
 	Metrics        bool
 	LogLevel       jsonlog.Level
 	NoWirteTimeout bool
+	// ReadTimeout and WriteTimeout of the server, default 10s and
+	// 30s. Streaming handlers lift the write timeout with
+	// http.ResponseController, see router.Config.Timeout for per
+	// route timeouts.
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
 }
 
 // DefaultConfig is the default configuration for the application
@@ -98,3 +106,12 @@ func (a *App) GetMetaData(key string) string {
 func (a *App) Env() string {
 	return a.config.Env
 }
+
+// RecoverConfig returns a panic recovery config logging with the app
+// logger, panic details are sent to clients only in development.
+func (a *App) RecoverConfig() handler.RecoverConfig {
+	return handler.RecoverConfig{
+		Logger:      a.Logger,
+		Development: a.Env() == DevEnv,
+	}
+}
@@ -1,10 +1,10 @@
 module github.com/datewu/gtea
 
-go 1.21.1
+go 1.22
 
 require (
 	github.com/datewu/security v0.2.5
+	github.com/klauspost/compress v1.18.0
+	golang.org/x/crypto v0.13.0
 	golang.org/x/time v0.3.0
 )
-
-require golang.org/x/crypto v0.13.0 // indirect
@@ -0,0 +1,179 @@
+package handler
+
+import (
+	"bufio"
+	crand "crypto/rand"
+	"encoding/hex"
+	"errors"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"path"
+	"time"
+
+	"github.com/datewu/gtea/jsonlog"
+)
+
+type requestIDKey struct{}
+
+// RequestID returns the request id set by the AccessLog middleware
+func RequestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDKey{}).(string)
+	return id
+}
+
+// AccessLogConfig of the AccessLog middleware
+type AccessLogConfig struct {
+	// Logger of the entries, default the jsonlog default logger
+	Logger *jsonlog.Logger
+	// SampleRate of the logged requests in (0, 1], default 1. Server
+	// errors and slow requests are always logged.
+	SampleRate float64
+	// Skip route patterns, or paths of unmatched routes, path.Match
+	// patterns e.g. "/v1/healthcheck"
+	Skip []string
+	// SkipFunc skips the requests it returns true for
+	SkipFunc func(r *http.Request) bool
+	// Slow requests are logged at the error level, 0 disables it
+	Slow time.Duration
+	// RequestIDHeader read from the request, a random id is generated
+	// when missing, and set on the response. Default "X-Request-ID".
+	RequestIDHeader string
+}
+
+// AccessLog returns a middleware writing a jsonlog entry per request
+// with the method, route pattern, status, bytes, duration, client IP,
+// user agent and request id. Server errors and slow requests are
+// logged at the error level.
+func AccessLog(conf AccessLogConfig) Middleware {
+	logInfo, logErr := jsonlog.Info, jsonlog.Err
+	if conf.Logger != nil {
+		logInfo, logErr = conf.Logger.Info, conf.Logger.Err
+	}
+	if conf.SampleRate <= 0 || conf.SampleRate > 1 {
+		conf.SampleRate = 1
+	}
+	if conf.RequestIDHeader == "" {
+		conf.RequestIDHeader = "X-Request-ID"
+	}
+	skip := func(r *http.Request) bool {
+		route := RoutePattern(r)
+		if route == "" {
+			route = r.URL.Path
+		}
+		for _, p := range conf.Skip {
+			if ok, _ := path.Match(p, route); ok {
+				return true
+			}
+		}
+		return conf.SkipFunc != nil && conf.SkipFunc(r)
+	}
+	mid := func(next http.HandlerFunc) http.HandlerFunc {
+		middle := func(w http.ResponseWriter, r *http.Request) {
+			id := r.Header.Get(conf.RequestIDHeader)
+			if !validRequestID(id) {
+				id = newRequestID()
+			}
+			w.Header().Set(conf.RequestIDHeader, id)
+			r = SetValue(r, requestIDKey{}, id)
+			if skip(r) {
+				next(w, r)
+				return
+			}
+			start := time.Now()
+			lw := &logWriter{ResponseWriter: w}
+			next(lw, r)
+			duration := time.Since(start)
+			if lw.status == 0 {
+				lw.status = http.StatusOK
+			}
+			slow := conf.Slow > 0 && duration >= conf.Slow
+			if lw.status < 500 && !slow && conf.SampleRate < 1 && rand.Float64() >= conf.SampleRate {
+				return
+			}
+			props := map[string]any{
+				"method":      r.Method,
+				"status":      lw.status,
+				"bytes":       lw.bytes,
+				"duration_ms": float64(duration.Microseconds()) / 1000,
+				"ip":          ClientIP(r),
+				"user_agent":  r.UserAgent(),
+				"request_id":  id,
+			}
+			if route := RoutePattern(r); route != "" {
+				props["route"] = route
+			}
+			switch {
+			case lw.status >= 500:
+				logErr(errors.New("server error"), props)
+			case slow:
+				logErr(errors.New("slow request"), props)
+			default:
+				logInfo("request", props)
+			}
+		}
+		return middle
+	}
+	return mid
+}
+
+// validRequestID accepts short printable ids of the clients
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	crand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// logWriter records the status and size of the response
+type logWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (lw *logWriter) WriteHeader(status int) {
+	if lw.status == 0 && status >= 200 {
+		lw.status = status
+	}
+	lw.ResponseWriter.WriteHeader(status)
+}
+
+func (lw *logWriter) Write(b []byte) (int, error) {
+	if lw.status == 0 {
+		lw.status = http.StatusOK
+	}
+	n, err := lw.ResponseWriter.Write(b)
+	lw.bytes += int64(n)
+	return n, err
+}
+
+// Flush for http.Flusher
+func (lw *logWriter) Flush() {
+	http.NewResponseController(lw.ResponseWriter).Flush()
+}
+
+// Hijack for http.Hijacker
+func (lw *logWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := http.NewResponseController(lw.ResponseWriter).Hijack()
+	if err == nil && lw.status == 0 {
+		lw.status = http.StatusSwitchingProtocols
+	}
+	return conn, rw, err
+}
+
+// Unwrap for http.ResponseController
+func (lw *logWriter) Unwrap() http.ResponseWriter {
+	return lw.ResponseWriter
+}
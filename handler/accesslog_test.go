@@ -0,0 +1,122 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/datewu/gtea/jsonlog"
+)
+
+func TestAccessLog(t *testing.T) {
+	var logs bytes.Buffer
+	mid := AccessLog(AccessLogConfig{
+		Logger: jsonlog.New(&logs, jsonlog.LevelDebug),
+		Skip:   []string{"/v1/healthcheck"},
+		Slow:   20 * time.Millisecond,
+	})
+	var seenID string
+	h := mid(func(w http.ResponseWriter, r *http.Request) {
+		seenID = RequestID(r)
+		switch RoutePattern(r) {
+		case "/users/:id":
+			OKText(w, "hello")
+		case "/slow":
+			time.Sleep(30 * time.Millisecond)
+		case "/fail":
+			ServerErrAny(w, "boom")
+		}
+	})
+	serve := func(path, pattern, id string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		r.RemoteAddr = "10.0.0.1:1234"
+		r.Header.Set("User-Agent", "test")
+		if id != "" {
+			r.Header.Set("X-Request-ID", id)
+		}
+		if pattern != "" {
+			r = SetValue(r, RouteCtxKey, pattern)
+		}
+		w := httptest.NewRecorder()
+		h(w, r)
+		return w
+	}
+	entries := func() []map[string]any {
+		var out []map[string]any
+		for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+			if line == "" {
+				continue
+			}
+			var e struct {
+				Level      string           `json:"level"`
+				Message    string           `json:"message"`
+				Properties []map[string]any `json:"properties"`
+			}
+			if err := json.Unmarshal([]byte(line), &e); err != nil {
+				t.Fatal(err)
+			}
+			e.Properties[0]["level"], e.Properties[0]["message"] = e.Level, e.Message
+			out = append(out, e.Properties[0])
+		}
+		logs.Reset()
+		return out
+	}
+
+	w := serve("/users/7", "/users/:id", "abc-123")
+	if w.Header().Get("X-Request-ID") != "abc-123" || seenID != "abc-123" {
+		t.Errorf("expected the client request id kept got %q %q", w.Header().Get("X-Request-ID"), seenID)
+	}
+	e := entries()
+	if len(e) != 1 {
+		t.Fatalf("expected one entry got %v", e)
+	}
+	for k, v := range map[string]any{
+		"level": "INFO", "method": "GET", "route": "/users/:id", "status": 200.0, "bytes": 5.0,
+		"ip": "10.0.0.1", "user_agent": "test", "request_id": "abc-123",
+	} {
+		if e[0][k] != v {
+			t.Errorf("expected %s %v got %v", k, v, e[0][k])
+		}
+	}
+
+	serve("/v1/healthcheck", "/v1/healthcheck", "")
+	if e := entries(); len(e) != 0 {
+		t.Errorf("expected the health check skipped got %v", e)
+	}
+	if w := serve("/nowhere", "", "bad id"); len(w.Header().Get("X-Request-ID")) != 32 {
+		t.Errorf("expected a generated request id got %q", w.Header().Get("X-Request-ID"))
+	}
+	if e := entries(); len(e) != 1 || e[0]["route"] != nil || e[0]["status"] != 200.0 {
+		t.Errorf("expected no route of an unmatched request got %v", e)
+	}
+	serve("/slow", "/slow", "")
+	serve("/fail", "/fail", "")
+	e = entries()
+	if len(e) != 2 || e[0]["level"] != "ERROR" || e[0]["message"] != "slow request" ||
+		e[1]["level"] != "ERROR" || e[1]["status"] != 500.0 {
+		t.Errorf("expected the slow and failed requests at the error level got %v", e)
+	}
+}
+
+func TestAccessLogSampling(t *testing.T) {
+	var logs bytes.Buffer
+	h := AccessLog(AccessLogConfig{
+		Logger:     jsonlog.New(&logs, jsonlog.LevelDebug),
+		SampleRate: 0.000001,
+	})(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	})
+	for i := 0; i < 20; i++ {
+		h(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	}
+	h(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/fail", nil))
+	if n := strings.Count(logs.String(), "\n"); n != 1 || !strings.Contains(logs.String(), "502") {
+		t.Errorf("expected only the server error logged got %s", logs.String())
+	}
+}
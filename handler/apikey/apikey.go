@@ -0,0 +1,216 @@
+// Package apikey authenticates requests by static API keys kept as
+// hashes in a Store, and enforces the scopes required by routes.
+package apikey
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/datewu/gtea/handler"
+)
+
+// Key is a stored API key
+type Key struct {
+	ID        string    `json:"id"`
+	Hash      string    `json:"hash"` // hex sha256 of the raw key
+	Owner     string    `json:"owner"`
+	Scopes    []string  `json:"scopes"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at,omitempty"` // zero never expires
+	LastUsed  time.Time `json:"last_used,omitempty"`
+}
+
+// Expired reports whether the key is expired at now
+func (k *Key) Expired(now time.Time) bool {
+	return !k.ExpiresAt.IsZero() && !now.Before(k.ExpiresAt)
+}
+
+// HasScope reports whether the key grants scope, "*" grants all
+func (k *Key) HasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope || s == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// Hash returns the hex sha256 of a raw key, keys are random enough to
+// not need a slow password hash.
+func Hash(raw string) string {
+	sum := sha256.Sum256([]byte(raw))
+	return hex.EncodeToString(sum[:])
+}
+
+func random(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Issue create and store a new key, the returned raw key is the only
+// time it is known, hand it to the client.
+func Issue(ctx context.Context, s Store, owner string, scopes ...string) (string, *Key, error) {
+	id, err := random(9)
+	if err != nil {
+		return "", nil, err
+	}
+	secret, err := random(32)
+	if err != nil {
+		return "", nil, err
+	}
+	raw := id + "." + secret
+	k := &Key{
+		ID:        id,
+		Hash:      Hash(raw),
+		Owner:     owner,
+		Scopes:    scopes,
+		CreatedAt: time.Now().UTC(),
+	}
+	if err := s.Put(ctx, k); err != nil {
+		return "", nil, err
+	}
+	return raw, k, nil
+}
+
+// Rotate issue a new key with the owner and scopes of key id, the old
+// key keeps working for the overlap window so clients could switch.
+func Rotate(ctx context.Context, s Store, id string, overlap time.Duration) (string, *Key, error) {
+	old, err := s.Get(ctx, id)
+	if err != nil {
+		return "", nil, err
+	}
+	raw, k, err := Issue(ctx, s, old.Owner, old.Scopes...)
+	if err != nil {
+		return "", nil, err
+	}
+	expire := time.Now().UTC().Add(overlap)
+	if old.ExpiresAt.IsZero() || expire.Before(old.ExpiresAt) {
+		old.ExpiresAt = expire
+	}
+	if err := s.Put(ctx, old); err != nil {
+		return "", nil, err
+	}
+	return raw, k, nil
+}
+
+type ctxKey struct{}
+
+// FromContext returns the key authenticated by the middleware
+func FromContext(ctx context.Context) (*Key, bool) {
+	k, ok := ctx.Value(ctxKey{}).(*Key)
+	return k, ok
+}
+
+// Config of an Auth
+type Config struct {
+	Store Store
+	// Header carrying the key, default "X-API-Key"
+	Header string
+	// Query parameter carrying the key, empty disables it
+	Query string
+	// TouchInterval throttle LastUsed writes, default 1 minute
+	TouchInterval time.Duration
+	Now           func() time.Time
+}
+
+// Auth authenticates API keys
+type Auth struct {
+	conf Config
+}
+
+// New returns an Auth
+func New(conf Config) *Auth {
+	if conf.Header == "" {
+		conf.Header = "X-API-Key"
+	}
+	if conf.TouchInterval <= 0 {
+		conf.TouchInterval = time.Minute
+	}
+	if conf.Now == nil {
+		conf.Now = time.Now
+	}
+	return &Auth{conf: conf}
+}
+
+var errInvalidKey = errors.New("invalid api key")
+
+func (a *Auth) raw(r *http.Request) string {
+	raw := strings.TrimSpace(r.Header.Get(a.conf.Header))
+	if raw == "" && a.conf.Query != "" {
+		raw = handler.ReadQuery(r, a.conf.Query, "")
+	}
+	return raw
+}
+
+// Authenticate look up the key of the request
+func (a *Auth) Authenticate(r *http.Request) (*Key, error) {
+	raw := a.raw(r)
+	if raw == "" {
+		return nil, handler.ErrNoToken
+	}
+	k, err := a.conf.Store.Find(r.Context(), Hash(raw))
+	if errors.Is(err, ErrNotFound) {
+		return nil, errInvalidKey
+	}
+	if err != nil {
+		return nil, err
+	}
+	now := a.conf.Now()
+	if k.Expired(now) {
+		return nil, errInvalidKey
+	}
+	if now.Sub(k.LastUsed) >= a.conf.TouchInterval {
+		k.LastUsed = now
+		a.conf.Store.Touch(r.Context(), k.ID, now)
+	}
+	return k, nil
+}
+
+// Require authenticate the request key and check it grants all scopes
+func (a *Auth) Require(scopes ...string) handler.Middleware {
+	mid := func(next http.HandlerFunc) http.HandlerFunc {
+		check := func(w http.ResponseWriter, r *http.Request) {
+			k, ok := FromContext(r.Context())
+			if !ok {
+				var err error
+				k, err = a.Authenticate(r)
+				switch {
+				case errors.Is(err, handler.ErrNoToken):
+					handler.AuthenticationRequire(w)
+					return
+				case errors.Is(err, errInvalidKey):
+					handler.InvalidAuthenticationToken(w)
+					return
+				case err != nil:
+					handler.ServerErr(w, err)
+					return
+				}
+				r = handler.SetValue(r, ctxKey{}, k)
+			}
+			for _, s := range scopes {
+				if !k.HasScope(s) {
+					handler.NotPermitted(w)
+					return
+				}
+			}
+			next(w, r)
+		}
+		return check
+	}
+	return mid
+}
+
+// Middleware authenticate the request key without scope requirement
+func (a *Auth) Middleware() handler.Middleware {
+	return a.Require()
+}
@@ -0,0 +1,128 @@
+package apikey
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/datewu/gtea/handler"
+)
+
+func TestRequire(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemory()
+	raw, k, err := Issue(ctx, store, "partner", "orders:read")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := New(Config{Store: store, Query: "api_key"})
+	read := a.Require("orders:read")(handler.HealthCheck)
+	write := a.Require("orders:write")(handler.HealthCheck)
+	req := func(h http.HandlerFunc, target, key string) int {
+		r := httptest.NewRequest(http.MethodGet, target, nil)
+		if key != "" {
+			r.Header.Set("X-API-Key", key)
+		}
+		w := httptest.NewRecorder()
+		h(w, r)
+		return w.Code
+	}
+	if code := req(read, "/", raw); code != http.StatusOK {
+		t.Errorf("expected %d got %d", http.StatusOK, code)
+	}
+	if code := req(read, "/?api_key="+raw, ""); code != http.StatusOK {
+		t.Errorf("expected %d got %d", http.StatusOK, code)
+	}
+	if code := req(write, "/", raw); code != http.StatusForbidden {
+		t.Errorf("expected %d got %d", http.StatusForbidden, code)
+	}
+	if code := req(read, "/", ""); code != http.StatusUnauthorized {
+		t.Errorf("expected %d got %d", http.StatusUnauthorized, code)
+	}
+	if code := req(read, "/", raw+"x"); code != http.StatusUnauthorized {
+		t.Errorf("expected %d got %d", http.StatusUnauthorized, code)
+	}
+	stored, _ := store.Get(ctx, k.ID)
+	if stored.LastUsed.IsZero() {
+		t.Error("expected last used recorded")
+	}
+	if strings.Contains(stored.Hash, raw) {
+		t.Error("raw key must not be stored")
+	}
+}
+
+func TestRotate(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemory()
+	oldRaw, old, _ := Issue(ctx, store, "partner", "*")
+	now := time.Now()
+	a := New(Config{Store: store, Now: func() time.Time { return now }})
+	newRaw, _, err := Rotate(ctx, store, old.ID, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := func(raw string) error {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("X-API-Key", raw)
+		_, err := a.Authenticate(r)
+		return err
+	}
+	if auth(oldRaw) != nil || auth(newRaw) != nil {
+		t.Error("expected both keys valid during overlap")
+	}
+	now = now.Add(2 * time.Hour)
+	if auth(oldRaw) == nil {
+		t.Error("expected old key expired after overlap")
+	}
+	if auth(newRaw) != nil {
+		t.Error("expected new key valid after overlap")
+	}
+}
+
+func TestFileStore(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "keys.json")
+	f, err := NewFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, k, err := Issue(ctx, f, "partner", "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(path)
+	if strings.Contains(string(data), raw) {
+		t.Error("raw key must not be persisted")
+	}
+	f, err = NewFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found, err := f.Find(ctx, Hash(raw))
+	if err != nil || found.ID != k.ID {
+		t.Errorf("expected key %s got %v %v", k.ID, found, err)
+	}
+}
+
+func TestMemoryIndex(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemory()
+	raw, k, _ := Issue(ctx, store, "partner", "*")
+	k.Hash = Hash("replaced")
+	store.Put(ctx, k)
+	if _, err := store.Find(ctx, Hash(raw)); err != ErrNotFound {
+		t.Errorf("expected the replaced hash unindexed got %v", err)
+	}
+	if found, err := store.Find(ctx, Hash("replaced")); err != nil || found.ID != k.ID {
+		t.Errorf("expected key %s got %v %v", k.ID, found, err)
+	}
+	store.Delete(ctx, k.ID)
+	if _, err := store.Find(ctx, Hash("replaced")); err != ErrNotFound {
+		t.Errorf("expected the deleted key unindexed got %v", err)
+	}
+}
@@ -0,0 +1,194 @@
+package apikey
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// ErrNotFound is returned by a Store for an unknown key
+var ErrNotFound = errors.New("api key not found")
+
+// Store holds keys by the hash of the raw key, never the raw key
+type Store interface {
+	Find(ctx context.Context, hash string) (*Key, error)
+	Get(ctx context.Context, id string) (*Key, error)
+	Put(ctx context.Context, k *Key) error
+	Delete(ctx context.Context, id string) error
+	Touch(ctx context.Context, id string, at time.Time) error
+}
+
+// Memory is an in memory Store
+type Memory struct {
+	mu     sync.RWMutex
+	keys   map[string]*Key // by id
+	hashes map[string]*Key // by hash
+}
+
+// NewMemory returns an empty Memory store
+func NewMemory() *Memory {
+	return &Memory{keys: make(map[string]*Key), hashes: make(map[string]*Key)}
+}
+
+// Find a key by hash
+func (m *Memory) Find(_ context.Context, hash string) (*Key, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	k, ok := m.hashes[hash]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	c := *k
+	return &c, nil
+}
+
+// Get a key by id
+func (m *Memory) Get(_ context.Context, id string) (*Key, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	k, ok := m.keys[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	c := *k
+	return &c, nil
+}
+
+// Put insert or replace a key
+func (m *Memory) Put(_ context.Context, k *Key) error {
+	c := *k
+	m.mu.Lock()
+	m.put(&c)
+	m.mu.Unlock()
+	return nil
+}
+
+// put index k by id and hash, m.mu held
+func (m *Memory) put(k *Key) {
+	if old, ok := m.keys[k.ID]; ok {
+		delete(m.hashes, old.Hash)
+	}
+	m.keys[k.ID] = k
+	m.hashes[k.Hash] = k
+}
+
+// Delete a key by id
+func (m *Memory) Delete(_ context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	k, ok := m.keys[id]
+	if !ok {
+		return ErrNotFound
+	}
+	delete(m.keys, id)
+	delete(m.hashes, k.Hash)
+	return nil
+}
+
+// Touch record the last used time
+func (m *Memory) Touch(_ context.Context, id string, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	k, ok := m.keys[id]
+	if !ok {
+		return ErrNotFound
+	}
+	k.LastUsed = at
+	return nil
+}
+
+// File is a Store persisted as a json file, every change rewrites the file
+type File struct {
+	mem  *Memory
+	path string
+	mu   sync.Mutex
+}
+
+// NewFile load the keys of path, a missing file is an empty store
+func NewFile(path string) (*File, error) {
+	f := &File{mem: NewMemory(), path: path}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return f, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var keys []*Key
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return nil, err
+	}
+	for _, k := range keys {
+		f.mem.put(k)
+	}
+	return f, nil
+}
+
+// Find a key by hash
+func (f *File) Find(ctx context.Context, hash string) (*Key, error) {
+	return f.mem.Find(ctx, hash)
+}
+
+// Get a key by id
+func (f *File) Get(ctx context.Context, id string) (*Key, error) {
+	return f.mem.Get(ctx, id)
+}
+
+// Put insert or replace a key
+func (f *File) Put(ctx context.Context, k *Key) error {
+	return f.change(func() error { return f.mem.Put(ctx, k) })
+}
+
+// Delete a key by id
+func (f *File) Delete(ctx context.Context, id string) error {
+	return f.change(func() error { return f.mem.Delete(ctx, id) })
+}
+
+// Touch record the last used time
+func (f *File) Touch(ctx context.Context, id string, at time.Time) error {
+	return f.change(func() error { return f.mem.Touch(ctx, id, at) })
+}
+
+func (f *File) change(fn func() error) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := fn(); err != nil {
+		return err
+	}
+	return f.save()
+}
+
+// save write the file atomically
+func (f *File) save() error {
+	f.mem.mu.RLock()
+	keys := make([]*Key, 0, len(f.mem.keys))
+	for _, k := range f.mem.keys {
+		keys = append(keys, k)
+	}
+	data, err := json.MarshalIndent(keys, "", "  ")
+	f.mem.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(f.path), ".apikeys-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), f.path)
+}
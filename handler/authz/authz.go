@@ -0,0 +1,229 @@
+// Package authz is a role and permission based authorization layer
+// evaluating the principal authenticated by the auth middlewares.
+package authz
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/datewu/gtea/handler"
+	"github.com/datewu/gtea/handler/apikey"
+	"github.com/datewu/gtea/handler/basicauth"
+	"github.com/datewu/gtea/handler/jwt"
+	"github.com/datewu/gtea/jsonlog"
+)
+
+// Principal is an authenticated subject
+type Principal struct {
+	ID          string
+	Roles       []string
+	Permissions []string
+}
+
+type ctxKey struct{}
+
+// NewContext returns a ctx carrying p, for custom auth middlewares
+func NewContext(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, ctxKey{}, p)
+}
+
+// FromContext returns the principal put by NewContext
+func FromContext(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(ctxKey{}).(*Principal)
+	return p, ok
+}
+
+// Resolver returns the principal of a request
+type Resolver func(*http.Request) (*Principal, bool)
+
+// DefaultResolver look up the principal put by NewContext, then the
+// jwt claims (sub, roles, scope), the apikey (owner, scopes) and the
+// basicauth user.
+func DefaultResolver(r *http.Request) (*Principal, bool) {
+	ctx := r.Context()
+	if p, ok := FromContext(ctx); ok {
+		return p, true
+	}
+	if c, ok := jwt.FromContext(ctx); ok {
+		return &Principal{ID: c.Subject, Roles: c.Roles, Permissions: c.Scopes()}, true
+	}
+	if k, ok := apikey.FromContext(ctx); ok {
+		return &Principal{ID: k.Owner, Permissions: k.Scopes}, true
+	}
+	if u := basicauth.User(ctx); u != "" {
+		return &Principal{ID: u}, true
+	}
+	return nil, false
+}
+
+// Policy config an Authorizer
+type Policy struct {
+	// Roles grant permissions to roles, "*" grants everything and
+	// "users:*" every permission of the users prefix.
+	Roles   map[string][]string
+	Resolve Resolver        // default DefaultResolver
+	Logger  *jsonlog.Logger // audit log, default the jsonlog default logger
+}
+
+// Authorizer evaluates requirements against the policy
+type Authorizer struct {
+	policy Policy
+}
+
+// New returns an Authorizer
+func New(p Policy) *Authorizer {
+	if p.Resolve == nil {
+		p.Resolve = DefaultResolver
+	}
+	return &Authorizer{policy: p}
+}
+
+// Default is the Authorizer of the package level functions
+var Default = New(Policy{})
+
+func grants(granted, perm string) bool {
+	if granted == "*" || granted == perm {
+		return true
+	}
+	prefix, ok := strings.CutSuffix(granted, "*")
+	return ok && strings.HasPrefix(perm, prefix)
+}
+
+// Can reports whether p has perm directly or through a role
+func (a *Authorizer) Can(p *Principal, perm string) bool {
+	for _, g := range p.Permissions {
+		if grants(g, perm) {
+			return true
+		}
+	}
+	for _, role := range p.Roles {
+		for _, g := range a.policy.Roles[role] {
+			if grants(g, perm) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// HasRole reports whether p has role
+func (a *Authorizer) HasRole(p *Principal, role string) bool {
+	for _, r := range p.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *Authorizer) audit(r *http.Request, p *Principal, allowed bool, rule string) {
+	props := map[string]any{
+		"principal": p.ID,
+		"allowed":   allowed,
+		"rule":      rule,
+		"method":    r.Method,
+		"path":      r.URL.Path,
+	}
+	switch {
+	case a.policy.Logger == nil && allowed:
+		jsonlog.Debug("authz decision", props)
+	case a.policy.Logger == nil:
+		jsonlog.Info("authz decision", props)
+	case allowed:
+		a.policy.Logger.Debug("authz decision", props)
+	default:
+		a.policy.Logger.Info("authz decision", props)
+	}
+}
+
+// check is the shared middleware of every requirement
+func (a *Authorizer) check(rule string, allow func(*http.Request, *Principal) (bool, error)) handler.Middleware {
+	mid := func(next http.HandlerFunc) http.HandlerFunc {
+		authorize := func(w http.ResponseWriter, r *http.Request) {
+			p, ok := a.policy.Resolve(r)
+			if !ok {
+				handler.AuthenticationRequire(w)
+				return
+			}
+			allowed, err := allow(r, p)
+			if err != nil {
+				handler.ServerErr(w, err)
+				return
+			}
+			a.audit(r, p, allowed, rule)
+			if !allowed {
+				handler.NotPermitted(w)
+				return
+			}
+			next(w, r)
+		}
+		return authorize
+	}
+	return mid
+}
+
+// Require every perm
+func (a *Authorizer) Require(perms ...string) handler.Middleware {
+	return a.check("all:"+strings.Join(perms, ","), func(_ *http.Request, p *Principal) (bool, error) {
+		for _, perm := range perms {
+			if !a.Can(p, perm) {
+				return false, nil
+			}
+		}
+		return true, nil
+	})
+}
+
+// RequireAny of perms
+func (a *Authorizer) RequireAny(perms ...string) handler.Middleware {
+	return a.check("any:"+strings.Join(perms, ","), func(_ *http.Request, p *Principal) (bool, error) {
+		for _, perm := range perms {
+			if a.Can(p, perm) {
+				return true, nil
+			}
+		}
+		return false, nil
+	})
+}
+
+// RequireRole any of roles
+func (a *Authorizer) RequireRole(roles ...string) handler.Middleware {
+	return a.check("role:"+strings.Join(roles, ","), func(_ *http.Request, p *Principal) (bool, error) {
+		for _, role := range roles {
+			if a.HasRole(p, role) {
+				return true, nil
+			}
+		}
+		return false, nil
+	})
+}
+
+// ResourceCheck decides on the resource of a request, e.g. whether the
+// principal owns the record of the path param
+type ResourceCheck func(r *http.Request, p *Principal) (bool, error)
+
+// Resource require perm, then pass the resource level check
+func (a *Authorizer) Resource(perm string, check ResourceCheck) handler.Middleware {
+	return a.check("resource:"+perm, func(r *http.Request, p *Principal) (bool, error) {
+		if !a.Can(p, perm) {
+			return false, nil
+		}
+		return check(r, p)
+	})
+}
+
+// Require every perm with the Default Authorizer
+func Require(perms ...string) handler.Middleware {
+	return Default.Require(perms...)
+}
+
+// RequireAny of perms with the Default Authorizer
+func RequireAny(perms ...string) handler.Middleware {
+	return Default.RequireAny(perms...)
+}
+
+// RequireRole any of roles with the Default Authorizer
+func RequireRole(roles ...string) handler.Middleware {
+	return Default.RequireRole(roles...)
+}
@@ -0,0 +1,77 @@
+package authz
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/datewu/gtea/handler"
+	"github.com/datewu/gtea/handler/jwt"
+	"github.com/datewu/gtea/jsonlog"
+	"github.com/datewu/gtea/router"
+)
+
+func TestRequire(t *testing.T) {
+	logs := new(bytes.Buffer)
+	a := New(Policy{
+		Roles:  map[string][]string{"admin": {"users:*"}, "viewer": {"users:read"}},
+		Logger: jsonlog.New(logs, jsonlog.LevelInfo),
+	})
+	authn := func(p *Principal) handler.Middleware {
+		return func(next http.HandlerFunc) http.HandlerFunc {
+			return func(w http.ResponseWriter, r *http.Request) {
+				if p != nil {
+					r = r.WithContext(NewContext(r.Context(), p))
+				}
+				next(w, r)
+			}
+		}
+	}
+	owner := a.Resource("users:read", func(r *http.Request, p *Principal) (bool, error) {
+		return handler.ReadPathParam(r, "id") == p.ID, nil
+	})
+	table := []struct {
+		p      *Principal
+		path   string
+		expect int
+	}{
+		{nil, "/admin/users", http.StatusUnauthorized},
+		{&Principal{ID: "1", Roles: []string{"viewer"}}, "/admin/users", http.StatusForbidden},
+		{&Principal{ID: "1", Roles: []string{"admin"}}, "/admin/users", http.StatusOK},
+		{&Principal{ID: "1", Permissions: []string{"users:write"}}, "/admin/users", http.StatusOK},
+		{&Principal{ID: "1", Roles: []string{"viewer"}}, "/users/1", http.StatusOK},
+		{&Principal{ID: "2", Roles: []string{"viewer"}}, "/users/1", http.StatusForbidden},
+	}
+	for _, c := range table {
+		g, _ := router.NewRoutesGroup(&router.Config{})
+		g.Use(authn(c.p))
+		g.Group("/admin", a.Require("users:write")).Post("/users", handler.HealthCheck)
+		g.Group("/users", owner).Get("/:id", handler.HealthCheck)
+		method := http.MethodGet
+		if strings.HasPrefix(c.path, "/admin") {
+			method = http.MethodPost
+		}
+		w := httptest.NewRecorder()
+		g.Handler().ServeHTTP(w, httptest.NewRequest(method, c.path, nil))
+		if w.Code != c.expect {
+			t.Errorf("%+v %s: expected %d got %d", c.p, c.path, c.expect, w.Code)
+		}
+	}
+	if !strings.Contains(logs.String(), `"allowed":false`) {
+		t.Errorf("expected denied decisions audited, got %q", logs.String())
+	}
+}
+
+func TestJWTPrincipal(t *testing.T) {
+	h := RequireRole("ops")(handler.HealthCheck)
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r = r.WithContext(jwt.NewContext(context.Background(), &jwt.Claims{Subject: "bob", Roles: []string{"ops"}}))
+	w := httptest.NewRecorder()
+	h(w, r)
+	if w.Code != http.StatusOK {
+		t.Errorf("expected %d got %d", http.StatusOK, w.Code)
+	}
+}
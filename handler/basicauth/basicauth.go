@@ -0,0 +1,165 @@
+// Package basicauth is a HTTP Basic auth middleware backed by an Apache
+// htpasswd file, the file is reloaded when it changes.
+package basicauth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/datewu/gtea/handler"
+	"github.com/datewu/gtea/jsonlog"
+)
+
+// Config of an Auth
+type Config struct {
+	File          string
+	Realm         string        // default "Restricted"
+	AllowPlain    bool          // accept plain text passwords, for tests only
+	CheckInterval time.Duration // how often the file is checked for changes, default 5s
+}
+
+// Auth verify users of an htpasswd file
+type Auth struct {
+	conf    Config
+	mu      sync.RWMutex
+	users   map[string]hash
+	modTime time.Time
+	size    int64
+	checked time.Time
+}
+
+// New load the htpasswd file
+func New(conf Config) (*Auth, error) {
+	if conf.File == "" {
+		return nil, errors.New("basicauth: no htpasswd file")
+	}
+	if conf.Realm == "" {
+		conf.Realm = "Restricted"
+	}
+	if conf.CheckInterval <= 0 {
+		conf.CheckInterval = 5 * time.Second
+	}
+	users, info, err := loadHtpasswd(conf.File, conf.AllowPlain)
+	if err != nil {
+		return nil, err
+	}
+	return &Auth{
+		conf:    conf,
+		users:   users,
+		modTime: info.ModTime(),
+		size:    info.Size(),
+		checked: time.Now(),
+	}, nil
+}
+
+// reload the file if it changed since last check, the old users
+// are kept when the new file is invalid.
+func (a *Auth) reload() {
+	a.mu.RLock()
+	due := time.Since(a.checked) >= a.conf.CheckInterval
+	a.mu.RUnlock()
+	if !due {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if time.Since(a.checked) < a.conf.CheckInterval {
+		return
+	}
+	a.checked = time.Now()
+	info, err := os.Stat(a.conf.File)
+	if err != nil {
+		jsonlog.Err(err, map[string]any{"htpasswd": a.conf.File})
+		return
+	}
+	if info.ModTime().Equal(a.modTime) && info.Size() == a.size {
+		return
+	}
+	users, info, err := loadHtpasswd(a.conf.File, a.conf.AllowPlain)
+	if err != nil {
+		jsonlog.Err(err, map[string]any{"htpasswd": a.conf.File})
+		return
+	}
+	a.users, a.modTime, a.size = users, info.ModTime(), info.Size()
+	jsonlog.Info("htpasswd reloaded", map[string]any{"file": a.conf.File, "users": len(users)})
+}
+
+// Verify user and password
+func (a *Auth) Verify(user, password string) bool {
+	a.reload()
+	a.mu.RLock()
+	h, ok := a.users[user]
+	a.mu.RUnlock()
+	if !ok {
+		dummy.match(password)
+		return false
+	}
+	return h.match(password)
+}
+
+type ctxKey struct{}
+
+// User returns the authenticated user name
+func User(ctx context.Context) string {
+	u, _ := ctx.Value(ctxKey{}).(string)
+	return u
+}
+
+// Option overrides the Auth config for a group
+type Option func(*options)
+
+type options struct {
+	realm string
+	users map[string]bool
+}
+
+// Realm override the realm
+func Realm(realm string) Option {
+	return func(o *options) {
+		o.realm = realm
+	}
+}
+
+// Users only allow some users of the file
+func Users(users ...string) Option {
+	return func(o *options) {
+		o.users = make(map[string]bool, len(users))
+		for _, u := range users {
+			o.users[u] = true
+		}
+	}
+}
+
+// Middleware ask for Basic credentials, Router.Static accepts it
+// as a middleware for the static dir.
+func (a *Auth) Middleware(opts ...Option) handler.Middleware {
+	o := &options{realm: a.conf.Realm}
+	for _, fn := range opts {
+		fn(o)
+	}
+	challenge := `Basic realm=` + strconv.Quote(o.realm) + `, charset="UTF-8"`
+	mid := func(next http.HandlerFunc) http.HandlerFunc {
+		check := func(w http.ResponseWriter, r *http.Request) {
+			user, pass, ok := r.BasicAuth()
+			if !ok {
+				w.Header().Set("WWW-Authenticate", challenge)
+				handler.AuthenticationRequire(w)
+				return
+			}
+			valid := a.Verify(user, pass)
+			if !valid || (o.users != nil && !o.users[user]) {
+				w.Header().Set("WWW-Authenticate", challenge)
+				handler.InvalidAuthenticationToken(w)
+				return
+			}
+			next(w, handler.SetValue(r, ctxKey{}, user))
+		}
+		return check
+	}
+	return mid
+}
@@ -0,0 +1,70 @@
+package basicauth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/datewu/gtea/handler"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func writeHtpasswd(t *testing.T, path, content string, mod time.Time) {
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(path, mod, mod)
+}
+
+func TestBasicAuth(t *testing.T) {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("bcrypt-pw"), bcrypt.MinCost)
+	path := filepath.Join(t.TempDir(), ".htpasswd")
+	content := "# users\nalice:" + string(hash) + "\n" +
+		"bob:{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g=\n" + // password
+		"carol:plain-pw\n"
+	writeHtpasswd(t, path, content, time.Now().Add(-time.Hour))
+	if _, err := New(Config{File: path}); err == nil {
+		t.Error("expected plain passwords rejected")
+	}
+	a, err := New(Config{File: path, AllowPlain: true, Realm: "tools", CheckInterval: time.Nanosecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := a.Middleware()(handler.HealthCheck)
+	adminOnly := a.Middleware(Realm("admin"), Users("alice"))(handler.HealthCheck)
+	req := func(h http.HandlerFunc, user, pass string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if user != "" {
+			r.SetBasicAuth(user, pass)
+		}
+		w := httptest.NewRecorder()
+		h(w, r)
+		return w
+	}
+	for user, pass := range map[string]string{"alice": "bcrypt-pw", "bob": "password", "carol": "plain-pw"} {
+		if w := req(h, user, pass); w.Code != http.StatusOK {
+			t.Errorf("%s: expected %d got %d", user, http.StatusOK, w.Code)
+		}
+		if w := req(h, user, pass+"x"); w.Code != http.StatusUnauthorized {
+			t.Errorf("%s: expected %d got %d", user, http.StatusUnauthorized, w.Code)
+		}
+	}
+	w := req(h, "", "")
+	if w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") != `Basic realm="tools", charset="UTF-8"` {
+		t.Errorf("unexpected challenge %d %q", w.Code, w.Header().Get("WWW-Authenticate"))
+	}
+	if w := req(adminOnly, "bob", "password"); w.Code != http.StatusUnauthorized ||
+		w.Header().Get("WWW-Authenticate") != `Basic realm="admin", charset="UTF-8"` {
+		t.Errorf("unexpected group override %d %v", w.Code, w.Header())
+	}
+	writeHtpasswd(t, path, "dave:new-pw\n", time.Now())
+	if w := req(h, "dave", "new-pw"); w.Code != http.StatusOK {
+		t.Errorf("expected reloaded user got %d", w.Code)
+	}
+	if w := req(h, "carol", "plain-pw"); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected removed user got %d", w.Code)
+	}
+}
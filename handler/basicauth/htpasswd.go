@@ -0,0 +1,104 @@
+package basicauth
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// hash is a password hash of an htpasswd line
+type hash interface {
+	match(password string) bool
+}
+
+type bcryptHash []byte
+
+func (h bcryptHash) match(password string) bool {
+	return bcrypt.CompareHashAndPassword(h, []byte(password)) == nil
+}
+
+type sha1Hash []byte
+
+func (h sha1Hash) match(password string) bool {
+	sum := sha1.Sum([]byte(password))
+	return subtle.ConstantTimeCompare(h, sum[:]) == 1
+}
+
+// plainHash keeps the sha256 of the password, so the comparison
+// doesn't leak the password length
+type plainHash [32]byte
+
+func (h plainHash) match(password string) bool {
+	sum := sha256.Sum256([]byte(password))
+	return subtle.ConstantTimeCompare(h[:], sum[:]) == 1
+}
+
+// dummy is compared for unknown users to not leak them by timing
+var dummy = bcryptHash("$2a$10$CWOrkoo7oJBQ/iyh7uJ0LO2aLEfrHwTWllSAxT0zRno7KaoVZ2Dni")
+
+// parseHtpasswd parse an Apache htpasswd file with bcrypt, {SHA}
+// and, when allowPlain, plain text passwords.
+func parseHtpasswd(data []byte, allowPlain bool) (map[string]hash, error) {
+	users := make(map[string]hash)
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	n := 0
+	for sc.Scan() {
+		n++
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		user, pw, ok := strings.Cut(line, ":")
+		if !ok || user == "" {
+			return nil, fmt.Errorf("htpasswd line %d: missing user or password", n)
+		}
+		h, err := parseHash(pw, allowPlain)
+		if err != nil {
+			return nil, fmt.Errorf("htpasswd line %d: %w", n, err)
+		}
+		users[user] = h
+	}
+	return users, sc.Err()
+}
+
+func parseHash(pw string, allowPlain bool) (hash, error) {
+	switch {
+	case strings.HasPrefix(pw, "$2a$"), strings.HasPrefix(pw, "$2b$"), strings.HasPrefix(pw, "$2y$"):
+		return bcryptHash(pw), nil
+	case strings.HasPrefix(pw, "{SHA}"):
+		sum, err := base64.StdEncoding.DecodeString(pw[len("{SHA}"):])
+		if err != nil || len(sum) != sha1.Size {
+			return nil, fmt.Errorf("invalid {SHA} hash")
+		}
+		return sha1Hash(sum), nil
+	case strings.HasPrefix(pw, "$"):
+		return nil, fmt.Errorf("unsupported hash %q", strings.SplitN(pw, "$", 3)[1])
+	case allowPlain:
+		return plainHash(sha256.Sum256([]byte(pw))), nil
+	}
+	return nil, fmt.Errorf("plain text passwords are not allowed")
+}
+
+func loadHtpasswd(path string, allowPlain bool) (map[string]hash, os.FileInfo, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	users, err := parseHtpasswd(data, allowPlain)
+	if err != nil {
+		return nil, nil, err
+	}
+	return users, info, nil
+}
@@ -0,0 +1,164 @@
+package handler
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// ErrDecompressionRatio is returned by the reads of a decompressed
+// request body growing more than BodyConfig.MaxRatio times its size
+var ErrDecompressionRatio = errors.New("request body decompression ratio exceeded")
+
+// BodyConfig of the BodyLimit middleware
+type BodyConfig struct {
+	// MaxBytes of every request body without a route limit, after
+	// decompression, 0 for no limit
+	MaxBytes int64
+	// Routes limits by route pattern "/upload" or method and pattern
+	// "PUT /upload", 0 for no limit
+	Routes map[string]int64
+	// Decompress gzip, deflate and zstd bodies of the Content-Encoding
+	// header, other encodings are rejected with 415
+	Decompress bool
+	// MaxRatio of the decompressed to the compressed size, default 100
+	MaxRatio int64
+}
+
+// BodyLimit returns a middleware limiting the size of request bodies,
+// the ones with a larger Content-Length are rejected with 413 and the
+// reads of the others fail with *http.MaxBytesError after the limit.
+func BodyLimit(conf BodyConfig) Middleware {
+	if conf.MaxRatio <= 0 {
+		conf.MaxRatio = 100
+	}
+	limit := func(r *http.Request) int64 {
+		if len(conf.Routes) > 0 {
+			pattern := RoutePattern(r)
+			if n, ok := conf.Routes[r.Method+" "+pattern]; ok {
+				return n
+			}
+			if n, ok := conf.Routes[pattern]; ok {
+				return n
+			}
+		}
+		return conf.MaxBytes
+	}
+	mid := func(next http.HandlerFunc) http.HandlerFunc {
+		middle := func(w http.ResponseWriter, r *http.Request) {
+			max := limit(r)
+			if max > 0 && r.ContentLength > max {
+				BodyTooLarge(w, max)
+				return
+			}
+			if enc := r.Header.Get("Content-Encoding"); conf.Decompress && enc != "" && r.Body != http.NoBody {
+				body, err := decompress(r.Body, enc, conf.MaxRatio, max)
+				if err != nil {
+					if errors.Is(err, errUnsupportedEncoding) {
+						errResponse(w, http.StatusUnsupportedMediaType, err.Error())
+						return
+					}
+					BadRequestErr(w, err)
+					return
+				}
+				defer body.Close()
+				r.Body = body
+				r.ContentLength = -1
+				r.Header.Del("Content-Encoding")
+				r.Header.Del("Content-Length")
+			}
+			if max > 0 && r.Body != nil && r.Body != http.NoBody {
+				r.Body = http.MaxBytesReader(w, r.Body, max)
+			}
+			next(w, r)
+		}
+		return middle
+	}
+	return mid
+}
+
+// BodyTooLarge 413 response
+func BodyTooLarge(w http.ResponseWriter, max int64) {
+	errResponse(w, http.StatusRequestEntityTooLarge,
+		fmt.Sprintf("body must not be larger than %d bytes", max))
+}
+
+var errUnsupportedEncoding = errors.New("unsupported content encoding")
+
+// zstdMaxWindow bounds the memory of a zstd frame, the default
+// window of the decoder is 512MB whatever the body size
+const zstdMaxWindow = 8 << 20
+
+// decompress body of the content encoding, max is the limit of the
+// decompressed size, 0 for none
+func decompress(body io.Reader, encoding string, maxRatio, max int64) (io.ReadCloser, error) {
+	src := &countReader{r: body}
+	var (
+		dec io.ReadCloser
+		err error
+	)
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "gzip", "x-gzip":
+		dec, err = gzip.NewReader(src)
+	case "deflate":
+		dec, err = zlib.NewReader(src)
+	case "zstd":
+		var d *zstd.Decoder
+		opts := []zstd.DOption{zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxWindow(zstdMaxWindow)}
+		if max > 0 {
+			// frames declare a window of at least MinWindowSize
+			mem := uint64(max)
+			if mem < zstd.MinWindowSize {
+				mem = zstd.MinWindowSize
+			}
+			opts = append(opts, zstd.WithDecoderMaxMemory(mem))
+		}
+		d, err = zstd.NewReader(src, opts...)
+		if err == nil {
+			dec = d.IOReadCloser()
+		}
+	case "identity":
+		return io.NopCloser(body), nil
+	default:
+		return nil, fmt.Errorf("%w %q", errUnsupportedEncoding, encoding)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s body: %w", encoding, err)
+	}
+	return &ratioReader{ReadCloser: dec, src: src, max: maxRatio}, nil
+}
+
+type countReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// ratioReader fails when the decompressed bytes outgrow max times the
+// compressed ones, past a first 64KB
+type ratioReader struct {
+	io.ReadCloser
+	src *countReader
+	n   int64
+	max int64
+}
+
+func (r *ratioReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.n += int64(n)
+	if r.n > 64<<10 && r.n > r.max*r.src.n {
+		return n, ErrDecompressionRatio
+	}
+	return n, err
+}
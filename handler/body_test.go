@@ -0,0 +1,162 @@
+package handler
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestBodyLimit(t *testing.T) {
+	mid := BodyLimit(BodyConfig{MaxBytes: 10, Routes: map[string]int64{"PUT /upload": 0}})
+	var readErr error
+	h := mid(func(w http.ResponseWriter, r *http.Request) {
+		_, readErr = io.ReadAll(r.Body)
+	})
+	serve := func(method, pattern string, body io.Reader, length int64) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, pattern, body)
+		r.ContentLength = length
+		r = SetValue(r, RouteCtxKey, pattern)
+		w := httptest.NewRecorder()
+		readErr = nil
+		h(w, r)
+		return w
+	}
+	w := serve(http.MethodPost, "/items", strings.NewReader(strings.Repeat("a", 11)), 11)
+	if w.Code != http.StatusRequestEntityTooLarge || !strings.Contains(w.Body.String(), `"error"`) {
+		t.Errorf("expected a 413 envelope got %d %s", w.Code, w.Body.String())
+	}
+	// chunked bodies fail on read
+	serve(http.MethodPost, "/items", strings.NewReader(strings.Repeat("a", 11)), -1)
+	var maxErr *http.MaxBytesError
+	if !errors.As(readErr, &maxErr) {
+		t.Errorf("expected a MaxBytesError got %v", readErr)
+	}
+	if serve(http.MethodPost, "/items", strings.NewReader("small"), 5); readErr != nil {
+		t.Errorf("expected a small body read got %v", readErr)
+	}
+	if w := serve(http.MethodPut, "/upload", strings.NewReader(strings.Repeat("a", 100)), 100); w.Code != http.StatusOK || readErr != nil {
+		t.Errorf("expected no limit of the route got %d %v", w.Code, readErr)
+	}
+}
+
+func TestBodyDecompress(t *testing.T) {
+	payload := []byte(`{"name":"gtea"}`)
+	encode := map[string]func(io.Writer) io.WriteCloser{
+		"gzip":    func(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) },
+		"deflate": func(w io.Writer) io.WriteCloser { return zlib.NewWriter(w) },
+		"zstd": func(w io.Writer) io.WriteCloser {
+			z, _ := zstd.NewWriter(w)
+			return z
+		},
+	}
+	compress := func(enc string, data []byte) []byte {
+		var buf bytes.Buffer
+		zw := encode[enc](&buf)
+		zw.Write(data)
+		zw.Close()
+		return buf.Bytes()
+	}
+	var (
+		got     []byte
+		readErr error
+	)
+	h := BodyLimit(BodyConfig{MaxBytes: 1 << 20, Decompress: true, MaxRatio: 10})(
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Content-Encoding") != "" {
+				t.Error("expected the Content-Encoding removed")
+			}
+			got, readErr = io.ReadAll(r.Body)
+		})
+	serve := func(enc string, body []byte) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+		r.Header.Set("Content-Encoding", enc)
+		w := httptest.NewRecorder()
+		got, readErr = nil, nil
+		h(w, r)
+		return w
+	}
+	for enc := range encode {
+		if serve(enc, compress(enc, payload)); readErr != nil || !bytes.Equal(got, payload) {
+			t.Errorf("expected the %s body decoded got %q %v", enc, got, readErr)
+		}
+	}
+	// a bomb of zeros stops at the ratio before the size limit
+	serve("gzip", compress("gzip", make([]byte, 1<<20)))
+	if !errors.Is(readErr, ErrDecompressionRatio) || len(got) >= 1<<20 {
+		t.Errorf("expected the ratio exceeded got %d bytes %v", len(got), readErr)
+	}
+	if w := serve("br", payload); w.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("expected 415 for an unknown encoding got %d", w.Code)
+	}
+	if w := serve("gzip", payload); w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for a bad gzip body got %d", w.Code)
+	}
+}
+
+func TestBodyZstdWindow(t *testing.T) {
+	// magic, no content size, a 512MB window and an empty last block
+	frame := []byte{0x28, 0xb5, 0x2f, 0xfd, 0x00, 19 << 3, 0x01, 0x00, 0x00}
+	var readErr error
+	h := BodyLimit(BodyConfig{MaxBytes: 1 << 20, Decompress: true})(
+		func(w http.ResponseWriter, r *http.Request) {
+			_, readErr = io.ReadAll(r.Body)
+		})
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	r := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(frame))
+	r.Header.Set("Content-Encoding", "zstd")
+	w := httptest.NewRecorder()
+	h(w, r)
+	runtime.ReadMemStats(&after)
+	if w.Code == http.StatusOK && readErr == nil {
+		t.Error("expected the large window rejected")
+	}
+	if n := after.TotalAlloc - before.TotalAlloc; n > 32<<20 {
+		t.Errorf("expected no window allocated got %d bytes", n)
+	}
+}
+
+func TestBodyTooLargeEnvelope(t *testing.T) {
+	h := BodyLimit(BodyConfig{MaxBytes: 10})(func(w http.ResponseWriter, r *http.Request) {
+		var v map[string]any
+		if err := ReadJSON(r, &v); err != nil {
+			BadRequestErr(w, err)
+		}
+	})
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"name":"a long name"}`))
+	r.ContentLength = -1
+	w := httptest.NewRecorder()
+	h(w, r)
+	if w.Code != http.StatusRequestEntityTooLarge ||
+		w.Body.String() != `{"error":"body must not be larger than 10 bytes"}` {
+		t.Errorf("expected the 413 envelope got %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestReadMaxJSON(t *testing.T) {
+	read := func(body string) error {
+		var v struct{ Name string }
+		r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		return ReadMaxJSON(httptest.NewRecorder(), r, &v, 16)
+	}
+	if err := read(`{"name":"a"}`); err != nil {
+		t.Errorf("expected a valid body read got %v", err)
+	}
+	if err := read(`{"name":`); err == nil {
+		t.Error("expected the decode error returned")
+	}
+	var maxErr *http.MaxBytesError
+	if err := read(`{"name":"a long name"}`); !errors.As(err, &maxErr) ||
+		err.Error() != "body must not be larger than 16 bytes" {
+		t.Errorf("expected a too large error got %v", err)
+	}
+}
@@ -0,0 +1,358 @@
+package handler
+
+import (
+	"bufio"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// CompressConfig of the Compress middleware
+type CompressConfig struct {
+	// Encodings supported in order of preference when the client
+	// accepts several with the same q-value, default zstd, gzip,
+	// deflate
+	Encodings []string
+	// Level of the encoders, 1 fastest to 9 best, default the default
+	// level of every encoder
+	Level int
+	// MinSize of the compressed responses, default 1024 bytes.
+	// Responses flushed before reaching it are compressed anyway.
+	MinSize int
+	// Types of the compressed responses, "text/*" matches every text
+	// type, default text, json, javascript, xml, svg and wasm types
+	Types []string
+}
+
+// DefaultCompressTypes of CompressConfig.Types
+var DefaultCompressTypes = []string{
+	"text/*",
+	"application/json",
+	"application/x-ndjson",
+	"application/problem+json",
+	"application/ld+json",
+	"application/manifest+json",
+	"application/javascript",
+	"application/xml",
+	"application/wasm",
+	"image/svg+xml",
+}
+
+// encoder is implemented by the gzip, zlib and zstd writers
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+	Reset(io.Writer)
+}
+
+// Compress returns a middleware compressing the responses with the
+// encoding of the Accept-Encoding q-values. Responses already encoded,
+// partial or of other types are sent as is. A strong ETag is made weak
+// when compressing, If-None-Match still matches it. The Flusher and
+// Hijacker of the ResponseWriter are passed through.
+func Compress(conf CompressConfig) Middleware {
+	if len(conf.Encodings) == 0 {
+		conf.Encodings = []string{"zstd", "gzip", "deflate"}
+	}
+	if conf.MinSize <= 0 {
+		conf.MinSize = 1024
+	}
+	if len(conf.Types) == 0 {
+		conf.Types = DefaultCompressTypes
+	}
+	pools := make(map[string]*sync.Pool, len(conf.Encodings))
+	for _, enc := range conf.Encodings {
+		pools[enc] = encoderPool(enc, conf.Level)
+	}
+	mid := func(next http.HandlerFunc) http.HandlerFunc {
+		middle := func(w http.ResponseWriter, r *http.Request) {
+			addVary(w.Header())
+			enc := negotiateEncoding(r.Header.Values("Accept-Encoding"), conf.Encodings)
+			if enc == "" || r.Method == http.MethodHead {
+				next(w, r)
+				return
+			}
+			cw := &compressWriter{w: w, conf: &conf, enc: enc, pool: pools[enc]}
+			defer cw.close()
+			next(cw, r)
+		}
+		return middle
+	}
+	return mid
+}
+
+// GzipMiddleware good for serving static html/js/css file
+func GzipMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return gzipMiddleware(next)
+}
+
+var gzipMiddleware = Compress(CompressConfig{Encodings: []string{"gzip"}})
+
+func encoderPool(enc string, level int) *sync.Pool {
+	var newEncoder func() encoder
+	switch enc {
+	case "gzip":
+		if level == 0 {
+			level = gzip.DefaultCompression
+		}
+		if _, err := gzip.NewWriterLevel(io.Discard, level); err != nil {
+			panic(err)
+		}
+		newEncoder = func() encoder {
+			w, _ := gzip.NewWriterLevel(io.Discard, level)
+			return w
+		}
+	case "deflate":
+		if level == 0 {
+			level = zlib.DefaultCompression
+		}
+		if _, err := zlib.NewWriterLevel(io.Discard, level); err != nil {
+			panic(err)
+		}
+		newEncoder = func() encoder {
+			w, _ := zlib.NewWriterLevel(io.Discard, level)
+			return w
+		}
+	case "zstd":
+		opts := []zstd.EOption{zstd.WithEncoderConcurrency(1)}
+		if level != 0 {
+			opts = append(opts, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+		}
+		newEncoder = func() encoder {
+			w, _ := zstd.NewWriter(nil, opts...)
+			return w
+		}
+	default:
+		panic("handler: unsupported compression encoding " + strconv.Quote(enc))
+	}
+	return &sync.Pool{New: func() any { return newEncoder() }}
+}
+
+// negotiateEncoding returns the supported encoding of the highest
+// q-value, ties broken by the supported order, "" for none
+func negotiateEncoding(accept []string, supported []string) string {
+	q := make(map[string]float64)
+	for _, v := range accept {
+		for _, part := range strings.Split(v, ",") {
+			name, params, _ := strings.Cut(part, ";")
+			name = strings.ToLower(strings.TrimSpace(name))
+			if name == "" {
+				continue
+			}
+			if name == "x-gzip" {
+				name = "gzip"
+			}
+			value := 1.0
+			for _, p := range strings.Split(params, ";") {
+				k, v, ok := strings.Cut(strings.TrimSpace(p), "=")
+				if ok && strings.EqualFold(k, "q") {
+					if f, err := strconv.ParseFloat(v, 64); err == nil {
+						value = f
+					}
+				}
+			}
+			q[name] = value
+		}
+	}
+	best, bestQ := "", 0.0
+	for _, enc := range supported {
+		v, ok := q[enc]
+		if !ok {
+			v = q["*"]
+		}
+		if v > bestQ {
+			best, bestQ = enc, v
+		}
+	}
+	return best
+}
+
+func addVary(h http.Header) {
+	for _, v := range h.Values("Vary") {
+		for _, f := range strings.Split(v, ",") {
+			f = strings.TrimSpace(f)
+			if f == "*" || strings.EqualFold(f, "Accept-Encoding") {
+				return
+			}
+		}
+	}
+	h.Add("Vary", "Accept-Encoding")
+}
+
+// compressWriter buffers MinSize bytes of the body before deciding to
+// compress it
+type compressWriter struct {
+	w    http.ResponseWriter
+	conf *CompressConfig
+	enc  string
+	pool *sync.Pool
+
+	status      int
+	wroteHeader bool
+	decided     bool
+	hijacked    bool
+	buf         []byte
+	zw          encoder
+}
+
+func (cw *compressWriter) Header() http.Header {
+	return cw.w.Header()
+}
+
+func (cw *compressWriter) WriteHeader(status int) {
+	if cw.wroteHeader || cw.hijacked {
+		return
+	}
+	if status >= 100 && status < 200 && status != http.StatusSwitchingProtocols {
+		cw.w.WriteHeader(status)
+		return
+	}
+	cw.status, cw.wroteHeader = status, true
+	if !bodyAllowed(status) {
+		cw.decide(false)
+	}
+}
+
+func (cw *compressWriter) Write(b []byte) (int, error) {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.decided {
+		if cw.zw != nil {
+			return cw.zw.Write(b)
+		}
+		return cw.w.Write(b)
+	}
+	cw.buf = append(cw.buf, b...)
+	if len(cw.buf) >= cw.conf.MinSize {
+		if err := cw.decide(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+// decide whether to compress, write the header and the buffered body
+func (cw *compressWriter) decide(big bool) error {
+	cw.decided = true
+	h := cw.w.Header()
+	if cw.compressible(big) {
+		h.Set("Content-Encoding", cw.enc)
+		h.Del("Content-Length")
+		h.Del("Accept-Ranges")
+		if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			h.Set("ETag", "W/"+etag)
+		}
+		addVary(h)
+		cw.zw = cw.pool.Get().(encoder)
+		cw.zw.Reset(cw.w)
+	}
+	cw.w.WriteHeader(cw.status)
+	if len(cw.buf) == 0 {
+		return nil
+	}
+	var err error
+	if cw.zw != nil {
+		_, err = cw.zw.Write(cw.buf)
+	} else {
+		_, err = cw.w.Write(cw.buf)
+	}
+	cw.buf = nil
+	return err
+}
+
+func (cw *compressWriter) compressible(big bool) bool {
+	h := cw.w.Header()
+	if !bodyAllowed(cw.status) || cw.status == http.StatusPartialContent ||
+		h.Get("Content-Encoding") != "" || h.Get("Content-Range") != "" {
+		return false
+	}
+	if cl := h.Get("Content-Length"); cl != "" {
+		if n, err := strconv.Atoi(cl); err == nil && n < cw.conf.MinSize {
+			return false
+		}
+	} else if !big {
+		return false
+	}
+	ct := h.Get("Content-Type")
+	if ct == "" && len(cw.buf) > 0 {
+		ct = http.DetectContentType(cw.buf)
+		h.Set("Content-Type", ct)
+	}
+	mt, _, _ := strings.Cut(ct, ";")
+	mt = strings.ToLower(strings.TrimSpace(mt))
+	for _, t := range cw.conf.Types {
+		if t == mt || strings.HasSuffix(t, "/*") && strings.HasPrefix(mt, t[:len(t)-1]) {
+			return true
+		}
+	}
+	return false
+}
+
+func bodyAllowed(status int) bool {
+	return status >= 200 && status != http.StatusNoContent && status != http.StatusNotModified
+}
+
+// Flush for http.Flusher, a flush before MinSize compresses the
+// stream anyway
+func (cw *compressWriter) Flush() {
+	cw.FlushError()
+}
+
+// FlushError for http.ResponseController
+func (cw *compressWriter) FlushError() error {
+	if cw.hijacked {
+		return nil
+	}
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if !cw.decided {
+		if err := cw.decide(true); err != nil {
+			return err
+		}
+	}
+	if cw.zw != nil {
+		if err := cw.zw.Flush(); err != nil {
+			return err
+		}
+	}
+	return http.NewResponseController(cw.w).Flush()
+}
+
+// Hijack for http.Hijacker
+func (cw *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := http.NewResponseController(cw.w).Hijack()
+	if err == nil {
+		cw.hijacked = true
+	}
+	return conn, rw, err
+}
+
+// Unwrap for http.ResponseController
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.w
+}
+
+// close write the rest of the body and put the encoder back
+func (cw *compressWriter) close() {
+	if cw.hijacked {
+		return
+	}
+	if !cw.decided && cw.wroteHeader {
+		cw.decide(false)
+	}
+	if cw.zw != nil {
+		cw.zw.Close()
+		cw.zw.Reset(io.Discard)
+		cw.pool.Put(cw.zw)
+		cw.zw = nil
+	}
+}
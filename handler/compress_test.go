@@ -0,0 +1,141 @@
+package handler
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	supported := []string{"zstd", "gzip", "deflate"}
+	cases := map[string]string{
+		"":                              "",
+		"gzip":                          "gzip",
+		"gzip, deflate, br, zstd":       "zstd",
+		"gzip;q=1.0, zstd;q=0.5":        "gzip",
+		"x-gzip":                        "gzip",
+		"*":                             "zstd",
+		"*;q=0.1, gzip;q=0.5, zstd;q=0": "gzip",
+		"br":                            "",
+		"identity":                      "",
+		"gzip;q=0":                      "",
+	}
+	for accept, expect := range cases {
+		if got := negotiateEncoding([]string{accept}, supported); got != expect {
+			t.Errorf("%q: expected %q got %q", accept, expect, got)
+		}
+	}
+}
+
+func TestCompress(t *testing.T) {
+	big := strings.Repeat(`{"name":"gtea"}`, 200)
+	h := Compress(CompressConfig{})(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/json":
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("ETag", `"v1"`)
+			w.Write([]byte(big))
+		case "/small":
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{}`))
+		case "/image":
+			w.Header().Set("Content-Type", "image/png")
+			w.Write([]byte(big))
+		case "/encoded":
+			w.Header().Set("Content-Type", "text/plain")
+			w.Header().Set("Content-Encoding", "br")
+			w.Write([]byte(big))
+		case "/sniffed":
+			w.Write([]byte("<html>" + big))
+		case "/not-modified":
+			w.WriteHeader(http.StatusNotModified)
+		}
+	})
+	serve := func(path, accept string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		r.Header.Set("Accept-Encoding", accept)
+		w := httptest.NewRecorder()
+		h(w, r)
+		return w
+	}
+
+	w := serve("/json", "gzip, deflate")
+	if w.Header().Get("Content-Encoding") != "gzip" || w.Header().Get("Vary") != "Accept-Encoding" ||
+		w.Header().Get("ETag") != `W/"v1"` {
+		t.Fatalf("expected a gzip response got %v", w.Header())
+	}
+	gz, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := io.ReadAll(gz); string(b) != big {
+		t.Error("unexpected gzip body")
+	}
+
+	w = serve("/json", "zstd")
+	zr, err := zstd.NewReader(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zr.Close()
+	if b, _ := io.ReadAll(zr); string(b) != big || w.Header().Get("Content-Encoding") != "zstd" {
+		t.Errorf("unexpected zstd response %v", w.Header())
+	}
+
+	for _, path := range []string{"/small", "/image"} {
+		w = serve(path, "gzip")
+		if w.Header().Get("Content-Encoding") != "" || w.Header().Get("Vary") != "Accept-Encoding" {
+			t.Errorf("expected %s sent as is with Vary got %v", path, w.Header())
+		}
+	}
+	if w = serve("/encoded", "gzip"); w.Header().Get("Content-Encoding") != "br" || w.Body.String() != big {
+		t.Errorf("expected an encoded response untouched got %v", w.Header())
+	}
+	if w = serve("/sniffed", "gzip"); w.Header().Get("Content-Encoding") != "gzip" ||
+		!strings.HasPrefix(w.Header().Get("Content-Type"), "text/html") {
+		t.Errorf("expected a sniffed html response compressed got %v", w.Header())
+	}
+	if w = serve("/not-modified", "gzip"); w.Code != http.StatusNotModified || w.Header().Get("Content-Encoding") != "" {
+		t.Errorf("expected a bare 304 got %d %v", w.Code, w.Header())
+	}
+	if w = serve("/json", ""); w.Header().Get("Content-Encoding") != "" || w.Body.String() != big {
+		t.Errorf("expected no compression without Accept-Encoding got %v", w.Header())
+	}
+}
+
+func TestCompressHijack(t *testing.T) {
+	h := Compress(CompressConfig{})(func(w http.ResponseWriter, r *http.Request) {
+		conn, rw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: test\r\nConnection: Upgrade\r\n\r\nhello")
+		rw.Flush()
+	})
+	ts := httptest.NewServer(h)
+	defer ts.Close()
+	req, _ := http.NewRequest(http.MethodGet, ts.URL, nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "test")
+	res, err := http.DefaultTransport.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("expected the hijacked connection got %d", res.StatusCode)
+	}
+	line, _ := bufio.NewReader(res.Body).ReadString('o')
+	if line != "hello" {
+		t.Errorf("unexpected hijacked data %q", line)
+	}
+}
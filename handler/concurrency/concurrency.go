@@ -0,0 +1,195 @@
+// Package concurrency caps the in flight requests with a bounded wait
+// queue, and sheds load when the latency passes a target like CoDel.
+package concurrency
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/datewu/gtea/handler"
+)
+
+// Config of a Limiter
+type Config struct {
+	// Limit of the in flight requests, 0 for no limit
+	Limit int
+	// Queue is the number of requests waiting for a slot, the others
+	// are rejected at once
+	Queue int
+	// Timeout of a request in the queue, default 1s
+	Timeout time.Duration
+	// RetryAfter header of rejected requests, default 1s
+	RetryAfter time.Duration
+	// Target latency of the adaptive mode, 0 disables it. When the
+	// minimum latency of an Interval passes it, the queue is bypassed
+	// and requests are shed at an increasing rate until it drops back.
+	Target time.Duration
+	// Interval of the latency measures, default 100ms
+	Interval time.Duration
+	// Rejected responds to shed requests after the Retry-After header
+	// is set, default a 503 json error
+	Rejected http.HandlerFunc
+}
+
+// Limiter caps the in flight requests
+type Limiter struct {
+	conf   Config
+	slots  chan struct{}
+	queued atomic.Int64
+
+	mu          sync.Mutex
+	windowStart time.Time
+	windowMin   time.Duration
+	dropping    bool
+	drops       int
+	dropNext    time.Time
+}
+
+// now is the clock of the adaptive mode
+var now = time.Now
+
+// New returns a Limiter
+func New(conf Config) *Limiter {
+	if conf.Timeout <= 0 {
+		conf.Timeout = time.Second
+	}
+	if conf.RetryAfter <= 0 {
+		conf.RetryAfter = time.Second
+	}
+	if conf.Interval <= 0 {
+		conf.Interval = 100 * time.Millisecond
+	}
+	if conf.Rejected == nil {
+		conf.Rejected = func(w http.ResponseWriter, _ *http.Request) {
+			handler.ServiceUnavailable(w)
+		}
+	}
+	l := &Limiter{conf: conf, windowStart: now(), windowMin: math.MaxInt64}
+	if conf.Limit > 0 {
+		l.slots = make(chan struct{}, conf.Limit)
+	}
+	return l
+}
+
+// InFlight returns the number of requests holding a slot
+func (l *Limiter) InFlight() int {
+	return len(l.slots)
+}
+
+// Queued returns the number of requests waiting for a slot
+func (l *Limiter) Queued() int {
+	return int(l.queued.Load())
+}
+
+// Observe is the handler.MetricsHook of the adaptive mode, a Metrics
+// middleware inside the Limiter one must call it:
+//
+//	g.Use(lim.Middleware, handler.Metrics(lim.Observe))
+func (l *Limiter) Observe(_ *http.Request, latency time.Duration) {
+	if l.conf.Target <= 0 {
+		return
+	}
+	t := now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if latency < l.windowMin {
+		l.windowMin = latency
+	}
+	if t.Sub(l.windowStart) < l.conf.Interval {
+		return
+	}
+	// a good request of the interval means the queue drained, CoDel
+	// only reacts to a standing latency
+	overloaded := l.windowMin > l.conf.Target
+	l.windowStart, l.windowMin = t, math.MaxInt64
+	switch {
+	case overloaded && !l.dropping:
+		l.dropping, l.drops, l.dropNext = true, 0, t
+	case !overloaded:
+		l.dropping = false
+	}
+}
+
+// shedding reports whether the adaptive mode is dropping, and whether
+// r is dropped by the control law, every Interval/sqrt(drops)
+func (l *Limiter) shedding() (dropping, drop bool) {
+	if l.conf.Target <= 0 {
+		return false, false
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.dropping {
+		return false, false
+	}
+	t := now()
+	// no measure for a whole interval, nothing is going through
+	if t.Sub(l.windowStart) > 2*l.conf.Interval {
+		l.dropping = false
+		return false, false
+	}
+	if t.Before(l.dropNext) {
+		return true, false
+	}
+	l.drops++
+	l.dropNext = t.Add(time.Duration(float64(l.conf.Interval) / math.Sqrt(float64(l.drops))))
+	return true, true
+}
+
+// acquire a slot, waiting in the queue unless dropping
+func (l *Limiter) acquire(r *http.Request) bool {
+	dropping, drop := l.shedding()
+	if drop {
+		return false
+	}
+	if l.slots == nil {
+		return true
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	default:
+	}
+	if dropping {
+		return false
+	}
+	if l.queued.Add(1) > int64(l.conf.Queue) {
+		l.queued.Add(-1)
+		return false
+	}
+	defer l.queued.Add(-1)
+	timer := time.NewTimer(l.conf.Timeout)
+	defer timer.Stop()
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	case <-timer.C:
+		return false
+	case <-r.Context().Done():
+		return false
+	}
+}
+
+func (l *Limiter) release() {
+	if l.slots != nil {
+		<-l.slots
+	}
+}
+
+// Middleware rejects the requests without a slot with a 503 and the
+// Retry-After header
+func (l *Limiter) Middleware(next http.HandlerFunc) http.HandlerFunc {
+	middle := func(w http.ResponseWriter, r *http.Request) {
+		if !l.acquire(r) {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(l.conf.RetryAfter.Seconds()))))
+			l.conf.Rejected(w, r)
+			return
+		}
+		defer l.release()
+		next(w, r)
+	}
+	return middle
+}
@@ -0,0 +1,138 @@
+package concurrency
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLimitAndQueue(t *testing.T) {
+	l := New(Config{Limit: 1, Queue: 1, Timeout: time.Second, RetryAfter: 2 * time.Second})
+	release := make(chan struct{})
+	h := l.Middleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			<-release
+		}
+	})
+	serve := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+	var wg sync.WaitGroup
+	codes := make(chan int, 2)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		codes <- serve("/slow").Code
+	}()
+	waitFor(t, func() bool { return l.InFlight() == 1 })
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		codes <- serve("/fast").Code
+	}()
+	waitFor(t, func() bool { return l.Queued() == 1 })
+
+	w := serve("/fast")
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") != "2" {
+		t.Errorf("expected 503 with Retry-After when the queue is full got %d %v", w.Code, w.Header())
+	}
+	close(release)
+	wg.Wait()
+	close(codes)
+	for c := range codes {
+		if c != http.StatusOK {
+			t.Errorf("expected the running and queued requests served got %d", c)
+		}
+	}
+	if l.InFlight() != 0 || l.Queued() != 0 {
+		t.Errorf("expected the slots released got %d in flight %d queued", l.InFlight(), l.Queued())
+	}
+}
+
+func TestQueueTimeout(t *testing.T) {
+	l := New(Config{Limit: 1, Queue: 5, Timeout: 20 * time.Millisecond})
+	release := make(chan struct{})
+	defer close(release)
+	h := l.Middleware(func(w http.ResponseWriter, r *http.Request) { <-release })
+	go h(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	waitFor(t, func() bool { return l.InFlight() == 1 })
+	start := time.Now()
+	w := httptest.NewRecorder()
+	l.Middleware(func(http.ResponseWriter, *http.Request) {})(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusServiceUnavailable || time.Since(start) < 20*time.Millisecond {
+		t.Errorf("expected 503 after the queue timeout got %d in %s", w.Code, time.Since(start))
+	}
+}
+
+func TestAdaptive(t *testing.T) {
+	clock := time.Unix(1700000000, 0)
+	now = func() time.Time { return clock }
+	defer func() { now = time.Now }()
+
+	l := New(Config{Target: 10 * time.Millisecond, Interval: 100 * time.Millisecond})
+	h := l.Middleware(func(http.ResponseWriter, *http.Request) {})
+	serve := func() int {
+		w := httptest.NewRecorder()
+		h(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		return w.Code
+	}
+	// one fast request of the interval, no standing latency
+	for _, ms := range []int{50, 5, 50} {
+		clock = clock.Add(50 * time.Millisecond)
+		l.Observe(nil, time.Duration(ms)*time.Millisecond)
+	}
+	if code := serve(); code != http.StatusOK {
+		t.Fatalf("expected no shedding got %d", code)
+	}
+	for i := 0; i < 3; i++ {
+		clock = clock.Add(50 * time.Millisecond)
+		l.Observe(nil, 50*time.Millisecond)
+	}
+	if code := serve(); code != http.StatusServiceUnavailable {
+		t.Fatalf("expected the first drop got %d", code)
+	}
+	if code := serve(); code != http.StatusOK {
+		t.Errorf("expected the control law to wait before the next drop got %d", code)
+	}
+	clock = clock.Add(100 * time.Millisecond)
+	l.Observe(nil, 50*time.Millisecond)
+	if code := serve(); code != http.StatusServiceUnavailable {
+		t.Errorf("expected the second drop got %d", code)
+	}
+	// next drop after Interval/sqrt(2)
+	clock = clock.Add(60 * time.Millisecond)
+	l.Observe(nil, 50*time.Millisecond)
+	if code := serve(); code != http.StatusOK {
+		t.Errorf("expected no drop before the next one got %d", code)
+	}
+	clock = clock.Add(20 * time.Millisecond)
+	l.Observe(nil, 50*time.Millisecond)
+	if code := serve(); code != http.StatusServiceUnavailable {
+		t.Errorf("expected the third drop got %d", code)
+	}
+	// the latency drops back under the target
+	for i := 0; i < 3; i++ {
+		clock = clock.Add(50 * time.Millisecond)
+		l.Observe(nil, time.Millisecond)
+	}
+	for i := 0; i < 3; i++ {
+		if code := serve(); code != http.StatusOK {
+			t.Errorf("expected shedding to stop got %d", code)
+		}
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
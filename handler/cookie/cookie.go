@@ -0,0 +1,284 @@
+// Package cookie signs or encrypts cookie values with a rotating
+// keyring and writes them with safe attributes.
+//
+// The codec is built on crypto/hmac and crypto/cipher rather than
+// github.com/datewu/security: the helpers of that module are hex
+// encoding and tagged MD5 digests, which are fine for the captcha
+// answers but neither a MAC nor an AEAD, and cookies need both keyed
+// HMAC-SHA256 signatures and AES-GCM sealing.
+package cookie
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// codec errors
+var (
+	ErrInvalid  = errors.New("cookie: invalid or tampered value")
+	ErrExpired  = errors.New("cookie: expired value")
+	ErrTooLarge = errors.New("cookie: value too large")
+	ErrShortKey = errors.New("cookie: keys must be at least 32 bytes")
+)
+
+const maxCookieSize = 4096
+
+var b64 = base64.RawURLEncoding
+
+type derived struct {
+	mac  []byte
+	aead cipher.AEAD
+}
+
+// Keyring holds the keys of a Codec, the first key signs and
+// encrypts, all keys are tried to verify and decrypt, so a new key is
+// added in front and old keys are dropped once their cookies expired.
+type Keyring struct {
+	keys []derived
+}
+
+// NewKeyring from the current key followed by previous keys
+func NewKeyring(current []byte, previous ...[]byte) (*Keyring, error) {
+	k := &Keyring{}
+	for _, key := range append([][]byte{current}, previous...) {
+		if len(key) < 32 {
+			return nil, ErrShortKey
+		}
+		enc := deriveKey(key, "gtea cookie encrypt")
+		block, err := aes.NewCipher(enc)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		k.keys = append(k.keys, derived{mac: deriveKey(key, "gtea cookie sign"), aead: aead})
+	}
+	return k, nil
+}
+
+// GenerateKey returns a random 32 bytes key
+func GenerateKey() ([]byte, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	return b, err
+}
+
+func deriveKey(key []byte, purpose string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil)
+}
+
+// Options are the cookie attributes and the codec mode
+type Options struct {
+	Encrypt     bool          // AEAD encrypt instead of only HMAC sign
+	MaxAge      time.Duration // value and cookie lifetime, 0 a session cookie never expiring
+	Path        string        // default "/"
+	Domain      string        // default host only
+	Secure      bool          // forced for SameSite=None, Partitioned and __Host-/__Secure- names
+	HTTPOnly    bool
+	SameSite    http.SameSite // default Lax
+	Partitioned bool          // CHIPS, requires Secure
+}
+
+// Codec encodes cookie values
+type Codec struct {
+	keys *Keyring
+	opts Options
+	now  func() time.Time
+}
+
+// New returns a Codec
+func New(keys *Keyring, opts Options) *Codec {
+	if opts.Path == "" {
+		opts.Path = "/"
+	}
+	if opts.SameSite == 0 || opts.SameSite == http.SameSiteDefaultMode {
+		opts.SameSite = http.SameSiteLaxMode
+	}
+	if opts.SameSite == http.SameSiteNoneMode || opts.Partitioned {
+		opts.Secure = true
+	}
+	return &Codec{keys: keys, opts: opts, now: time.Now}
+}
+
+// Encode bind value to the cookie name and the current time
+func (c *Codec) Encode(name string, value []byte) (string, error) {
+	plain := make([]byte, 8+len(value))
+	binary.BigEndian.PutUint64(plain, uint64(c.now().Unix()))
+	copy(plain[8:], value)
+	key := c.keys.keys[0]
+	var out string
+	if c.opts.Encrypt {
+		nonce := make([]byte, key.aead.NonceSize())
+		if _, err := rand.Read(nonce); err != nil {
+			return "", err
+		}
+		sealed := key.aead.Seal(nonce, nonce, plain, []byte(name))
+		out = b64.EncodeToString(sealed)
+	} else {
+		out = b64.EncodeToString(plain) + "." + b64.EncodeToString(sign(key.mac, name, plain))
+	}
+	if len(name)+len(out) > maxCookieSize {
+		return "", ErrTooLarge
+	}
+	return out, nil
+}
+
+func sign(key []byte, name string, plain []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(name))
+	mac.Write([]byte{0})
+	mac.Write(plain)
+	return mac.Sum(nil)
+}
+
+// Decode verify an encoded value of the cookie name with every key
+func (c *Codec) Decode(name, encoded string) ([]byte, error) {
+	var plain []byte
+	if c.opts.Encrypt {
+		sealed, err := b64.DecodeString(encoded)
+		if err != nil {
+			return nil, ErrInvalid
+		}
+		for _, k := range c.keys.keys {
+			ns := k.aead.NonceSize()
+			if len(sealed) < ns {
+				return nil, ErrInvalid
+			}
+			p, err := k.aead.Open(nil, sealed[:ns], sealed[ns:], []byte(name))
+			if err == nil {
+				plain = p
+				break
+			}
+		}
+	} else {
+		data, sig, ok := strings.Cut(encoded, ".")
+		if !ok {
+			return nil, ErrInvalid
+		}
+		p, err := b64.DecodeString(data)
+		if err != nil {
+			return nil, ErrInvalid
+		}
+		mac, err := b64.DecodeString(sig)
+		if err != nil {
+			return nil, ErrInvalid
+		}
+		for _, k := range c.keys.keys {
+			if hmac.Equal(mac, sign(k.mac, name, p)) {
+				plain = p
+				break
+			}
+		}
+	}
+	if len(plain) < 8 {
+		return nil, ErrInvalid
+	}
+	if c.opts.MaxAge > 0 {
+		issued := time.Unix(int64(binary.BigEndian.Uint64(plain)), 0)
+		if c.now().After(issued.Add(c.opts.MaxAge)) {
+			return nil, ErrExpired
+		}
+	}
+	return plain[8:], nil
+}
+
+func (c *Codec) cookie(name, value string) *http.Cookie {
+	ck := &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     c.opts.Path,
+		Domain:   c.opts.Domain,
+		Secure:   c.opts.Secure,
+		HttpOnly: c.opts.HTTPOnly,
+		SameSite: c.opts.SameSite,
+	}
+	if strings.HasPrefix(name, "__Secure-") {
+		ck.Secure = true
+	}
+	if strings.HasPrefix(name, "__Host-") {
+		ck.Secure = true
+		ck.Path = "/"
+		ck.Domain = ""
+	}
+	if c.opts.MaxAge > 0 {
+		ck.MaxAge = int(c.opts.MaxAge.Seconds())
+		ck.Expires = c.now().Add(c.opts.MaxAge).UTC()
+	}
+	return ck
+}
+
+// write add the Set-Cookie header, http.Cookie has no Partitioned
+// attribute before go1.23
+func (c *Codec) write(w http.ResponseWriter, ck *http.Cookie) {
+	v := ck.String()
+	if v == "" {
+		return
+	}
+	if c.opts.Partitioned {
+		v += "; Partitioned"
+	}
+	w.Header().Add("Set-Cookie", v)
+}
+
+// Set encode value and set the cookie
+func (c *Codec) Set(w http.ResponseWriter, name string, value []byte) error {
+	encoded, err := c.Encode(name, value)
+	if err != nil {
+		return err
+	}
+	c.write(w, c.cookie(name, encoded))
+	return nil
+}
+
+// Get decode the value of the request cookie name,
+// http.ErrNoCookie is returned if there is no such cookie.
+func (c *Codec) Get(r *http.Request, name string) ([]byte, error) {
+	ck, err := r.Cookie(name)
+	if err != nil {
+		return nil, err
+	}
+	return c.Decode(name, ck.Value)
+}
+
+// SetJSON set v marshaled as json
+func (c *Codec) SetJSON(w http.ResponseWriter, name string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.Set(w, name, data)
+}
+
+// GetJSON unmarshal the value of the cookie name into v
+func (c *Codec) GetJSON(r *http.Request, name string, v any) error {
+	data, err := c.Get(r, name)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return ErrInvalid
+	}
+	return nil
+}
+
+// Clear expire the cookie name
+func (c *Codec) Clear(w http.ResponseWriter, name string) {
+	ck := c.cookie(name, "")
+	ck.MaxAge = -1
+	ck.Expires = time.Unix(0, 0)
+	c.write(w, ck)
+}
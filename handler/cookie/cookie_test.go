@@ -0,0 +1,91 @@
+package cookie
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/datewu/gtea/handler"
+)
+
+func testKeyring(t *testing.T, keys ...[]byte) *Keyring {
+	k, err := NewKeyring(keys[0], keys[1:]...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+func TestCodec(t *testing.T) {
+	oldKey := bytes.Repeat([]byte("o"), 32)
+	newKey := bytes.Repeat([]byte("n"), 32)
+	for _, encrypt := range []bool{false, true} {
+		old := New(testKeyring(t, oldKey), Options{Encrypt: encrypt, MaxAge: time.Hour})
+		v, err := old.Encode("sid", []byte("hello"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if encrypt && strings.Contains(v, "aGVsbG8") {
+			t.Error("expected encrypted value")
+		}
+		rotated := New(testKeyring(t, newKey, oldKey), Options{Encrypt: encrypt, MaxAge: time.Hour})
+		got, err := rotated.Decode("sid", v)
+		if err != nil || string(got) != "hello" {
+			t.Errorf("expected rotated keyring to decode old value got %q %v", got, err)
+		}
+		if _, err := rotated.Decode("other", v); err != ErrInvalid {
+			t.Errorf("expected value bound to the name got %v", err)
+		}
+		tampered := []byte(v)
+		tampered[2] ^= 1
+		if _, err := rotated.Decode("sid", string(tampered)); err != ErrInvalid {
+			t.Errorf("expected tampered value rejected got %v", err)
+		}
+		fresh := New(testKeyring(t, newKey), Options{Encrypt: encrypt})
+		if _, err := fresh.Decode("sid", v); err != ErrInvalid {
+			t.Errorf("expected dropped key rejected got %v", err)
+		}
+		rotated.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+		if _, err := rotated.Decode("sid", v); err != ErrExpired {
+			t.Errorf("expected %v got %v", ErrExpired, err)
+		}
+	}
+	if _, err := NewKeyring([]byte("short")); err != ErrShortKey {
+		t.Errorf("expected %v got %v", ErrShortKey, err)
+	}
+}
+
+func TestAttributes(t *testing.T) {
+	c := New(testKeyring(t, bytes.Repeat([]byte("k"), 32)), Options{
+		SameSite: http.SameSiteNoneMode, Partitioned: true, HTTPOnly: true,
+	})
+	w := httptest.NewRecorder()
+	if err := c.SetJSON(w, "__Host-prefs", map[string]string{"theme": "dark"}); err != nil {
+		t.Fatal(err)
+	}
+	set := w.Header().Get("Set-Cookie")
+	for _, attr := range []string{"Path=/", "HttpOnly", "Secure", "SameSite=None", "Partitioned"} {
+		if !strings.Contains(set, attr) {
+			t.Errorf("expected %s in %q", attr, set)
+		}
+	}
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Cookie", strings.Split(set, ";")[0])
+	var prefs map[string]string
+	if err := c.GetJSON(r, "__Host-prefs", &prefs); err != nil || prefs["theme"] != "dark" {
+		t.Errorf("unexpected cookie value %v %v", prefs, err)
+	}
+}
+
+func TestSimpleCookie(t *testing.T) {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "https://example.com/", nil)
+	handler.SetSimpleCookie(w, r, "k", "v")
+	set := w.Header().Get("Set-Cookie")
+	if strings.Contains(set, "Domain") || !strings.Contains(set, "Secure") || !strings.Contains(set, "SameSite=Lax") {
+		t.Errorf("unexpected simple cookie %q", set)
+	}
+}
@@ -0,0 +1,207 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORSConfig of the CORS middleware
+type CORSConfig struct {
+	// TrustedOrigins allowed, "*" for any origin and
+	// "https://*.example.com" for the subdomains of example.com
+	TrustedOrigins []string
+	// OriginPatterns regexps matching the whole allowed origins
+	OriginPatterns []string
+	// AllowOrigin decides the origins not matched by the others
+	AllowOrigin func(r *http.Request, origin string) bool
+	// Methods allowed, default GET, HEAD, POST, PUT, PATCH and DELETE
+	Methods []string
+	// Headers allowed, "*" for any, default Accept, Authorization,
+	// Content-Type, X-CSRF-Token and X-Requested-With
+	Headers []string
+	// ExposedHeaders readable by the scripts
+	ExposedHeaders []string
+	// Credentials allow cookies and the Authorization header, the
+	// origin is then sent back, it can not be set with the "*" origin
+	Credentials bool
+	// MaxAge of the preflight responses in the browser cache, negative
+	// to disable the cache
+	MaxAge time.Duration
+	// PrivateNetwork allows public sites to call this server on a
+	// private network, the Private Network Access preflights
+	PrivateNetwork bool
+}
+
+// Enabled reports whether the config allows any origin
+func (c CORSConfig) Enabled() bool {
+	return len(c.TrustedOrigins) > 0 || len(c.OriginPatterns) > 0 || c.AllowOrigin != nil
+}
+
+// CORS returns a middleware answering the preflight requests and
+// adding the CORS headers to the responses of the allowed origins.
+// Preflights of other origins, methods or headers are rejected with
+// 403 and never reach the handlers.
+func CORS(conf CORSConfig) (Middleware, error) {
+	var (
+		anyOrigin bool
+		exact     = make(map[string]bool)
+		suffixes  [][2]string // scheme://, .domain
+		patterns  []*regexp.Regexp
+	)
+	for _, o := range conf.TrustedOrigins {
+		o = strings.ToLower(strings.TrimSuffix(o, "/"))
+		switch {
+		case o == "*":
+			if conf.Credentials {
+				return nil, errors.New(`cors: the "*" origin can not allow credentials`)
+			}
+			anyOrigin = true
+		case strings.Contains(o, "://*."):
+			scheme, host, _ := strings.Cut(o, "://*")
+			suffixes = append(suffixes, [2]string{scheme + "://", host})
+		default:
+			exact[o] = true
+		}
+	}
+	for _, p := range conf.OriginPatterns {
+		re, err := regexp.Compile("^(?:" + p + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("cors: invalid origin pattern %q: %w", p, err)
+		}
+		patterns = append(patterns, re)
+	}
+	if len(conf.Methods) == 0 {
+		conf.Methods = []string{http.MethodGet, http.MethodHead, http.MethodPost,
+			http.MethodPut, http.MethodPatch, http.MethodDelete}
+	}
+	if len(conf.Headers) == 0 {
+		conf.Headers = []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token", "X-Requested-With"}
+	}
+	anyHeader := false
+	headers := make(map[string]bool)
+	for _, h := range conf.Headers {
+		if h == "*" {
+			anyHeader = true
+		}
+		headers[strings.ToLower(h)] = true
+	}
+	methods := strings.Join(conf.Methods, ", ")
+	exposed := strings.Join(conf.ExposedHeaders, ", ")
+	maxAge := ""
+	switch {
+	case conf.MaxAge > 0:
+		maxAge = strconv.Itoa(int(conf.MaxAge.Seconds()))
+	case conf.MaxAge < 0:
+		maxAge = "0"
+	}
+
+	allowed := func(r *http.Request, origin string) bool {
+		o := strings.ToLower(origin)
+		if anyOrigin || exact[o] {
+			return true
+		}
+		for _, s := range suffixes {
+			if strings.HasPrefix(o, s[0]) && strings.HasSuffix(o, s[1]) && len(o) > len(s[0])+len(s[1]) {
+				return true
+			}
+		}
+		for _, re := range patterns {
+			if re.MatchString(origin) {
+				return true
+			}
+		}
+		return conf.AllowOrigin != nil && conf.AllowOrigin(r, origin)
+	}
+	allowOrigin := func(h http.Header, origin string) {
+		if anyOrigin {
+			h.Set("Access-Control-Allow-Origin", "*")
+		} else {
+			h.Set("Access-Control-Allow-Origin", origin)
+		}
+		if conf.Credentials {
+			h.Set("Access-Control-Allow-Credentials", "true")
+		}
+	}
+	methodAllowed := func(m string) bool {
+		for _, v := range conf.Methods {
+			if v == m {
+				return true
+			}
+		}
+		return false
+	}
+	headersAllowed := func(requested string) bool {
+		if anyHeader {
+			return true
+		}
+		for _, h := range strings.Split(requested, ",") {
+			if h = strings.ToLower(strings.TrimSpace(h)); h != "" && !headers[h] {
+				return false
+			}
+		}
+		return true
+	}
+
+	mid := func(next http.HandlerFunc) http.HandlerFunc {
+		cors := func(w http.ResponseWriter, r *http.Request) {
+			h := w.Header()
+			origin := r.Header.Get("Origin")
+			preflight := r.Method == http.MethodOptions && origin != "" &&
+				r.Header.Get("Access-Control-Request-Method") != ""
+			if preflight {
+				h.Add("Vary", "Origin")
+				h.Add("Vary", "Access-Control-Request-Method")
+				h.Add("Vary", "Access-Control-Request-Headers")
+				if conf.PrivateNetwork {
+					h.Add("Vary", "Access-Control-Request-Private-Network")
+				}
+				reqMethod := r.Header.Get("Access-Control-Request-Method")
+				reqHeaders := r.Header.Get("Access-Control-Request-Headers")
+				privateNetwork := r.Header.Get("Access-Control-Request-Private-Network") == "true"
+				if !allowed(r, origin) || !methodAllowed(reqMethod) ||
+					!headersAllowed(reqHeaders) || privateNetwork && !conf.PrivateNetwork {
+					errResponse(w, http.StatusForbidden, "cross origin request not allowed")
+					return
+				}
+				allowOrigin(h, origin)
+				h.Set("Access-Control-Allow-Methods", methods)
+				if reqHeaders != "" {
+					// the requested headers are all allowed
+					h.Set("Access-Control-Allow-Headers", reqHeaders)
+				}
+				if maxAge != "" {
+					h.Set("Access-Control-Max-Age", maxAge)
+				}
+				if privateNetwork {
+					h.Set("Access-Control-Allow-Private-Network", "true")
+				}
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			if !anyOrigin {
+				h.Add("Vary", "Origin")
+			}
+			if origin != "" && allowed(r, origin) {
+				allowOrigin(h, origin)
+				if exposed != "" {
+					h.Set("Access-Control-Expose-Headers", exposed)
+				}
+			}
+			next(w, r)
+		}
+		return cors
+	}
+	return mid, nil
+}
+
+// CORSMiddleware unblock trustedOrigins domains with the default
+// CORSConfig methods and headers
+func CORSMiddleware(trustedOrigins []string) Middleware {
+	mid, _ := CORS(CORSConfig{TrustedOrigins: trustedOrigins})
+	return mid
+}
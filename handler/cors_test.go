@@ -0,0 +1,100 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCORS(t *testing.T) {
+	mid, err := CORS(CORSConfig{
+		TrustedOrigins: []string{"https://app.example.com", "https://*.example.org"},
+		OriginPatterns: []string{`http://localhost:\d+`},
+		ExposedHeaders: []string{"X-Total"},
+		Credentials:    true,
+		MaxAge:         10 * time.Minute,
+		PrivateNetwork: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	reached := false
+	h := mid(func(w http.ResponseWriter, r *http.Request) { reached = true })
+	serve := func(method, origin string, headers map[string]string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, "/items", nil)
+		if origin != "" {
+			r.Header.Set("Origin", origin)
+		}
+		for k, v := range headers {
+			r.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		reached = false
+		h(w, r)
+		return w
+	}
+	preflight := func(origin, method, headers string) *httptest.ResponseRecorder {
+		return serve(http.MethodOptions, origin, map[string]string{
+			"Access-Control-Request-Method":  method,
+			"Access-Control-Request-Headers": headers,
+		})
+	}
+
+	for _, origin := range []string{"https://app.example.com", "https://a.b.example.org", "http://localhost:5173"} {
+		w := serve(http.MethodGet, origin, nil)
+		if !reached || w.Header().Get("Access-Control-Allow-Origin") != origin ||
+			w.Header().Get("Access-Control-Allow-Credentials") != "true" ||
+			w.Header().Get("Access-Control-Expose-Headers") != "X-Total" || w.Header().Get("Vary") != "Origin" {
+			t.Errorf("expected %s allowed got %v", origin, w.Header())
+		}
+	}
+	for _, origin := range []string{"https://evil.com", "https://example.org", "http://a.example.org", "http://localhost:80x"} {
+		w := serve(http.MethodGet, origin, nil)
+		if !reached || w.Header().Get("Access-Control-Allow-Origin") != "" {
+			t.Errorf("expected %s without CORS headers got %v", origin, w.Header())
+		}
+	}
+
+	w := preflight("https://app.example.com", http.MethodPost, "Content-Type, X-CSRF-Token")
+	if reached || w.Code != http.StatusNoContent ||
+		w.Header().Get("Access-Control-Allow-Methods") != "GET, HEAD, POST, PUT, PATCH, DELETE" ||
+		w.Header().Get("Access-Control-Allow-Headers") != "Content-Type, X-CSRF-Token" ||
+		w.Header().Get("Access-Control-Max-Age") != "600" {
+		t.Errorf("expected an allowed preflight got %d %v", w.Code, w.Header())
+	}
+	for name, w := range map[string]*httptest.ResponseRecorder{
+		"origin": preflight("https://evil.com", http.MethodPost, ""),
+		"method": preflight("https://app.example.com", "PURGE", ""),
+		"header": preflight("https://app.example.com", http.MethodPost, "X-Secret"),
+	} {
+		if reached || w.Code != http.StatusForbidden || w.Header().Get("Access-Control-Allow-Origin") != "" {
+			t.Errorf("expected the preflight of a bad %s rejected got %d %v", name, w.Code, w.Header())
+		}
+	}
+	w = serve(http.MethodOptions, "https://app.example.com", map[string]string{
+		"Access-Control-Request-Method":          http.MethodGet,
+		"Access-Control-Request-Private-Network": "true",
+	})
+	if w.Code != http.StatusNoContent || w.Header().Get("Access-Control-Allow-Private-Network") != "true" {
+		t.Errorf("expected a private network preflight allowed got %d %v", w.Code, w.Header())
+	}
+
+	if _, err := CORS(CORSConfig{OriginPatterns: []string{"("}}); err == nil {
+		t.Error("expected an invalid pattern error")
+	}
+	if _, err := CORS(CORSConfig{TrustedOrigins: []string{"*"}, Credentials: true}); err == nil {
+		t.Error("expected any origin with credentials rejected")
+	}
+}
+
+func TestCORSAnyOrigin(t *testing.T) {
+	h := CORSMiddleware([]string{"*"})(func(http.ResponseWriter, *http.Request) {})
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Origin", "https://any.site")
+	w := httptest.NewRecorder()
+	h(w, r)
+	if w.Header().Get("Access-Control-Allow-Origin") != "*" || w.Header().Get("Vary") != "" {
+		t.Errorf("expected a wildcard without Vary got %v", w.Header())
+	}
+}
@@ -0,0 +1,335 @@
+// Package csrf protects cookie authenticated routes against cross
+// site request forgery, with a double submit cookie or a synchronizer
+// token kept in the session, on top of Origin, Referer and
+// Sec-Fetch-Site checks.
+//
+// With a session middleware before it, the double submit token is the
+// HMAC of the session id keyed by the cookie, so a cookie planted by
+// another visitor doesn't validate in the victim's session. The token
+// changes when the session is stored the first time or regenerated.
+package csrf
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"html/template"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/datewu/gtea/handler"
+	"github.com/datewu/gtea/handler/cookie"
+	"github.com/datewu/gtea/handler/session"
+)
+
+// Mode where the expected token is kept
+type Mode int
+
+// csrf modes
+const (
+	// DoubleSubmit keep the token in a signed cookie
+	DoubleSubmit Mode = iota
+	// Synchronizer keep the token in the session, the session
+	// middleware must run before
+	Synchronizer
+)
+
+// reasons of a failed check, Reason returns them to the Failure handler
+var (
+	ErrCrossOrigin = errors.New("cross origin request")
+	ErrBadOrigin   = errors.New("origin not allowed")
+	ErrBadReferer  = errors.New("referer not allowed")
+	ErrNoToken     = errors.New("csrf token missing")
+	ErrBadToken    = errors.New("csrf token invalid")
+)
+
+const tokenLen = 32
+
+// sessionKey of the synchronizer token
+const sessionKey = "_csrf"
+
+// Config of a Protector
+type Config struct {
+	Mode Mode
+	// CookieName of the double submit cookie, default "csrf"
+	CookieName string
+	// Codec signs the double submit cookie, default a codec with a
+	// random key.
+	Codec *cookie.Codec
+	// Header carrying the token, default "X-CSRF-Token"
+	Header string
+	// Field of the urlencoded form carrying the token, default
+	// "csrf_token". Multipart bodies aren't parsed, the token of a
+	// multipart request must be sent in Header.
+	Field string
+	// TrustedOrigins allowed besides the origin of the request host,
+	// e.g. "https://admin.example.com"
+	TrustedOrigins []string
+	// Exempt paths, path.Match patterns e.g. "/webhooks/*"
+	Exempt []string
+	// Failure responds to a failed check, default a 403 json error
+	Failure http.HandlerFunc
+}
+
+// Protector is the csrf middleware
+type Protector struct {
+	conf    Config
+	trusted map[string]bool
+}
+
+// New returns a Protector
+func New(conf Config) (*Protector, error) {
+	if conf.CookieName == "" {
+		conf.CookieName = "csrf"
+	}
+	if conf.Header == "" {
+		conf.Header = "X-CSRF-Token"
+	}
+	if conf.Field == "" {
+		conf.Field = "csrf_token"
+	}
+	if conf.Failure == nil {
+		conf.Failure = failure
+	}
+	if conf.Codec == nil && conf.Mode == DoubleSubmit {
+		key, err := cookie.GenerateKey()
+		if err != nil {
+			return nil, err
+		}
+		keys, err := cookie.NewKeyring(key)
+		if err != nil {
+			return nil, err
+		}
+		conf.Codec = cookie.New(keys, cookie.Options{HTTPOnly: true})
+	}
+	for _, p := range conf.Exempt {
+		if _, err := path.Match(p, "/"); err != nil {
+			return nil, err
+		}
+	}
+	p := &Protector{conf: conf, trusted: make(map[string]bool)}
+	for _, o := range conf.TrustedOrigins {
+		p.trusted[strings.ToLower(strings.TrimSuffix(o, "/"))] = true
+	}
+	return p, nil
+}
+
+func failure(w http.ResponseWriter, r *http.Request) {
+	msg := "CSRF check failed"
+	if err := Reason(r); err != nil {
+		msg += ": " + err.Error()
+	}
+	handler.WriteJSON(w, http.StatusForbidden, handler.Envelope{"error": msg}, nil)
+}
+
+type ctxKey int
+
+const (
+	tokenKey ctxKey = iota
+	reasonKey
+)
+
+// token of a request context
+type token struct {
+	raw   []byte
+	field string
+}
+
+// Token returns the masked token of the request for forms and
+// headers, a new mask every call keeps it safe from BREACH
+func Token(r *http.Request) string {
+	t, ok := r.Context().Value(tokenKey).(*token)
+	if !ok {
+		return ""
+	}
+	return mask(t.raw)
+}
+
+// TemplateField returns a hidden input carrying the token
+func TemplateField(r *http.Request) template.HTML {
+	t, ok := r.Context().Value(tokenKey).(*token)
+	if !ok {
+		return ""
+	}
+	return template.HTML(`<input type="hidden" name="` + template.HTMLEscapeString(t.field) +
+		`" value="` + mask(t.raw) + `">`)
+}
+
+// Reason returns why the check failed, for a Failure handler
+func Reason(r *http.Request) error {
+	err, _ := r.Context().Value(reasonKey).(error)
+	return err
+}
+
+func mask(raw []byte) string {
+	out := make([]byte, 2*tokenLen)
+	rand.Read(out[:tokenLen])
+	for i := 0; i < tokenLen; i++ {
+		out[tokenLen+i] = raw[i] ^ out[i]
+	}
+	return base64.RawURLEncoding.EncodeToString(out)
+}
+
+func unmask(token string) []byte {
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || len(b) != 2*tokenLen {
+		return nil
+	}
+	raw := make([]byte, tokenLen)
+	for i := 0; i < tokenLen; i++ {
+		raw[i] = b[i] ^ b[tokenLen+i]
+	}
+	return raw
+}
+
+func randomToken() ([]byte, error) {
+	b := make([]byte, tokenLen)
+	_, err := rand.Read(b)
+	return b, err
+}
+
+// load returns the expected token, creating one if there is none
+func (p *Protector) load(w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	if p.conf.Mode == Synchronizer {
+		s, ok := session.FromContext(r.Context())
+		if !ok {
+			return nil, errors.New("csrf: no session middleware")
+		}
+		if raw, ok := session.Get[[]byte](s, sessionKey); ok && len(raw) == tokenLen {
+			return raw, nil
+		}
+		raw, err := randomToken()
+		if err != nil {
+			return nil, err
+		}
+		return raw, s.Set(sessionKey, raw)
+	}
+	raw, err := p.conf.Codec.Get(r, p.conf.CookieName)
+	if err == nil && len(raw) == tokenLen {
+		return bind(r, raw), nil
+	}
+	raw, err = randomToken()
+	if err != nil {
+		return nil, err
+	}
+	return bind(r, raw), p.conf.Codec.Set(w, p.conf.CookieName, raw)
+}
+
+// bind the double submit cookie value raw to the stored session of r
+func bind(r *http.Request, raw []byte) []byte {
+	s, ok := session.FromContext(r.Context())
+	if !ok || s.ID() == "" {
+		return raw
+	}
+	mac := hmac.New(sha256.New, raw)
+	mac.Write([]byte(s.ID()))
+	return mac.Sum(nil)
+}
+
+func (p *Protector) exempt(r *http.Request) bool {
+	for _, pattern := range p.conf.Exempt {
+		if ok, _ := path.Match(pattern, r.URL.Path); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func requestOrigin(r *http.Request) string {
+	scheme := "http"
+	if handler.IsHTTPS(r) {
+		scheme = "https"
+	}
+	return scheme + "://" + strings.ToLower(r.Host)
+}
+
+func (p *Protector) allowed(origin string, r *http.Request) bool {
+	origin = strings.ToLower(origin)
+	return origin == requestOrigin(r) || p.trusted[origin]
+}
+
+// checkOrigin use Origin, then Sec-Fetch-Site, then Referer
+func (p *Protector) checkOrigin(r *http.Request) error {
+	if origin := r.Header.Get("Origin"); origin != "" && origin != "null" {
+		if !p.allowed(origin, r) {
+			return ErrBadOrigin
+		}
+		return nil
+	}
+	if r.Header.Get("Sec-Fetch-Site") == "cross-site" {
+		return ErrCrossOrigin
+	}
+	if ref := r.Header.Get("Referer"); ref != "" {
+		u, err := url.Parse(ref)
+		if err != nil || !p.allowed(u.Scheme+"://"+u.Host, r) {
+			return ErrBadReferer
+		}
+	}
+	return nil
+}
+
+func (p *Protector) submitted(r *http.Request) string {
+	if t := r.Header.Get(p.conf.Header); t != "" {
+		return t
+	}
+	// a multipart body is left alone, parsing it would read the
+	// whole upload before the check
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+		return r.PostFormValue(p.conf.Field)
+	}
+	return ""
+}
+
+func safeMethod(m string) bool {
+	switch m {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	return false
+}
+
+func (p *Protector) fail(w http.ResponseWriter, r *http.Request, err error) {
+	p.conf.Failure(w, handler.SetValue(r, reasonKey, err))
+}
+
+// Middleware check the unsafe methods and put the token in the request
+// context for Token
+func (p *Protector) Middleware(next http.HandlerFunc) http.HandlerFunc {
+	middle := func(w http.ResponseWriter, r *http.Request) {
+		if p.exempt(r) {
+			next(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Cookie")
+		raw, err := p.load(w, r)
+		if err != nil {
+			handler.ServerErr(w, err)
+			return
+		}
+		r = handler.SetValue(r, tokenKey, &token{raw: raw, field: p.conf.Field})
+		if safeMethod(r.Method) {
+			next(w, r)
+			return
+		}
+		if err := p.checkOrigin(r); err != nil {
+			p.fail(w, r, err)
+			return
+		}
+		sent := p.submitted(r)
+		if sent == "" {
+			p.fail(w, r, ErrNoToken)
+			return
+		}
+		if subtle.ConstantTimeCompare(unmask(sent), raw) != 1 {
+			p.fail(w, r, ErrBadToken)
+			return
+		}
+		next(w, r)
+	}
+	return middle
+}
@@ -0,0 +1,177 @@
+package csrf
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/datewu/gtea/handler/session"
+)
+
+func run(h http.HandlerFunc, r *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h(w, r)
+	return w
+}
+
+func TestDoubleSubmit(t *testing.T) {
+	p, err := New(Config{TrustedOrigins: []string{"https://admin.example.com"}, Exempt: []string{"/hooks/*"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var token string
+	h := p.Middleware(func(w http.ResponseWriter, r *http.Request) {
+		token = Token(r)
+		w.Write([]byte("ok"))
+	})
+	w := run(h, httptest.NewRequest(http.MethodGet, "http://example.com/form", nil))
+	cookie := strings.Split(w.Header().Get("Set-Cookie"), ";")[0]
+	if w.Code != http.StatusOK || token == "" || cookie == "" {
+		t.Fatalf("expected a token and a cookie got %d %q", w.Code, cookie)
+	}
+	post := func(origin, header string, form url.Values) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "http://example.com/form", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.Header.Set("Cookie", cookie)
+		if origin != "" {
+			r.Header.Set("Origin", origin)
+		}
+		if header != "" {
+			r.Header.Set("X-CSRF-Token", header)
+		}
+		return run(h, r)
+	}
+	cases := []struct {
+		name   string
+		origin string
+		header string
+		form   url.Values
+		code   int
+	}{
+		{"form field", "http://example.com", "", url.Values{"csrf_token": {token}}, http.StatusOK},
+		{"header", "", token, nil, http.StatusOK},
+		{"trusted origin", "https://admin.example.com", token, nil, http.StatusOK},
+		{"cross origin", "https://evil.com", token, nil, http.StatusForbidden},
+		{"missing token", "http://example.com", "", nil, http.StatusForbidden},
+		{"wrong token", "", mask(make([]byte, tokenLen)), nil, http.StatusForbidden},
+	}
+	for _, c := range cases {
+		if w := post(c.origin, c.header, c.form); w.Code != c.code {
+			t.Errorf("%s: expected %d got %d %s", c.name, c.code, w.Code, w.Body)
+		}
+	}
+	if Token(httptest.NewRequest(http.MethodGet, "/", nil)) != "" {
+		t.Error("expected no token out of the middleware")
+	}
+	r := httptest.NewRequest(http.MethodPost, "http://example.com/hooks/github", nil)
+	r.Header.Set("Sec-Fetch-Site", "cross-site")
+	if w := run(h, r); w.Code != http.StatusOK {
+		t.Errorf("expected exempt path got %d", w.Code)
+	}
+}
+
+func TestSynchronizer(t *testing.T) {
+	m, _ := session.New(session.Config{Store: session.NewMemory(0)})
+	var reason error
+	p, _ := New(Config{Mode: Synchronizer, Failure: func(w http.ResponseWriter, r *http.Request) {
+		reason = Reason(r)
+		w.WriteHeader(http.StatusTeapot)
+	}})
+	var token string
+	h := m.Middleware(p.Middleware(func(w http.ResponseWriter, r *http.Request) {
+		token = Token(r)
+	}))
+	w := run(h, httptest.NewRequest(http.MethodGet, "/", nil))
+	cookie := strings.Split(w.Header().Get("Set-Cookie"), ";")[0]
+	r := httptest.NewRequest(http.MethodPost, "/", nil)
+	r.Header.Set("Cookie", cookie)
+	r.Header.Set("X-CSRF-Token", token)
+	if w := run(h, r); w.Code != http.StatusOK {
+		t.Errorf("expected session token accepted got %d", w.Code)
+	}
+	r = httptest.NewRequest(http.MethodDelete, "/", nil)
+	r.Header.Set("Cookie", cookie)
+	r.Header.Set("Sec-Fetch-Site", "cross-site")
+	if w := run(h, r); w.Code != http.StatusTeapot || reason != ErrCrossOrigin {
+		t.Errorf("expected custom failure got %d %v", w.Code, reason)
+	}
+}
+
+func TestTemplateField(t *testing.T) {
+	p, _ := New(Config{Field: "_token"})
+	var field string
+	run(p.Middleware(func(w http.ResponseWriter, r *http.Request) {
+		field = string(TemplateField(r))
+	}), httptest.NewRequest(http.MethodGet, "/", nil))
+	if !strings.HasPrefix(field, `<input type="hidden" name="_token" value="`) {
+		t.Errorf("unexpected field %s", field)
+	}
+}
+
+func TestSessionBoundDoubleSubmit(t *testing.T) {
+	m, _ := session.New(session.Config{Store: session.NewMemory(0)})
+	p, _ := New(Config{})
+	var token string
+	h := m.Middleware(p.Middleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/login" {
+			session.FromRequest(r).Set("user", r.URL.Query().Get("user"))
+		}
+		token = Token(r)
+	}))
+	cookies := func(w *httptest.ResponseRecorder, keep map[string]string) map[string]string {
+		for _, c := range w.Result().Cookies() {
+			keep[c.Name] = c.Value
+		}
+		return keep
+	}
+	visit := func(method, target string, jar map[string]string, header string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, "http://example.com"+target, nil)
+		for name, value := range jar {
+			r.AddCookie(&http.Cookie{Name: name, Value: value})
+		}
+		if header != "" {
+			r.Header.Set("X-CSRF-Token", header)
+		}
+		return run(h, r)
+	}
+	login := func(user string) (map[string]string, string) {
+		jar := cookies(visit(http.MethodGet, "/login?user="+user, nil, ""), map[string]string{})
+		cookies(visit(http.MethodGet, "/form", jar, ""), jar)
+		return jar, token
+	}
+	victim, victimToken := login("alice")
+	attacker, attackerToken := login("mallory")
+	if w := visit(http.MethodPost, "/form", victim, victimToken); w.Code != http.StatusOK {
+		t.Errorf("expected the session token accepted got %d %s", w.Code, w.Body)
+	}
+	planted := map[string]string{"session": victim["session"], "csrf": attacker["csrf"]}
+	if w := visit(http.MethodPost, "/form", planted, attackerToken); w.Code != http.StatusForbidden {
+		t.Errorf("expected a planted cookie rejected in another session got %d", w.Code)
+	}
+}
+
+func TestMultipartNeedsHeader(t *testing.T) {
+	p, _ := New(Config{})
+	var token string
+	h := p.Middleware(func(w http.ResponseWriter, r *http.Request) { token = Token(r) })
+	w := run(h, httptest.NewRequest(http.MethodGet, "/", nil))
+	cookie := strings.Split(w.Header().Get("Set-Cookie"), ";")[0]
+	post := func(header string) int {
+		body := "--b\r\nContent-Disposition: form-data; name=\"csrf_token\"\r\n\r\n" + token + "\r\n--b--\r\n"
+		r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		r.Header.Set("Content-Type", "multipart/form-data; boundary=b")
+		r.Header.Set("Cookie", cookie)
+		if header != "" {
+			r.Header.Set("X-CSRF-Token", header)
+		}
+		return run(h, r).Code
+	}
+	if code := post(""); code != http.StatusForbidden {
+		t.Errorf("expected the multipart field ignored got %d", code)
+	}
+	if code := post(token); code != http.StatusOK {
+		t.Errorf("expected the header token accepted got %d", code)
+	}
+}
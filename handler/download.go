@@ -0,0 +1,202 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ServeDownload serve content as an attachment named name. Range,
+// multi range, If-Range and the other conditional requests are handled
+// by http.ServeContent, set an ETag header before calling to make
+// If-Range match on it instead of modtime.
+func ServeDownload(w http.ResponseWriter, r *http.Request, name string, modtime time.Time, content io.ReadSeeker) {
+	setDownloadHeaders(w, name)
+	http.ServeContent(w, r, name, modtime, content)
+}
+
+// ServeDownloadReader serve a non seekable content with a declared size.
+// Ranges are served by skipping forward, a multi range request out of
+// order is answered with the full content.
+func ServeDownloadReader(w http.ResponseWriter, r *http.Request, name string, modtime time.Time, content io.Reader, size int64) {
+	if size < 0 {
+		ServerErr(w, errors.New("download size must not be negative"))
+		return
+	}
+	setDownloadHeaders(w, name)
+	if w.Header().Get("Content-Type") == "" {
+		// never let ServeContent sniff, it would seek backwards
+		w.Header().Set("Content-Type", "application/octet-stream")
+	}
+	if !forwardRanges(r.Header.Get("Range"), size) {
+		r.Header.Del("Range")
+	}
+	http.ServeContent(w, r, name, modtime, &forwardSeeker{r: content, size: size})
+}
+
+func setDownloadHeaders(w http.ResponseWriter, name string) {
+	h := w.Header()
+	h.Set("Content-Disposition", contentDisposition(name))
+	if h.Get("Content-Type") == "" {
+		if ct := mime.TypeByExtension(filepath.Ext(name)); ct != "" {
+			h.Set("Content-Type", ct)
+		}
+	}
+}
+
+// contentDisposition format an attachment header value with an ascii
+// fallback filename and a RFC 5987 encoded filename* for non ascii names
+func contentDisposition(filename string) string {
+	filename = filepath.Base(filename)
+	if filename == "." || filename == string(filepath.Separator) {
+		return "attachment"
+	}
+	var fallback strings.Builder
+	ascii := true
+	for _, c := range filename {
+		switch {
+		case c < 0x20 || c == 0x7f:
+			ascii = false
+		case c > 0x7e:
+			ascii = false
+			fallback.WriteByte('_')
+		case c == '"' || c == '\\':
+			fallback.WriteByte('\\')
+			fallback.WriteRune(c)
+		default:
+			fallback.WriteRune(c)
+		}
+	}
+	v := `attachment; filename="` + fallback.String() + `"`
+	if !ascii {
+		v += "; filename*=UTF-8''" + rfc5987Escape(filename)
+	}
+	return v
+}
+
+// rfc5987Escape percent encode all bytes but attr-char
+func rfc5987Escape(s string) string {
+	const hex = "0123456789ABCDEF"
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' ||
+			strings.IndexByte("!#$&+-.^_`|~", c) >= 0 {
+			b.WriteByte(c)
+			continue
+		}
+		b.WriteByte('%')
+		b.WriteByte(hex[c>>4])
+		b.WriteByte(hex[c&0x0f])
+	}
+	return b.String()
+}
+
+// forwardRanges reports whether a Range header could be served by only
+// reading forward, an invalid header is reported true to let
+// http.ServeContent response it.
+func forwardRanges(header string, size int64) bool {
+	if header == "" {
+		return true
+	}
+	spec, ok := strings.CutPrefix(header, "bytes=")
+	if !ok {
+		return true
+	}
+	end := int64(-1)
+	for _, ra := range strings.Split(spec, ",") {
+		ra = strings.TrimSpace(ra)
+		if ra == "" {
+			continue
+		}
+		first, last, ok := strings.Cut(ra, "-")
+		if !ok {
+			return true
+		}
+		var start, stop int64
+		if first == "" {
+			n, err := strconv.ParseInt(last, 10, 64)
+			if err != nil {
+				return true
+			}
+			start, stop = size-n, size-1
+			if start < 0 {
+				start = 0
+			}
+		} else {
+			i, err := strconv.ParseInt(first, 10, 64)
+			if err != nil {
+				return true
+			}
+			start, stop = i, size-1
+			if last != "" {
+				j, err := strconv.ParseInt(last, 10, 64)
+				if err != nil {
+					return true
+				}
+				if j < stop {
+					stop = j
+				}
+			}
+		}
+		if start >= size {
+			// not satisfiable, skipped by ServeContent
+			continue
+		}
+		if start <= end {
+			return false
+		}
+		end = stop
+	}
+	return true
+}
+
+// forwardSeeker fake a io.ReadSeeker on top of a reader with a known
+// size, seeking forward discards bytes, seeking backwards fails once
+// the bytes have been read.
+type forwardSeeker struct {
+	r    io.Reader
+	size int64
+	pos  int64 // logical position
+	read int64 // bytes consumed from r
+}
+
+func (f *forwardSeeker) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.pos
+	case io.SeekEnd:
+		offset += f.size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	f.pos = offset
+	return offset, nil
+}
+
+func (f *forwardSeeker) Read(p []byte) (int, error) {
+	if f.pos < f.read {
+		return 0, fmt.Errorf("cannot seek backwards to %d after reading %d bytes", f.pos, f.read)
+	}
+	if f.pos > f.read {
+		n, err := io.CopyN(io.Discard, f.r, f.pos-f.read)
+		f.read += n
+		if err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.r.Read(p)
+	f.read += int64(n)
+	f.pos = f.read
+	return n, err
+}
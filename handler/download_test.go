@@ -0,0 +1,78 @@
+package handler
+
+import (
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const downloadText = "0123456789abcdefghij"
+
+func download(t *testing.T, seekable bool, headers map[string]string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, "/report", nil)
+	for k, v := range headers {
+		r.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	mod := time.Date(2023, 9, 1, 0, 0, 0, 0, time.UTC)
+	if seekable {
+		ServeDownload(w, r, "rapport été.csv", mod, strings.NewReader(downloadText))
+	} else {
+		rd := io.NopCloser(strings.NewReader(downloadText))
+		ServeDownloadReader(w, r, "rapport été.csv", mod, rd, int64(len(downloadText)))
+	}
+	return w
+}
+
+func TestServeDownload(t *testing.T) {
+	for _, seekable := range []bool{true, false} {
+		w := download(t, seekable, nil)
+		if w.Code != http.StatusOK || w.Body.String() != downloadText {
+			t.Errorf("unexpected full download %d %q", w.Code, w.Body)
+		}
+		cd := w.Header().Get("Content-Disposition")
+		expect := `attachment; filename="rapport _t_.csv"; filename*=UTF-8''rapport%20%C3%A9t%C3%A9.csv`
+		if cd != expect {
+			t.Errorf("expected %q got %q", expect, cd)
+		}
+		w = download(t, seekable, map[string]string{"Range": "bytes=2-4"})
+		if w.Code != http.StatusPartialContent || w.Body.String() != "234" ||
+			w.Header().Get("Content-Length") != "3" {
+			t.Errorf("unexpected range download %d %q %v", w.Code, w.Body, w.Header())
+		}
+		w = download(t, seekable, map[string]string{
+			"Range":    "bytes=2-4",
+			"If-Range": "Thu, 01 Jan 1970 00:00:00 GMT",
+		})
+		if w.Code != http.StatusOK || w.Body.String() != downloadText {
+			t.Errorf("expected If-Range mismatch full download got %d", w.Code)
+		}
+		w = download(t, seekable, map[string]string{"Range": "bytes=0-1,-2"})
+		if w.Code != http.StatusPartialContent {
+			t.Fatalf("expected %d got %d", http.StatusPartialContent, w.Code)
+		}
+		_, params, _ := mime.ParseMediaType(w.Header().Get("Content-Type"))
+		mr := multipart.NewReader(w.Body, params["boundary"])
+		var parts []string
+		for {
+			p, err := mr.NextPart()
+			if err != nil {
+				break
+			}
+			b, _ := io.ReadAll(p)
+			parts = append(parts, string(b))
+		}
+		if len(parts) != 2 || parts[0] != "01" || parts[1] != "ij" {
+			t.Errorf("unexpected multi range parts %q", parts)
+		}
+	}
+	w := download(t, false, map[string]string{"Range": "bytes=5-6,0-1"})
+	if w.Code != http.StatusOK || w.Body.String() != downloadText {
+		t.Errorf("expected out of order ranges to get full content got %d", w.Code)
+	}
+}
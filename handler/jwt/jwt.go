@@ -0,0 +1,346 @@
+// Package jwt verifies HS256, RS256, ES256 and EdDSA signed JSON Web
+// Tokens against a local key set or a JWKS, and provides a middleware
+// putting the verified claims in the request context.
+package jwt
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// supported algorithms
+const (
+	HS256 = "HS256"
+	RS256 = "RS256"
+	ES256 = "ES256"
+	EdDSA = "EdDSA"
+)
+
+// verification errors
+var (
+	ErrMalformed        = errors.New("malformed token")
+	ErrAlgorithm        = errors.New("algorithm not allowed")
+	ErrKeyNotFound      = errors.New("no key found for token")
+	ErrSignature        = errors.New("invalid signature")
+	ErrExpired          = errors.New("token is expired")
+	ErrNotValidYet      = errors.New("token is not valid yet")
+	ErrInvalidIssuer    = errors.New("invalid issuer")
+	ErrInvalidAudience  = errors.New("invalid audience")
+	ErrUnsupportedKey   = errors.New("unsupported key type")
+	errKeyAlgorithmPair = errors.New("key type does not match algorithm")
+)
+
+var b64 = base64.RawURLEncoding
+
+// NumericDate is seconds since the epoch, 0 means absent
+type NumericDate int64
+
+// UnmarshalJSON accept float seconds
+func (d *NumericDate) UnmarshalJSON(b []byte) error {
+	var f float64
+	if err := json.Unmarshal(b, &f); err != nil {
+		return err
+	}
+	*d = NumericDate(f)
+	return nil
+}
+
+// Time returns the zero time if d is absent
+func (d NumericDate) Time() time.Time {
+	if d == 0 {
+		return time.Time{}
+	}
+	return time.Unix(int64(d), 0)
+}
+
+// NewNumericDate from a time
+func NewNumericDate(t time.Time) NumericDate {
+	return NumericDate(t.Unix())
+}
+
+// Audience is a single string or an array of strings
+type Audience []string
+
+// UnmarshalJSON accept a string or an array
+func (a *Audience) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*a = Audience{s}
+		return nil
+	}
+	var ss []string
+	if err := json.Unmarshal(b, &ss); err != nil {
+		return err
+	}
+	*a = ss
+	return nil
+}
+
+// Contains reports whether aud is one of the audiences
+func (a Audience) Contains(aud string) bool {
+	for _, v := range a {
+		if v == aud {
+			return true
+		}
+	}
+	return false
+}
+
+// Claims are the registered claims of a token, Raw keeps the whole
+// payload to decode private claims with Decode.
+type Claims struct {
+	Issuer    string      `json:"iss,omitempty"`
+	Subject   string      `json:"sub,omitempty"`
+	Audience  Audience    `json:"aud,omitempty"`
+	ExpiresAt NumericDate `json:"exp,omitempty"`
+	NotBefore NumericDate `json:"nbf,omitempty"`
+	IssuedAt  NumericDate `json:"iat,omitempty"`
+	ID        string      `json:"jti,omitempty"`
+	Scope     string      `json:"scope,omitempty"`
+	Roles     []string    `json:"roles,omitempty"`
+
+	Raw json.RawMessage `json:"-"`
+}
+
+// Decode the whole payload into v, for private claims
+func (c *Claims) Decode(v any) error {
+	return json.Unmarshal(c.Raw, v)
+}
+
+// Scopes split the space separated scope claim
+func (c *Claims) Scopes() []string {
+	return strings.Fields(c.Scope)
+}
+
+type header struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid,omitempty"`
+	Typ string `json:"typ,omitempty"`
+}
+
+// Config of a Verifier
+type Config struct {
+	Keys       KeySet
+	Algorithms []string      // allowed algorithms, default all supported
+	Issuer     string        // required iss if not empty
+	Audience   string        // required aud if not empty
+	Leeway     time.Duration // clock skew tolerated on exp and nbf
+	Now        func() time.Time
+}
+
+// Verifier verify tokens
+type Verifier struct {
+	conf Config
+}
+
+// NewVerifier returns a Verifier
+func NewVerifier(conf Config) *Verifier {
+	if len(conf.Algorithms) == 0 {
+		conf.Algorithms = []string{HS256, RS256, ES256, EdDSA}
+	}
+	if conf.Now == nil {
+		conf.Now = time.Now
+	}
+	return &Verifier{conf: conf}
+}
+
+func (v *Verifier) allowed(alg string) bool {
+	for _, a := range v.conf.Algorithms {
+		if a == alg {
+			return true
+		}
+	}
+	return false
+}
+
+// Verify check the signature and the registered claims of token
+func (v *Verifier) Verify(ctx context.Context, token string) (*Claims, error) {
+	if v.conf.Keys == nil {
+		return nil, errors.New("jwt: no key set configured")
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrMalformed
+	}
+	var h header
+	if err := decodeSegment(parts[0], &h); err != nil {
+		return nil, ErrMalformed
+	}
+	if !v.allowed(h.Alg) {
+		return nil, ErrAlgorithm
+	}
+	sig, err := b64.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrMalformed
+	}
+	keys, err := v.conf.Keys.Lookup(ctx, h.Kid, h.Alg)
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return nil, ErrKeyNotFound
+	}
+	signed := []byte(parts[0] + "." + parts[1])
+	verified := false
+	for _, k := range keys {
+		if verify(h.Alg, k.Key, signed, sig) == nil {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return nil, ErrSignature
+	}
+	payload, err := b64.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrMalformed
+	}
+	c := &Claims{Raw: payload}
+	if err := json.Unmarshal(payload, c); err != nil {
+		return nil, ErrMalformed
+	}
+	return c, v.validate(c)
+}
+
+func (v *Verifier) validate(c *Claims) error {
+	now := v.conf.Now()
+	if c.ExpiresAt != 0 && !now.Before(c.ExpiresAt.Time().Add(v.conf.Leeway)) {
+		return ErrExpired
+	}
+	if c.NotBefore != 0 && now.Add(v.conf.Leeway).Before(c.NotBefore.Time()) {
+		return ErrNotValidYet
+	}
+	if v.conf.Issuer != "" && c.Issuer != v.conf.Issuer {
+		return ErrInvalidIssuer
+	}
+	if v.conf.Audience != "" && !c.Audience.Contains(v.conf.Audience) {
+		return ErrInvalidAudience
+	}
+	return nil
+}
+
+func decodeSegment(seg string, v any) error {
+	data, err := b64.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	return dec.Decode(v)
+}
+
+func verify(alg string, key any, signed, sig []byte) error {
+	sum := sha256.Sum256(signed)
+	switch alg {
+	case HS256:
+		secret, ok := key.([]byte)
+		if !ok {
+			return errKeyAlgorithmPair
+		}
+		mac := hmac.New(sha256.New, secret)
+		mac.Write(signed)
+		if !hmac.Equal(mac.Sum(nil), sig) {
+			return ErrSignature
+		}
+		return nil
+	case RS256:
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return errKeyAlgorithmPair
+		}
+		return rsa.VerifyPKCS1v15(pub, crypto.SHA256, sum[:], sig)
+	case ES256:
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok || pub.Curve.Params().BitSize != 256 {
+			return errKeyAlgorithmPair
+		}
+		if len(sig) != 64 {
+			return ErrSignature
+		}
+		r := new(big.Int).SetBytes(sig[:32])
+		s := new(big.Int).SetBytes(sig[32:])
+		if !ecdsa.Verify(pub, sum[:], r, s) {
+			return ErrSignature
+		}
+		return nil
+	case EdDSA:
+		pub, ok := key.(ed25519.PublicKey)
+		if !ok {
+			return errKeyAlgorithmPair
+		}
+		if !ed25519.Verify(pub, signed, sig) {
+			return ErrSignature
+		}
+		return nil
+	}
+	return ErrAlgorithm
+}
+
+// Sign returns a compact token of claims signed by a private key:
+// []byte for HS256, *rsa.PrivateKey, *ecdsa.PrivateKey or ed25519.PrivateKey.
+func Sign(alg, kid string, key any, claims any) (string, error) {
+	h, err := json.Marshal(header{Alg: alg, Kid: kid, Typ: "JWT"})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signed := b64.EncodeToString(h) + "." + b64.EncodeToString(payload)
+	sum := sha256.Sum256([]byte(signed))
+	var sig []byte
+	switch alg {
+	case HS256:
+		secret, ok := key.([]byte)
+		if !ok {
+			return "", errKeyAlgorithmPair
+		}
+		mac := hmac.New(sha256.New, secret)
+		mac.Write([]byte(signed))
+		sig = mac.Sum(nil)
+	case RS256:
+		priv, ok := key.(*rsa.PrivateKey)
+		if !ok {
+			return "", errKeyAlgorithmPair
+		}
+		sig, err = rsa.SignPKCS1v15(rand.Reader, priv, crypto.SHA256, sum[:])
+	case ES256:
+		priv, ok := key.(*ecdsa.PrivateKey)
+		if !ok || priv.Curve.Params().BitSize != 256 {
+			return "", errKeyAlgorithmPair
+		}
+		var r, s *big.Int
+		r, s, err = ecdsa.Sign(rand.Reader, priv, sum[:])
+		if err == nil {
+			sig = make([]byte, 64)
+			r.FillBytes(sig[:32])
+			s.FillBytes(sig[32:])
+		}
+	case EdDSA:
+		priv, ok := key.(ed25519.PrivateKey)
+		if !ok {
+			return "", errKeyAlgorithmPair
+		}
+		sig = ed25519.Sign(priv, []byte(signed))
+	default:
+		return "", fmt.Errorf("%w: %s", ErrAlgorithm, alg)
+	}
+	if err != nil {
+		return "", err
+	}
+	return signed + "." + b64.EncodeToString(sig), nil
+}
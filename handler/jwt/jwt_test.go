@@ -0,0 +1,257 @@
+package jwt
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type testClaims struct {
+	Issuer    string `json:"iss,omitempty"`
+	Subject   string `json:"sub,omitempty"`
+	Audience  any    `json:"aud,omitempty"`
+	ExpiresAt int64  `json:"exp,omitempty"`
+	NotBefore int64  `json:"nbf,omitempty"`
+	Name      string `json:"name,omitempty"`
+}
+
+func TestAlgorithms(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	edPub, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	secret := []byte("lol secret")
+	keys := StaticKeys{
+		{ID: "hs", Key: secret},
+		{ID: "rs", Key: &rsaKey.PublicKey},
+		{ID: "es", Key: &ecKey.PublicKey},
+		{ID: "ed", Key: edPub},
+	}
+	v := NewVerifier(Config{Keys: keys})
+	table := []struct {
+		alg, kid string
+		key      any
+	}{
+		{HS256, "hs", secret},
+		{RS256, "rs", rsaKey},
+		{ES256, "es", ecKey},
+		{EdDSA, "ed", edKey},
+		{EdDSA, "", edKey},
+	}
+	for _, c := range table {
+		token, err := Sign(c.alg, c.kid, c.key, testClaims{Subject: "bob", Name: "Bob"})
+		if err != nil {
+			t.Fatal(c.alg, err)
+		}
+		claims, err := v.Verify(context.Background(), token)
+		if err != nil {
+			t.Fatal(c.alg, err)
+		}
+		var custom testClaims
+		if claims.Subject != "bob" || claims.Decode(&custom) != nil || custom.Name != "Bob" {
+			t.Errorf("%s: unexpected claims %+v", c.alg, claims)
+		}
+	}
+	// an HS256 token signed with the RSA public key bytes must not verify
+	token, _ := Sign(HS256, "rs", rsaKey.PublicKey.N.Bytes(), testClaims{})
+	if _, err := v.Verify(context.Background(), token); err != ErrSignature {
+		t.Errorf("expected %v got %v", ErrSignature, err)
+	}
+	other, _ := rsa.GenerateKey(rand.Reader, 2048)
+	token, _ = Sign(RS256, "rs", other, testClaims{})
+	if _, err := v.Verify(context.Background(), token); err != ErrSignature {
+		t.Errorf("expected %v got %v", ErrSignature, err)
+	}
+	if _, err := v.Verify(context.Background(), "eyJhbGciOiJub25lIn0.e30."); err != ErrAlgorithm {
+		t.Errorf("expected %v got %v", ErrAlgorithm, err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	secret := []byte("lol secret")
+	now := time.Unix(1_700_000_000, 0)
+	v := NewVerifier(Config{
+		Keys:     StaticKeys{{Key: secret}},
+		Issuer:   "https://id.example.com",
+		Audience: "api",
+		Leeway:   time.Minute,
+		Now:      func() time.Time { return now },
+	})
+	base := testClaims{Issuer: "https://id.example.com", Audience: []string{"web", "api"}}
+	table := []struct {
+		mod    func(c *testClaims)
+		expect error
+	}{
+		{func(c *testClaims) {}, nil},
+		{func(c *testClaims) { c.ExpiresAt = now.Add(-30 * time.Second).Unix() }, nil},
+		{func(c *testClaims) { c.ExpiresAt = now.Add(-2 * time.Minute).Unix() }, ErrExpired},
+		{func(c *testClaims) { c.NotBefore = now.Add(30 * time.Second).Unix() }, nil},
+		{func(c *testClaims) { c.NotBefore = now.Add(2 * time.Minute).Unix() }, ErrNotValidYet},
+		{func(c *testClaims) { c.Issuer = "evil" }, ErrInvalidIssuer},
+		{func(c *testClaims) { c.Audience = "web" }, ErrInvalidAudience},
+		{func(c *testClaims) { c.Audience = "api" }, nil},
+	}
+	for i, c := range table {
+		claims := base
+		c.mod(&claims)
+		token, _ := Sign(HS256, "", secret, claims)
+		if _, err := v.Verify(context.Background(), token); err != c.expect {
+			t.Errorf("case %d: expected %v got %v", i, c.expect, err)
+		}
+	}
+}
+
+func TestJWKSMiddleware(t *testing.T) {
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	pad := func(i *big.Int) string {
+		b := make([]byte, 32)
+		return b64.EncodeToString(i.FillBytes(b))
+	}
+	set := map[string]any{"keys": []map[string]string{{
+		"kty": "EC", "crv": "P-256", "kid": "k1", "alg": ES256, "use": "sig",
+		"x": pad(ecKey.X), "y": pad(ecKey.Y),
+	}}}
+	fetched := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetched++
+		json.NewEncoder(w).Encode(set)
+	}))
+	defer ts.Close()
+	md := Middleware(Config{Keys: &JWKS{URL: ts.URL}})
+	h := md(func(w http.ResponseWriter, r *http.Request) {
+		c, ok := FromContext(r.Context())
+		if !ok {
+			t.Error("expected claims in context")
+			return
+		}
+		w.Write([]byte(c.Subject))
+	})
+	req := func(token string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		h(w, r)
+		return w
+	}
+	token, _ := Sign(ES256, "k1", ecKey, testClaims{Subject: "alice"})
+	w := req(token)
+	if w.Code != http.StatusOK || w.Body.String() != "alice" {
+		t.Errorf("unexpected response %d %q", w.Code, w.Body)
+	}
+	req(token)
+	if fetched != 1 {
+		t.Errorf("expected JWKS fetched once got %d", fetched)
+	}
+	w = req("")
+	if w.Code != http.StatusUnauthorized ||
+		w.Body.String() != `{"error":"you must be authenticated to access this resource"}` {
+		t.Errorf("unexpected response %d %q", w.Code, w.Body)
+	}
+	token, _ = Sign(ES256, "k1", ecKey, testClaims{ExpiresAt: 1})
+	w = req(token)
+	if w.Code != http.StatusUnauthorized ||
+		w.Body.String() != `{"error":"invalid or missing authentication token"}` {
+		t.Errorf("unexpected response %d %q", w.Code, w.Body)
+	}
+}
+
+func TestJWKSBackoff(t *testing.T) {
+	var mu sync.Mutex
+	fetched, fail := 0, false
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		mu.Lock()
+		defer mu.Unlock()
+		fetched++
+		if fail {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.Write([]byte(`{"keys":[{"kty":"oct","kid":"k1","k":"c2VjcmV0"}]}`))
+	}))
+	defer ts.Close()
+	j := &JWKS{URL: ts.URL, MinInterval: 50 * time.Millisecond}
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if keys, err := j.Lookup(ctx, "k1", HS256); err != nil || len(keys) != 1 {
+				t.Errorf("unexpected lookup %v %v", keys, err)
+			}
+		}()
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if fetched != 1 {
+		t.Fatalf("expected concurrent lookups sharing a fetch got %d", fetched)
+	}
+
+	mu.Lock()
+	fail = true
+	mu.Unlock()
+	time.Sleep(60 * time.Millisecond)
+	if _, err := j.Lookup(ctx, "k2", HS256); err == nil {
+		t.Error("expected the failed reload error")
+	}
+	for i := 0; i < 5; i++ {
+		if keys, err := j.Lookup(ctx, "k2", HS256); err != nil || len(keys) != 0 {
+			t.Errorf("unexpected lookup %v %v", keys, err)
+		}
+		if keys, _ := j.Lookup(ctx, "k1", HS256); len(keys) != 1 {
+			t.Error("expected the loaded keys kept after a failed reload")
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if fetched != 2 {
+		t.Errorf("expected a failed reload backing off got %d fetches", fetched)
+	}
+}
+
+func TestJWKSStale(t *testing.T) {
+	var fetched atomic.Int32
+	block := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fetched.Add(1) > 1 {
+			<-block
+		}
+		w.Write([]byte(`{"keys":[{"kty":"oct","kid":"k1","k":"c2VjcmV0"}]}`))
+	}))
+	defer ts.Close()
+	defer close(block)
+	j := &JWKS{URL: ts.URL, RefreshInterval: 10 * time.Millisecond, MinInterval: time.Millisecond}
+	if keys, err := j.Lookup(context.Background(), "k1", HS256); err != nil || len(keys) != 1 {
+		t.Fatalf("unexpected lookup %v %v", keys, err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	for i := 0; i < 3; i++ {
+		if keys, err := j.Lookup(ctx, "k1", HS256); err != nil || len(keys) != 1 {
+			t.Fatalf("expected the stale keys served got %v %v", keys, err)
+		}
+	}
+	for i := 0; i < 100 && fetched.Load() < 2; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	if n := fetched.Load(); n != 2 {
+		t.Errorf("expected a single background reload got %d fetches", n)
+	}
+}
@@ -0,0 +1,267 @@
+package jwt
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// Key is a verification key, Key holds a []byte HS256 secret, a
+// *rsa.PublicKey, a *ecdsa.PublicKey or a ed25519.PublicKey.
+type Key struct {
+	ID  string
+	Alg string // empty matches any algorithm of the key type
+	Key any
+}
+
+// KeySet looks up the candidate keys of a token
+type KeySet interface {
+	Lookup(ctx context.Context, kid, alg string) ([]Key, error)
+}
+
+// StaticKeys is a local key set
+type StaticKeys []Key
+
+// Lookup keys matching kid and alg, an empty kid matches every key
+func (s StaticKeys) Lookup(_ context.Context, kid, alg string) ([]Key, error) {
+	return match(s, kid, alg), nil
+}
+
+func match(keys []Key, kid, alg string) []Key {
+	var res []Key
+	for _, k := range keys {
+		if kid != "" && k.ID != kid {
+			continue
+		}
+		if k.Alg != "" && k.Alg != alg {
+			continue
+		}
+		res = append(res, k)
+	}
+	return res
+}
+
+// JWKS is a key set loaded from a JSON Web Key Set file or URL,
+// keys are reloaded every RefreshInterval or when a kid is unknown,
+// a stale set keeps serving its keys until the reload completes.
+// Reloads are attempted at most once per MinInterval, failed ones
+// included, and concurrent lookups share a single reload.
+type JWKS struct {
+	URL             string
+	File            string
+	Client          *http.Client
+	RefreshInterval time.Duration // default 1h
+	MinInterval     time.Duration // between reload attempts, default 1m
+
+	mu       sync.Mutex
+	keys     []Key
+	loaded   time.Time
+	tried    time.Time
+	err      error
+	inflight chan struct{}
+}
+
+// Lookup keys matching kid and alg
+func (j *JWKS) Lookup(ctx context.Context, kid, alg string) ([]Key, error) {
+	refresh := j.RefreshInterval
+	if refresh <= 0 {
+		refresh = time.Hour
+	}
+	min := j.MinInterval
+	if min <= 0 {
+		min = time.Minute
+	}
+	j.mu.Lock()
+	keys := match(j.keys, kid, alg)
+	loaded := !j.loaded.IsZero()
+	stale := !loaded || time.Since(j.loaded) > refresh
+	unknown := len(keys) == 0 && kid != ""
+	wait := j.inflight
+	if wait == nil && (stale || unknown) && time.Since(j.tried) > min {
+		wait = make(chan struct{})
+		j.inflight = wait
+		j.tried = time.Now()
+		// a canceled request must not fail the reload shared with others
+		go j.reload(context.WithoutCancel(ctx), wait)
+	}
+	err := j.err
+	j.mu.Unlock()
+	// a stale set serves its keys while refreshing in the background,
+	// only the first load and an unknown kid wait for the reload
+	if wait == nil || (loaded && !unknown) {
+		if !loaded {
+			return nil, err
+		}
+		return keys, nil
+	}
+	select {
+	case <-wait:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	keys = match(j.keys, kid, alg)
+	if len(keys) == 0 && j.err != nil {
+		return nil, j.err
+	}
+	return keys, nil
+}
+
+// reload the keys outside of j.mu, then close done
+func (j *JWKS) reload(ctx context.Context, done chan struct{}) {
+	keys, err := j.load(ctx)
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.err = err
+	if err == nil {
+		j.keys = keys
+		j.loaded = time.Now()
+	}
+	j.inflight = nil
+	close(done)
+}
+
+func (j *JWKS) load(ctx context.Context) ([]Key, error) {
+	var data []byte
+	var err error
+	switch {
+	case j.File != "":
+		data, err = os.ReadFile(j.File)
+	case j.URL != "":
+		data, err = j.fetch(ctx)
+	default:
+		err = errors.New("jwt: JWKS has no File or URL")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ParseJWKS(data)
+}
+
+func (j *JWKS) fetch(ctx context.Context) ([]byte, error) {
+	client := j.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, j.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("jwt: fetch JWKS got status %d", res.StatusCode)
+	}
+	return io.ReadAll(io.LimitReader(res.Body, 1<<20))
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	K   string `json:"k"`
+}
+
+// ParseJWKS parse a JSON Web Key Set, keys not for signature or of an
+// unsupported type are skipped.
+func ParseJWKS(data []byte) ([]Key, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+	var keys []Key
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.parse()
+		if errors.Is(err, ErrUnsupportedKey) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("jwt: key %q: %w", k.Kid, err)
+		}
+		keys = append(keys, Key{ID: k.Kid, Alg: k.Alg, Key: key})
+	}
+	return keys, nil
+}
+
+func (k jwk) parse() (any, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := b64.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := b64.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if len(e) > 4 {
+			return nil, errors.New("rsa exponent too large")
+		}
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, ErrUnsupportedKey
+		}
+		x, err := b64.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := b64.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		pub := &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}
+		if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, errors.New("point not on curve")
+		}
+		return pub, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, ErrUnsupportedKey
+		}
+		x, err := b64.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid ed25519 key size")
+		}
+		return ed25519.PublicKey(x), nil
+	case "oct":
+		return b64.DecodeString(k.K)
+	}
+	return nil, ErrUnsupportedKey
+}
@@ -0,0 +1,72 @@
+package jwt
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/datewu/gtea/handler"
+)
+
+type ctxKey struct{}
+
+// FromContext returns the verified claims put by the Middleware
+func FromContext(ctx context.Context) (*Claims, bool) {
+	c, ok := ctx.Value(ctxKey{}).(*Claims)
+	return c, ok
+}
+
+// NewContext returns a ctx carrying claims
+func NewContext(ctx context.Context, c *Claims) context.Context {
+	return context.WithValue(ctx, ctxKey{}, c)
+}
+
+// TokenFromRequest read the token the same way as handler.GetToken,
+// stripping a "Bearer " prefix
+func TokenFromRequest(r *http.Request, name string) (string, error) {
+	token, err := handler.GetToken(r, name)
+	if err != nil {
+		return "", err
+	}
+	if len(token) > 7 && strings.EqualFold(token[:7], "bearer ") {
+		token = strings.TrimSpace(token[7:])
+	}
+	if token == "" {
+		return "", handler.ErrNoToken
+	}
+	return token, nil
+}
+
+// Middleware verify the request token and put the claims in the
+// request context, name is the query/cookie name of handler.GetToken.
+func (v *Verifier) Middleware(name string) handler.Middleware {
+	mid := func(next http.HandlerFunc) http.HandlerFunc {
+		verify := func(w http.ResponseWriter, r *http.Request) {
+			token, err := TokenFromRequest(r, name)
+			if err != nil {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				handler.AuthenticationRequire(w)
+				return
+			}
+			claims, err := v.Verify(r.Context(), token)
+			if err != nil {
+				desc := "invalid token"
+				if errors.Is(err, ErrExpired) {
+					desc = "token is expired"
+				}
+				w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token", error_description="`+desc+`"`)
+				handler.InvalidAuthenticationToken(w)
+				return
+			}
+			next(w, r.WithContext(NewContext(r.Context(), claims)))
+		}
+		return verify
+	}
+	return mid
+}
+
+// Middleware is a shortcut for NewVerifier(conf).Middleware("token")
+func Middleware(conf Config) handler.Middleware {
+	return NewVerifier(conf).Middleware("token")
+}
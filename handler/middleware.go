@@ -1,12 +1,8 @@
 package handler
 
 import (
-	"compress/gzip"
 	"expvar"
-	"io"
-	"net"
 	"net/http"
-	"strings"
 	"sync"
 	"time"
 
@@ -72,19 +68,10 @@ func Aggregate(mds ...Middleware) Middleware {
 	return md
 }
 
-// RecoverPanicMiddleware middleware
+// RecoverPanicMiddleware recover panics with the default RecoverConfig,
+// the panic is logged and never sent to the client
 func RecoverPanicMiddleware(next http.HandlerFunc) http.HandlerFunc {
-	middle := func(w http.ResponseWriter, r *http.Request) {
-		defer func() {
-			if err := recover(); err != nil {
-				w.Header().Set("Connection", "close")
-				ServerErrAny(w, err)
-				return
-			}
-		}()
-		next(w, r)
-	}
-	return middle
+	return Recover(RecoverConfig{})(next)
 }
 
 var (
@@ -93,20 +80,39 @@ var (
 	totalProcessingTimeMicroseconds = expvar.NewInt("total_processing_time_us")
 )
 
+// MetricsHook observes the latency of every request going through a
+// Metrics middleware
+type MetricsHook func(r *http.Request, latency time.Duration)
+
 // MetricsMiddleware middleware enable expvar profile
 func MetricsMiddleware(next http.HandlerFunc) http.HandlerFunc {
-	middle := func(w http.ResponseWriter, r *http.Request) {
-		start := time.Now()
-		totalRequestReceived.Add(1)
-		next(w, r)
-		totalResponsesSend.Add(1)
-		duration := time.Since(start).Microseconds()
-		totalProcessingTimeMicroseconds.Add(duration)
+	return Metrics()(next)
+}
+
+// Metrics returns the MetricsMiddleware calling hooks after every
+// request, e.g. the Observe hook of an adaptive concurrency limiter
+func Metrics(hooks ...MetricsHook) Middleware {
+	mid := func(next http.HandlerFunc) http.HandlerFunc {
+		middle := func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			totalRequestReceived.Add(1)
+			next(w, r)
+			totalResponsesSend.Add(1)
+			latency := time.Since(start)
+			totalProcessingTimeMicroseconds.Add(latency.Microseconds())
+			for _, hook := range hooks {
+				hook(r, latency)
+			}
+		}
+		return middle
 	}
-	return middle
+	return mid
 }
 
-// RateLimitMiddleware return a middle
+// RateLimitMiddleware return a middle limiting every client IP,
+// its cleanup goroutine never stops.
+//
+// Deprecated: use the ratelimit package.
 func RateLimitMiddleware(rps float64, brust int) Middleware {
 	mid := func(next http.HandlerFunc) http.HandlerFunc {
 		type client struct {
@@ -131,15 +137,7 @@ func RateLimitMiddleware(rps float64, brust int) Middleware {
 		}
 		go delOld(time.Minute)
 		ratelimit := func(w http.ResponseWriter, r *http.Request) {
-			if r.RemoteAddr == "" {
-				// for httptest.NewRecorder()
-				r.RemoteAddr = "httptest.client:35256"
-			}
-			ip, _, err := net.SplitHostPort(r.RemoteAddr)
-			if err != nil {
-				ServerErr(w, err)
-				return
-			}
+			ip := ClientIP(r)
 			mu.Lock()
 			if _, existed := clients[ip]; !existed {
 				clients[ip] = &client{
@@ -160,46 +158,6 @@ func RateLimitMiddleware(rps float64, brust int) Middleware {
 	return mid
 }
 
-// CORSMiddleware unblock trustedOrigins domains
-func CORSMiddleware(trustedOrigins []string) Middleware {
-	mid := func(next http.HandlerFunc) http.HandlerFunc {
-		cors := func(w http.ResponseWriter, r *http.Request) {
-			w.Header().Add("Vary", "Origin")
-
-			// Add the "Vary: Access-Control-Request-Method" header.
-			w.Header().Add("Vary", "Access-Control-Request-Method")
-
-			origin := r.Header.Get("Origin")
-
-			if origin != "" {
-				for i := range trustedOrigins {
-					if origin == trustedOrigins[i] {
-						w.Header().Set("Access-Control-Allow-Origin", origin)
-
-						// Check if the request has the HTTP method OPTIONS and contains the
-						// "Access-Control-Request-Method" header. If it does, then we treat
-						// it as a preflight request.
-						if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
-							// Set the necessary preflight response headers, as discussed
-							// previously.
-							w.Header().Set("Access-Control-Allow-Methods", "OPTIONS, PUT, PATCH, DELETE")
-							w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
-
-							// Write the headers along with a 200 OK status and return from
-							// the middleware with no further action.
-							w.WriteHeader(http.StatusOK)
-							return
-						}
-					}
-				}
-			}
-			next(w, r)
-		}
-		return cors
-	}
-	return mid
-}
-
 // TokenMiddleware GetToken then use check token
 func TokenMiddleware(check func(string) (bool, error)) Middleware {
 	mid := func(next http.HandlerFunc) http.HandlerFunc {
@@ -228,43 +186,3 @@ func TokenMiddleware(check func(string) (bool, error)) Middleware {
 	}
 	return mid
 }
-
-var gzPool = sync.Pool{
-	New: func() any {
-		w := gzip.NewWriter(io.Discard)
-		gzip.NewWriterLevel(w, gzip.BestCompression)
-		return w
-	},
-}
-
-type gzipResponseWriter struct {
-	io.Writer
-	http.ResponseWriter
-}
-
-func (w *gzipResponseWriter) WriteHeader(status int) {
-	w.Header().Del("Content-Length")
-	w.ResponseWriter.WriteHeader(status)
-}
-
-func (w *gzipResponseWriter) Write(b []byte) (int, error) {
-	return w.Writer.Write(b)
-}
-
-// GipMiddleware good for serving static html/js/css file
-func GzipMiddleware(next http.HandlerFunc) http.HandlerFunc {
-	gzipFunc := func(w http.ResponseWriter, r *http.Request) {
-		if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
-			next(w, r)
-			return
-		}
-		w.Header().Set("Content-Encoding", "gzip")
-		gz := gzPool.Get().(*gzip.Writer)
-		defer gzPool.Put(gz)
-
-		gz.Reset(w)
-		defer gz.Close()
-		next(&gzipResponseWriter{ResponseWriter: w, Writer: gz}, r)
-	}
-	return gzipFunc
-}
@@ -0,0 +1,137 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/datewu/gtea/handler"
+	"github.com/datewu/gtea/handler/jwt"
+	"github.com/datewu/gtea/handler/session"
+)
+
+// Identity is the logged in user
+type Identity struct {
+	Subject       string         `json:"sub"`
+	Email         string         `json:"email,omitempty"`
+	EmailVerified bool           `json:"email_verified,omitempty"`
+	Name          string         `json:"name,omitempty"`
+	Groups        []string       `json:"groups,omitempty"`
+	Claims        map[string]any `json:"claims,omitempty"` // set by OnLogin, kept small in cookie mode
+	// IDToken is the id_token_hint of the logout, kept in session mode
+	// only as it would outgrow the 4KB of a cookie
+	IDToken string    `json:"id_token,omitempty"`
+	Expiry  time.Time `json:"expiry"`
+}
+
+func newIdentity(c *jwt.Claims, idToken string) (*Identity, error) {
+	id := &Identity{}
+	if err := c.Decode(id); err != nil {
+		return nil, err
+	}
+	id.IDToken = idToken
+	return id, nil
+}
+
+// merge the userinfo claims
+func (id *Identity) merge(info map[string]any) {
+	if v, ok := info["email"].(string); ok {
+		id.Email = v
+	}
+	if v, ok := info["email_verified"].(bool); ok {
+		id.EmailVerified = v
+	}
+	if v, ok := info["name"].(string); ok {
+		id.Name = v
+	}
+	if v, ok := info["groups"].([]any); ok {
+		id.Groups = id.Groups[:0]
+		for _, g := range v {
+			if s, ok := g.(string); ok {
+				id.Groups = append(id.Groups, s)
+			}
+		}
+	}
+}
+
+// sessionKey of the identity in session mode
+const sessionKey = "oidc"
+
+func (c *Client) store(w http.ResponseWriter, r *http.Request, id *Identity) error {
+	id.Expiry = c.conf.Now().Add(c.conf.MaxAge)
+	if c.conf.UseSession {
+		s, ok := session.FromContext(r.Context())
+		if !ok {
+			return errors.New("oidc: no session middleware")
+		}
+		s.Regenerate()
+		return s.Set(sessionKey, id)
+	}
+	small := *id
+	small.IDToken = ""
+	return c.identity.SetJSON(w, c.conf.CookieName, &small)
+}
+
+func (c *Client) forget(w http.ResponseWriter, r *http.Request) error {
+	if c.conf.UseSession {
+		s, ok := session.FromContext(r.Context())
+		if !ok {
+			return errors.New("oidc: no session middleware")
+		}
+		s.Destroy()
+		return nil
+	}
+	c.identity.Clear(w, c.conf.CookieName)
+	return nil
+}
+
+// Identity returns the logged in user of r
+func (c *Client) Identity(r *http.Request) (*Identity, bool) {
+	id := &Identity{}
+	if c.conf.UseSession {
+		s, ok := session.FromContext(r.Context())
+		if !ok {
+			return nil, false
+		}
+		if id, ok = session.Get[*Identity](s, sessionKey); !ok {
+			return nil, false
+		}
+	} else if err := c.identity.GetJSON(r, c.conf.CookieName, id); err != nil {
+		return nil, false
+	}
+	if !c.conf.Now().Before(id.Expiry) {
+		return nil, false
+	}
+	return id, true
+}
+
+type ctxKey struct{}
+
+// FromContext returns the identity put by Require
+func FromContext(ctx context.Context) (*Identity, bool) {
+	id, ok := ctx.Value(ctxKey{}).(*Identity)
+	return id, ok
+}
+
+// Require a logged in user, browsers navigating with GET are
+// redirected to the login route, other requests get a 401.
+func (c *Client) Require(loginPath string) handler.Middleware {
+	mid := func(next http.HandlerFunc) http.HandlerFunc {
+		check := func(w http.ResponseWriter, r *http.Request) {
+			id, ok := c.Identity(r)
+			if ok {
+				next(w, handler.SetValue(r, ctxKey{}, id))
+				return
+			}
+			if r.Method == http.MethodGet && r.Header.Get("Sec-Fetch-Mode") != "cors" {
+				http.Redirect(w, r, loginPath+"?return_to="+url.QueryEscape(r.URL.RequestURI()), http.StatusFound)
+				return
+			}
+			handler.AuthenticationRequire(w)
+		}
+		return check
+	}
+	return mid
+}
@@ -0,0 +1,448 @@
+// Package oidc is an OpenID Connect relying party, it mounts login,
+// callback and logout routes doing the authorization code flow with
+// PKCE and keeps the identity in an encrypted cookie or the session.
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/datewu/gtea/handler"
+	"github.com/datewu/gtea/handler/cookie"
+	"github.com/datewu/gtea/handler/csrf"
+	"github.com/datewu/gtea/handler/jwt"
+	"github.com/datewu/gtea/jsonlog"
+	"github.com/datewu/gtea/router"
+)
+
+// flow errors
+var (
+	ErrState = errors.New("oidc: invalid state")
+	ErrNonce = errors.New("oidc: invalid nonce")
+)
+
+// Config of a Client
+type Config struct {
+	// Issuer URL, the discovery document is read from
+	// Issuer + "/.well-known/openid-configuration"
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// RedirectURL is the absolute URL of the mounted callback route
+	RedirectURL string
+	// Scopes default "openid profile email"
+	Scopes []string
+	// UserInfo fetch the userinfo endpoint after the token exchange
+	UserInfo bool
+	// Keys encrypt the identity and flow cookies, default a random
+	// key, logins then don't survive a restart.
+	Keys *cookie.Keyring
+	// UseSession keep the identity in the session instead of a cookie,
+	// the session middleware must run before
+	UseSession bool
+	// CookieName of the identity cookie, default "oidc"
+	CookieName string
+	// MaxAge of a login, default 8 hours
+	MaxAge time.Duration
+	// AfterLogin default redirect after login, default "/"
+	AfterLogin string
+	// PostLogoutURL absolute URL the provider redirects to after its
+	// logout, without it the logout route redirects to "/"
+	PostLogoutURL string
+	// CSRF checks the token of the logout form, its middleware must
+	// also give the pages their csrf.Token. Cross site logouts are
+	// refused by Origin and Sec-Fetch-Site without it.
+	CSRF *csrf.Protector
+	// OnLogin is called with the new identity before it is stored,
+	// an error aborts the login with a 403
+	OnLogin func(r *http.Request, id *Identity) error
+	Client  *http.Client
+	Leeway  time.Duration
+	Now     func() time.Time
+}
+
+// Provider is the discovery document
+type Provider struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserinfoEndpoint      string `json:"userinfo_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+	EndSessionEndpoint    string `json:"end_session_endpoint"`
+}
+
+// Client is an OpenID Connect relying party
+type Client struct {
+	conf     Config
+	identity *cookie.Codec
+	flow     *cookie.Codec
+
+	mu       sync.Mutex
+	provider *Provider
+	verifier *jwt.Verifier
+}
+
+// New returns a Client, the provider is discovered on first use
+func New(conf Config) (*Client, error) {
+	if conf.Issuer == "" || conf.ClientID == "" || conf.RedirectURL == "" {
+		return nil, errors.New("oidc: Issuer, ClientID and RedirectURL are required")
+	}
+	conf.Issuer = strings.TrimSuffix(conf.Issuer, "/")
+	if len(conf.Scopes) == 0 {
+		conf.Scopes = []string{"openid", "profile", "email"}
+	}
+	if conf.CookieName == "" {
+		conf.CookieName = "oidc"
+	}
+	if conf.MaxAge <= 0 {
+		conf.MaxAge = 8 * time.Hour
+	}
+	if conf.AfterLogin == "" {
+		conf.AfterLogin = "/"
+	}
+	if conf.Client == nil {
+		conf.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+	if conf.Now == nil {
+		conf.Now = time.Now
+	}
+	if conf.Keys == nil {
+		key, err := cookie.GenerateKey()
+		if err != nil {
+			return nil, err
+		}
+		if conf.Keys, err = cookie.NewKeyring(key); err != nil {
+			return nil, err
+		}
+	}
+	secure := strings.HasPrefix(conf.RedirectURL, "https://")
+	return &Client{
+		conf: conf,
+		identity: cookie.New(conf.Keys, cookie.Options{
+			Encrypt: true, MaxAge: conf.MaxAge, HTTPOnly: true, Secure: secure,
+		}),
+		// the callback is a cross site top level navigation, Lax
+		// cookies are sent with it
+		flow: cookie.New(conf.Keys, cookie.Options{
+			Encrypt: true, MaxAge: 10 * time.Minute, HTTPOnly: true, Secure: secure,
+		}),
+	}, nil
+}
+
+// Provider returns the discovery document, fetched once
+func (c *Client) Provider(ctx context.Context) (*Provider, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.provider != nil {
+		return c.provider, nil
+	}
+	p := &Provider{}
+	if err := c.getJSON(ctx, c.conf.Issuer+"/.well-known/openid-configuration", "", p); err != nil {
+		return nil, err
+	}
+	if strings.TrimSuffix(p.Issuer, "/") != c.conf.Issuer {
+		return nil, fmt.Errorf("oidc: discovery issuer %q mismatch", p.Issuer)
+	}
+	if p.AuthorizationEndpoint == "" || p.TokenEndpoint == "" || p.JWKSURI == "" {
+		return nil, errors.New("oidc: incomplete discovery document")
+	}
+	c.provider = p
+	c.verifier = jwt.NewVerifier(jwt.Config{
+		Keys:       &jwt.JWKS{URL: p.JWKSURI, Client: c.conf.Client},
+		Algorithms: []string{jwt.RS256, jwt.ES256, jwt.EdDSA},
+		Issuer:     p.Issuer,
+		Audience:   c.conf.ClientID,
+		Leeway:     c.conf.Leeway,
+		Now:        c.conf.Now,
+	})
+	return p, nil
+}
+
+func (c *Client) getJSON(ctx context.Context, u, bearer string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	if bearer != "" {
+		req.Header.Set("Authorization", "Bearer "+bearer)
+	}
+	req.Header.Set("Accept", "application/json")
+	return c.do(req, v)
+}
+
+func (c *Client) do(req *http.Request, v any) error {
+	res, err := c.conf.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(res.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("oidc: %s got status %d: %s", req.URL.Path, res.StatusCode, body)
+	}
+	return json.Unmarshal(body, v)
+}
+
+// Mount the login, callback and POST logout routes under path, the
+// callback must be the RedirectURL
+func (c *Client) Mount(g *router.RoutesGroup, path string) {
+	path = strings.TrimSuffix(path, "/")
+	g.Get(path+"/login", c.Login)
+	g.Get(path+"/callback", c.Callback)
+	logout := c.Logout
+	if c.conf.CSRF != nil {
+		logout = c.conf.CSRF.Middleware(logout)
+	}
+	g.Post(path+"/logout", logout)
+}
+
+// flowState is kept in a short lived cookie between login and callback
+type flowState struct {
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+	ReturnTo string `json:"return_to"`
+}
+
+func random() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// localPath reports whether p is a path of this site, not an open
+// redirect
+func localPath(p string) bool {
+	return strings.HasPrefix(p, "/") && !strings.HasPrefix(p, "//") && !strings.HasPrefix(p, "/\\")
+}
+
+func (c *Client) flowCookie() string {
+	return c.conf.CookieName + "_flow"
+}
+
+// Login redirect to the provider, the return_to query param is where
+// the callback redirects to
+func (c *Client) Login(w http.ResponseWriter, r *http.Request) {
+	p, err := c.Provider(r.Context())
+	if err != nil {
+		handler.ServerErr(w, err)
+		return
+	}
+	st := flowState{State: random(), Nonce: random(), Verifier: random(), ReturnTo: c.conf.AfterLogin}
+	if rt := handler.ReadQuery(r, "return_to", ""); localPath(rt) {
+		st.ReturnTo = rt
+	}
+	if err := c.flow.SetJSON(w, c.flowCookie(), st); err != nil {
+		handler.ServerErr(w, err)
+		return
+	}
+	challenge := sha256.Sum256([]byte(st.Verifier))
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {c.conf.ClientID},
+		"redirect_uri":          {c.conf.RedirectURL},
+		"scope":                 {strings.Join(c.conf.Scopes, " ")},
+		"state":                 {st.State},
+		"nonce":                 {st.Nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	sep := "?"
+	if strings.Contains(p.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	http.Redirect(w, r, p.AuthorizationEndpoint+sep+q.Encode(), http.StatusFound)
+}
+
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	IDToken     string `json:"id_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
+
+// exchange the code for tokens
+func (c *Client) exchange(ctx context.Context, p *Provider, code, verifier string) (*tokenResponse, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {c.conf.RedirectURL},
+		"code_verifier": {verifier},
+		"client_id":     {c.conf.ClientID},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if c.conf.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(c.conf.ClientID), url.QueryEscape(c.conf.ClientSecret))
+	}
+	tr := &tokenResponse{}
+	if err := c.do(req, tr); err != nil {
+		return nil, err
+	}
+	if tr.IDToken == "" {
+		return nil, errors.New("oidc: no id_token in token response")
+	}
+	return tr, nil
+}
+
+// verifyIDToken check the signature, iss, aud, exp, azp and nonce
+func (c *Client) verifyIDToken(ctx context.Context, token, nonce string) (*jwt.Claims, error) {
+	claims, err := c.verifier.Verify(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	var extra struct {
+		Nonce string `json:"nonce"`
+		Azp   string `json:"azp"`
+	}
+	if err := claims.Decode(&extra); err != nil {
+		return nil, err
+	}
+	if extra.Nonce != nonce {
+		return nil, ErrNonce
+	}
+	if len(claims.Audience) > 1 && extra.Azp != c.conf.ClientID {
+		return nil, errors.New("oidc: invalid azp")
+	}
+	if claims.Subject == "" {
+		return nil, errors.New("oidc: no sub claim")
+	}
+	return claims, nil
+}
+
+// Callback exchange the code, verify the ID token and store the
+// identity
+func (c *Client) Callback(w http.ResponseWriter, r *http.Request) {
+	var st flowState
+	err := c.flow.GetJSON(r, c.flowCookie(), &st)
+	c.flow.Clear(w, c.flowCookie())
+	if err != nil || st.State == "" || handler.ReadQuery(r, "state", "") != st.State {
+		handler.BadRequestErr(w, ErrState)
+		return
+	}
+	if e := handler.ReadQuery(r, "error", ""); e != "" {
+		handler.BadRequestMsg(w, "oidc: provider error: "+e+" "+handler.ReadQuery(r, "error_description", ""))
+		return
+	}
+	code := handler.ReadQuery(r, "code", "")
+	if code == "" {
+		handler.BadRequestMsg(w, "oidc: missing code")
+		return
+	}
+	ctx := r.Context()
+	p, err := c.Provider(ctx)
+	if err != nil {
+		handler.ServerErr(w, err)
+		return
+	}
+	tr, err := c.exchange(ctx, p, code, st.Verifier)
+	if err != nil {
+		handler.ServerErr(w, err)
+		return
+	}
+	claims, err := c.verifyIDToken(ctx, tr.IDToken, st.Nonce)
+	if err != nil {
+		jsonlog.Err(err, map[string]any{"oidc": "id token"})
+		handler.InvalidAuthenticationToken(w)
+		return
+	}
+	id, err := newIdentity(claims, tr.IDToken)
+	if err != nil {
+		handler.ServerErr(w, err)
+		return
+	}
+	if c.conf.UserInfo && p.UserinfoEndpoint != "" {
+		if err := c.userInfo(ctx, p, tr.AccessToken, id); err != nil {
+			handler.ServerErr(w, err)
+			return
+		}
+	}
+	if c.conf.OnLogin != nil {
+		if err := c.conf.OnLogin(r, id); err != nil {
+			jsonlog.Info("oidc login refused", map[string]any{"sub": id.Subject, "error": err.Error()})
+			handler.NotPermitted(w)
+			return
+		}
+	}
+	if err := c.store(w, r, id); err != nil {
+		handler.ServerErr(w, err)
+		return
+	}
+	http.Redirect(w, r, st.ReturnTo, http.StatusFound)
+}
+
+func (c *Client) userInfo(ctx context.Context, p *Provider, token string, id *Identity) error {
+	info := map[string]any{}
+	if err := c.getJSON(ctx, p.UserinfoEndpoint, token, &info); err != nil {
+		return err
+	}
+	if sub, _ := info["sub"].(string); sub != id.Subject {
+		return errors.New("oidc: userinfo sub mismatch")
+	}
+	id.merge(info)
+	return nil
+}
+
+// Logout forget the identity and, when the provider supports it and
+// PostLogoutURL is set, end the provider session too. Only same site
+// POST requests are accepted.
+func (c *Client) Logout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		handler.MethodNotAllow(w)
+		return
+	}
+	if crossSite(r) {
+		handler.NotPermitted(w)
+		return
+	}
+	id, _ := c.Identity(r)
+	if err := c.forget(w, r); err != nil {
+		handler.ServerErr(w, err)
+		return
+	}
+	target := "/"
+	if c.conf.PostLogoutURL != "" {
+		target = c.conf.PostLogoutURL
+		p, err := c.Provider(r.Context())
+		if err == nil && p.EndSessionEndpoint != "" {
+			q := url.Values{"client_id": {c.conf.ClientID}, "post_logout_redirect_uri": {c.conf.PostLogoutURL}}
+			if id != nil && id.IDToken != "" {
+				q.Set("id_token_hint", id.IDToken)
+			}
+			target = p.EndSessionEndpoint + "?" + q.Encode()
+		}
+	}
+	http.Redirect(w, r, target, http.StatusSeeOther)
+}
+
+// crossSite reports a request sent by another site, by Sec-Fetch-Site
+// or an Origin other than the request host
+func crossSite(r *http.Request) bool {
+	if r.Header.Get("Sec-Fetch-Site") == "cross-site" {
+		return true
+	}
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return false
+	}
+	u, err := url.Parse(origin)
+	return err != nil || !strings.EqualFold(u.Host, r.Host)
+}
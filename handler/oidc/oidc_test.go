@@ -0,0 +1,206 @@
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/datewu/gtea/handler"
+	"github.com/datewu/gtea/handler/jwt"
+	"github.com/datewu/gtea/handler/session"
+	"github.com/datewu/gtea/router"
+)
+
+// fakeProvider is an in process OpenID provider
+type fakeProvider struct {
+	*httptest.Server
+	key   *rsa.PrivateKey
+	codes map[string]url.Values // code to the authorize params
+}
+
+func newFakeProvider(t *testing.T) *fakeProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &fakeProvider{key: key, codes: map[string]url.Values{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		handler.OKJSON(w, map[string]string{
+			"issuer":                 p.URL,
+			"authorization_endpoint": p.URL + "/authorize",
+			"token_endpoint":         p.URL + "/token",
+			"userinfo_endpoint":      p.URL + "/userinfo",
+			"jwks_uri":               p.URL + "/jwks",
+			"end_session_endpoint":   p.URL + "/logout",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		b64 := base64.RawURLEncoding
+		handler.OKJSON(w, map[string]any{"keys": []map[string]string{{
+			"kty": "RSA", "kid": "k1", "alg": "RS256", "use": "sig",
+			"n": b64.EncodeToString(key.N.Bytes()),
+			"e": b64.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		id, secret, _ := r.BasicAuth()
+		auth, ok := p.codes[r.PostFormValue("code")]
+		delete(p.codes, r.PostFormValue("code"))
+		sum := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+		if !ok || id != "app" || secret != "s3cret" ||
+			base64.RawURLEncoding.EncodeToString(sum[:]) != auth.Get("code_challenge") ||
+			r.PostFormValue("redirect_uri") != auth.Get("redirect_uri") {
+			handler.WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"}, nil)
+			return
+		}
+		now := time.Now()
+		token, _ := jwt.Sign(jwt.RS256, "k1", key, map[string]any{
+			"iss": p.URL, "sub": "u1", "aud": "app", "nonce": auth.Get("nonce"),
+			"iat": now.Unix(), "exp": now.Add(time.Hour).Unix(), "name": "Alice",
+		})
+		handler.OKJSON(w, map[string]any{"access_token": "at", "id_token": token, "token_type": "Bearer"})
+	})
+	mux.HandleFunc("/userinfo", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer at" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		handler.OKJSON(w, map[string]any{"sub": "u1", "email": "alice@example.com", "email_verified": true})
+	})
+	p.Server = httptest.NewServer(mux)
+	t.Cleanup(p.Close)
+	return p
+}
+
+// authorize acts as the user consenting, it returns the callback URL
+func (p *fakeProvider) authorize(t *testing.T, location string) string {
+	u, err := url.Parse(location)
+	if err != nil || !strings.HasPrefix(location, p.URL+"/authorize") {
+		t.Fatalf("unexpected authorize redirect %q", location)
+	}
+	q := u.Query()
+	if q.Get("code_challenge_method") != "S256" || q.Get("nonce") == "" {
+		t.Fatalf("expected PKCE and nonce in %q", location)
+	}
+	p.codes["code1"] = q
+	return q.Get("redirect_uri") + "?code=code1&state=" + url.QueryEscape(q.Get("state"))
+}
+
+type browser struct {
+	h       http.Handler
+	cookies map[string]string
+}
+
+func (b *browser) get(target string) *httptest.ResponseRecorder {
+	return b.do(http.MethodGet, target, nil)
+}
+
+func (b *browser) do(method, target string, headers map[string]string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, nil)
+	for k, v := range headers {
+		r.Header.Set(k, v)
+	}
+	for k, v := range b.cookies {
+		r.AddCookie(&http.Cookie{Name: k, Value: v})
+	}
+	w := httptest.NewRecorder()
+	b.h.ServeHTTP(w, r)
+	for _, c := range w.Result().Cookies() {
+		if c.MaxAge < 0 {
+			delete(b.cookies, c.Name)
+		} else {
+			b.cookies[c.Name] = c.Value
+		}
+	}
+	return w
+}
+
+func setup(t *testing.T, useSession bool) (*fakeProvider, *browser) {
+	p := newFakeProvider(t)
+	c, err := New(Config{
+		Issuer: p.URL, ClientID: "app", ClientSecret: "s3cret",
+		RedirectURL: "http://app.test/auth/callback", UserInfo: true, UseSession: useSession,
+		PostLogoutURL: "http://app.test/",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, _ := router.NewRoutesGroup(&router.Config{})
+	if useSession {
+		m, _ := session.New(session.Config{Store: session.NewMemory(0)})
+		g.Use(m.Middleware)
+	}
+	c.Mount(g, "/auth")
+	g.Get("/dash", c.Require("/auth/login")(func(w http.ResponseWriter, r *http.Request) {
+		id, _ := FromContext(r.Context())
+		handler.OKText(w, id.Subject+" "+id.Name+" "+id.Email)
+	}))
+	return p, &browser{h: g.Handler(), cookies: map[string]string{}}
+}
+
+func TestLoginFlow(t *testing.T) {
+	for _, useSession := range []bool{false, true} {
+		p, b := setup(t, useSession)
+		w := b.get("http://app.test/dash")
+		if w.Code != http.StatusFound || w.Header().Get("Location") != "/auth/login?return_to=%2Fdash" {
+			t.Fatalf("expected a redirect to login got %d %q", w.Code, w.Header().Get("Location"))
+		}
+		w = b.get("http://app.test/auth/login?return_to=/dash")
+		callback := p.authorize(t, w.Header().Get("Location"))
+		w = b.get(callback)
+		if w.Code != http.StatusFound || w.Header().Get("Location") != "/dash" {
+			t.Fatalf("expected a redirect to /dash got %d %s", w.Code, w.Body)
+		}
+		if w = b.get("http://app.test/dash"); w.Body.String() != "u1 Alice alice@example.com" {
+			t.Errorf("unexpected identity %q", w.Body)
+		}
+		if !useSession && len(b.cookies["oidc"]) > 1024 {
+			t.Errorf("expected a small identity cookie got %d bytes", len(b.cookies["oidc"]))
+		}
+		if w = b.get("http://app.test/auth/logout"); w.Code < http.StatusBadRequest {
+			t.Errorf("expected a GET logout refused got %d", w.Code)
+		}
+		w = b.do(http.MethodPost, "http://app.test/auth/logout", map[string]string{"Origin": "https://evil.com"})
+		if w.Code != http.StatusForbidden {
+			t.Errorf("expected a cross site logout refused got %d", w.Code)
+		}
+		w = b.do(http.MethodPost, "http://app.test/auth/logout", map[string]string{"Origin": "http://app.test"})
+		loc, _ := url.Parse(w.Header().Get("Location"))
+		if !strings.HasPrefix(loc.String(), p.URL+"/logout") || loc.Query().Get("client_id") != "app" ||
+			(loc.Query().Get("id_token_hint") != "") != useSession {
+			t.Errorf("expected end session redirect got %q", loc)
+		}
+		if w = b.get("http://app.test/dash"); w.Code != http.StatusFound {
+			t.Errorf("expected logged out got %d", w.Code)
+		}
+	}
+}
+
+func TestCallbackRejects(t *testing.T) {
+	p, b := setup(t, false)
+	w := b.get("http://app.test/auth/login?return_to=//evil.com")
+	callback := p.authorize(t, w.Header().Get("Location"))
+	if w := b.get(strings.Replace(callback, "state=", "state=x", 1)); w.Code != http.StatusBadRequest {
+		t.Errorf("expected bad state rejected got %d", w.Code)
+	}
+	w = b.get("http://app.test/auth/login?return_to=//evil.com")
+	callback = p.authorize(t, w.Header().Get("Location"))
+	if w := b.get(callback); w.Header().Get("Location") != "/" {
+		t.Errorf("expected open redirect ignored got %q", w.Header().Get("Location"))
+	}
+	var out map[string]any
+	json.Unmarshal(b.get("http://app.test/auth/callback?code=code1").Body.Bytes(), &out)
+	if out["error"] == nil {
+		t.Error("expected a callback without flow cookie rejected")
+	}
+}
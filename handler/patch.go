@@ -0,0 +1,567 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"mime"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Patch media types
+const (
+	JSONPatchType  = "application/json-patch+json"  // RFC 6902
+	MergePatchType = "application/merge-patch+json" // RFC 7396
+)
+
+// ErrPatchTestFailed is wrapped by the PatchError of a failed test operation
+var ErrPatchTestFailed = errors.New("test operation failed")
+
+// PatchError describe why a patch could not be parsed or applied,
+// Status is the http status code should be responsed:
+// 400 for a malformed patch, 409 for a failed test operation,
+// 415 for an unsupported media type, 422 for path errors.
+type PatchError struct {
+	Status int
+	Op     string
+	Path   string
+	Err    error
+}
+
+func (e *PatchError) Error() string {
+	if e.Op == "" {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("%s operation on path %q: %s", e.Op, e.Path, e.Err)
+}
+
+func (e *PatchError) Unwrap() error {
+	return e.Err
+}
+
+func malformedPatch(format string, a ...any) *PatchError {
+	return &PatchError{Status: http.StatusBadRequest, Err: fmt.Errorf(format, a...)}
+}
+
+// PatchErr response a patch error with its status code,
+// other errors are treated as 400
+func PatchErr(w http.ResponseWriter, err error) {
+	var pe *PatchError
+	if errors.As(err, &pe) {
+		errResponse(w, pe.Status, pe.Error())
+		return
+	}
+	BadRequestErr(w, err)
+}
+
+// PatchOperation is a single RFC 6902 operation
+type PatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// JSONPatch is a RFC 6902 patch document
+type JSONPatch []PatchOperation
+
+// ParseJSONPatch parse and validate a RFC 6902 patch document
+func ParseJSONPatch(data []byte) (JSONPatch, error) {
+	var p JSONPatch
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if err := dec.Decode(&p); err != nil {
+		return nil, malformedPatch("patch must be an array of operations: %s", err)
+	}
+	if dec.More() {
+		return nil, malformedPatch("patch must only contain a single json value")
+	}
+	for i, op := range p {
+		if err := op.validate(); err != nil {
+			return nil, malformedPatch("operation %d: %s", i, err)
+		}
+	}
+	return p, nil
+}
+
+func (o PatchOperation) validate() error {
+	switch o.Op {
+	case "add", "replace", "test":
+		if o.Value == nil {
+			return fmt.Errorf("%s operation requires a value", o.Op)
+		}
+	case "remove":
+	case "move", "copy":
+		if _, err := parsePointer(o.From); err != nil {
+			return err
+		}
+	case "":
+		return errors.New("missing op")
+	default:
+		return fmt.Errorf("unknown op %q", o.Op)
+	}
+	_, err := parsePointer(o.Path)
+	return err
+}
+
+// Apply apply the patch to a raw JSON document and return the patched document
+func (p JSONPatch) Apply(doc []byte) ([]byte, error) {
+	node, err := decodeDoc(doc)
+	if err != nil {
+		return nil, err
+	}
+	for _, op := range p {
+		node, err = op.apply(node)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return json.Marshal(node)
+}
+
+// ApplyTo apply the patch to the value pointed by dst
+func (p JSONPatch) ApplyTo(dst any) error {
+	return applyTo(dst, p.Apply)
+}
+
+func (o PatchOperation) apply(doc any) (any, error) {
+	fail := func(status int, err error) (any, error) {
+		return nil, &PatchError{Status: status, Op: o.Op, Path: o.Path, Err: err}
+	}
+	path, err := parsePointer(o.Path)
+	if err != nil {
+		return fail(http.StatusBadRequest, err)
+	}
+	var value any
+	if o.Value != nil {
+		value, err = decodeDoc(o.Value)
+		if err != nil {
+			return fail(http.StatusBadRequest, err)
+		}
+	}
+	switch o.Op {
+	case "add":
+		doc, err = path.add(doc, value)
+	case "remove":
+		doc, _, err = path.remove(doc)
+	case "replace":
+		doc, err = path.replace(doc, value)
+	case "move":
+		from, _ := parsePointer(o.From)
+		if from.isProperPrefix(path) {
+			return fail(http.StatusUnprocessableEntity,
+				errors.New("cannot move a value into one of its children"))
+		}
+		var v any
+		doc, v, err = from.remove(doc)
+		if err == nil {
+			doc, err = path.add(doc, v)
+		}
+	case "copy":
+		from, _ := parsePointer(o.From)
+		var v any
+		v, err = from.get(doc)
+		if err == nil {
+			doc, err = path.add(doc, deepCopy(v))
+		}
+	case "test":
+		var v any
+		v, err = path.get(doc)
+		if err == nil && !jsonEqual(v, value) {
+			return fail(http.StatusConflict, ErrPatchTestFailed)
+		}
+	default:
+		return fail(http.StatusBadRequest, fmt.Errorf("unknown op %q", o.Op))
+	}
+	if err != nil {
+		return fail(http.StatusUnprocessableEntity, err)
+	}
+	return doc, nil
+}
+
+// MergePatch is a RFC 7396 merge patch document
+type MergePatch json.RawMessage
+
+// ParseMergePatch validate a RFC 7396 merge patch document
+func ParseMergePatch(data []byte) (MergePatch, error) {
+	if _, err := decodeDoc(data); err != nil {
+		return nil, malformedPatch("%s", err)
+	}
+	return MergePatch(data), nil
+}
+
+// Apply merge the patch into a raw JSON document and return the patched document
+func (p MergePatch) Apply(doc []byte) ([]byte, error) {
+	target, err := decodeDoc(doc)
+	if err != nil {
+		return nil, err
+	}
+	patch, err := decodeDoc(p)
+	if err != nil {
+		return nil, malformedPatch("%s", err)
+	}
+	return json.Marshal(mergePatch(target, patch))
+}
+
+// ApplyTo merge the patch into the value pointed by dst
+func (p MergePatch) ApplyTo(dst any) error {
+	return applyTo(dst, p.Apply)
+}
+
+func mergePatch(target, patch any) any {
+	obj, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+	t, ok := target.(map[string]any)
+	if !ok {
+		t = make(map[string]any)
+	}
+	for k, v := range obj {
+		if v == nil {
+			delete(t, k)
+			continue
+		}
+		t[k] = mergePatch(t[k], v)
+	}
+	return t
+}
+
+// ReadJSONPatch reads a application/json-patch+json request body
+func ReadJSONPatch(w http.ResponseWriter, r *http.Request, max int64) (JSONPatch, error) {
+	data, err := readPatchBody(w, r, max, JSONPatchType)
+	if err != nil {
+		return nil, err
+	}
+	return ParseJSONPatch(data)
+}
+
+// ReadMergePatch reads a application/merge-patch+json request body
+func ReadMergePatch(w http.ResponseWriter, r *http.Request, max int64) (MergePatch, error) {
+	data, err := readPatchBody(w, r, max, MergePatchType)
+	if err != nil {
+		return nil, err
+	}
+	return ParseMergePatch(data)
+}
+
+// ReadPatch reads a JSON Patch or a JSON Merge Patch request body
+// by its Content-Type and apply it to the value pointed by dst.
+// Respond the returned error with PatchErr.
+func ReadPatch(w http.ResponseWriter, r *http.Request, dst any, max int64) error {
+	data, err := readPatchBody(w, r, max, JSONPatchType, MergePatchType)
+	if err != nil {
+		return err
+	}
+	mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mt == JSONPatchType {
+		p, err := ParseJSONPatch(data)
+		if err != nil {
+			return err
+		}
+		return p.ApplyTo(dst)
+	}
+	p, err := ParseMergePatch(data)
+	if err != nil {
+		return err
+	}
+	return p.ApplyTo(dst)
+}
+
+func readPatchBody(w http.ResponseWriter, r *http.Request, max int64, types ...string) ([]byte, error) {
+	mt, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	supported := false
+	for _, t := range types {
+		if err == nil && mt == t {
+			supported = true
+		}
+	}
+	if !supported {
+		return nil, &PatchError{
+			Status: http.StatusUnsupportedMediaType,
+			Err:    fmt.Errorf("content type must be %s", strings.Join(types, " or ")),
+		}
+	}
+	if max == 0 {
+		max = 8 * 1_048_576
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, max)
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			return nil, &PatchError{
+				Status: http.StatusRequestEntityTooLarge,
+				Err:    fmt.Errorf("body must not be larger than %d bytes", max),
+			}
+		}
+		return nil, malformedPatch("%s", err)
+	}
+	if len(bytes.TrimSpace(data)) == 0 {
+		return nil, malformedPatch("body must not be empty")
+	}
+	return data, nil
+}
+
+func applyTo(dst any, apply func([]byte) ([]byte, error)) error {
+	rv := reflect.ValueOf(dst)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return errors.New("patch destination must be a non-nil pointer")
+	}
+	doc, err := json.Marshal(dst)
+	if err != nil {
+		return err
+	}
+	out, err := apply(doc)
+	if err != nil {
+		return err
+	}
+	fresh := reflect.New(rv.Elem().Type())
+	if err := decodeJSON(bytes.NewReader(out), fresh.Interface()); err != nil {
+		return &PatchError{Status: http.StatusUnprocessableEntity, Err: err}
+	}
+	rv.Elem().Set(fresh.Elem())
+	return nil
+}
+
+func decodeDoc(data []byte) (any, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	if dec.More() {
+		return nil, errors.New("document must only contain a single json value")
+	}
+	return v, nil
+}
+
+// pointer is a parsed RFC 6901 JSON pointer
+type pointer []string
+
+var pointerUnescaper = strings.NewReplacer("~1", "/", "~0", "~")
+
+func parsePointer(s string) (pointer, error) {
+	if s == "" {
+		return pointer{}, nil
+	}
+	if !strings.HasPrefix(s, "/") {
+		return nil, fmt.Errorf("invalid json pointer %q", s)
+	}
+	tokens := strings.Split(s[1:], "/")
+	for i, t := range tokens {
+		tokens[i] = pointerUnescaper.Replace(t)
+	}
+	return pointer(tokens), nil
+}
+
+func (p pointer) isProperPrefix(o pointer) bool {
+	if len(p) >= len(o) {
+		return false
+	}
+	for i := range p {
+		if p[i] != o[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (p pointer) get(doc any) (any, error) {
+	node := doc
+	for _, t := range p {
+		switch n := node.(type) {
+		case map[string]any:
+			v, ok := n[t]
+			if !ok {
+				return nil, fmt.Errorf("member %q not found", t)
+			}
+			node = v
+		case []any:
+			i, err := arrayIndex(t, len(n)-1)
+			if err != nil {
+				return nil, err
+			}
+			node = n[i]
+		default:
+			return nil, fmt.Errorf("cannot traverse into %q", t)
+		}
+	}
+	return node, nil
+}
+
+// mutate call fn on the parent container of the pointed location,
+// fn returns the new container replacing the old one.
+func (p pointer) mutate(doc any, fn func(parent any, key string) (any, error)) (any, error) {
+	if len(p) == 1 {
+		return fn(doc, p[0])
+	}
+	child, err := p[:1].get(doc)
+	if err != nil {
+		return nil, err
+	}
+	child, err = p[1:].mutate(child, fn)
+	if err != nil {
+		return nil, err
+	}
+	switch n := doc.(type) {
+	case map[string]any:
+		n[p[0]] = child
+	case []any:
+		i, _ := arrayIndex(p[0], len(n)-1)
+		n[i] = child
+	}
+	return doc, nil
+}
+
+func (p pointer) add(doc, value any) (any, error) {
+	if len(p) == 0 {
+		return value, nil
+	}
+	return p.mutate(doc, func(parent any, key string) (any, error) {
+		switch n := parent.(type) {
+		case map[string]any:
+			n[key] = value
+			return n, nil
+		case []any:
+			if key == "-" {
+				return append(n, value), nil
+			}
+			i, err := arrayIndex(key, len(n))
+			if err != nil {
+				return nil, err
+			}
+			n = append(n, nil)
+			copy(n[i+1:], n[i:])
+			n[i] = value
+			return n, nil
+		}
+		return nil, fmt.Errorf("cannot add member %q to a non container value", key)
+	})
+}
+
+func (p pointer) remove(doc any) (any, any, error) {
+	if len(p) == 0 {
+		return nil, nil, errors.New("cannot remove the whole document")
+	}
+	var removed any
+	doc, err := p.mutate(doc, func(parent any, key string) (any, error) {
+		switch n := parent.(type) {
+		case map[string]any:
+			v, ok := n[key]
+			if !ok {
+				return nil, fmt.Errorf("member %q not found", key)
+			}
+			removed = v
+			delete(n, key)
+			return n, nil
+		case []any:
+			i, err := arrayIndex(key, len(n)-1)
+			if err != nil {
+				return nil, err
+			}
+			removed = n[i]
+			return append(n[:i], n[i+1:]...), nil
+		}
+		return nil, fmt.Errorf("cannot remove member %q from a non container value", key)
+	})
+	return doc, removed, err
+}
+
+func (p pointer) replace(doc, value any) (any, error) {
+	if _, err := p.get(doc); err != nil {
+		return nil, err
+	}
+	if len(p) == 0 {
+		return value, nil
+	}
+	return p.mutate(doc, func(parent any, key string) (any, error) {
+		switch n := parent.(type) {
+		case map[string]any:
+			n[key] = value
+			return n, nil
+		case []any:
+			i, _ := arrayIndex(key, len(n)-1)
+			n[i] = value
+			return n, nil
+		}
+		return nil, fmt.Errorf("cannot replace member %q of a non container value", key)
+	})
+}
+
+// arrayIndex parse an array index token no larger than max
+func arrayIndex(token string, max int) (int, error) {
+	if token == "" || (len(token) > 1 && token[0] == '0') ||
+		strings.TrimLeft(token, "0123456789") != "" {
+		return 0, fmt.Errorf("invalid array index %q", token)
+	}
+	i, err := strconv.Atoi(token)
+	if err != nil || i > max {
+		return 0, fmt.Errorf("array index %q out of bounds", token)
+	}
+	return i, nil
+}
+
+func deepCopy(v any) any {
+	switch n := v.(type) {
+	case map[string]any:
+		m := make(map[string]any, len(n))
+		for k, e := range n {
+			m[k] = deepCopy(e)
+		}
+		return m
+	case []any:
+		s := make([]any, len(n))
+		for i, e := range n {
+			s[i] = deepCopy(e)
+		}
+		return s
+	}
+	return v
+}
+
+func jsonEqual(a, b any) bool {
+	switch x := a.(type) {
+	case map[string]any:
+		y, ok := b.(map[string]any)
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for k, v := range x {
+			w, ok := y[k]
+			if !ok || !jsonEqual(v, w) {
+				return false
+			}
+		}
+		return true
+	case []any:
+		y, ok := b.([]any)
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for i := range x {
+			if !jsonEqual(x[i], y[i]) {
+				return false
+			}
+		}
+		return true
+	case json.Number:
+		y, ok := b.(json.Number)
+		if !ok {
+			return false
+		}
+		fx, _, errx := big.ParseFloat(x.String(), 10, 256, big.ToNearestEven)
+		fy, _, erry := big.ParseFloat(y.String(), 10, 256, big.ToNearestEven)
+		if errx != nil || erry != nil {
+			return x == y
+		}
+		return fx.Cmp(fy) == 0
+	}
+	return a == b
+}
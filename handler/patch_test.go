@@ -0,0 +1,111 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestJSONPatch(t *testing.T) {
+	table := []struct {
+		doc, patch, expect string
+		status             int
+	}{
+		{`{"foo":"bar"}`, `[{"op":"add","path":"/baz","value":"qux"}]`, `{"baz":"qux","foo":"bar"}`, 0},
+		{`{"foo":["bar","baz"]}`, `[{"op":"add","path":"/foo/1","value":"qux"}]`, `{"foo":["bar","qux","baz"]}`, 0},
+		{`{"foo":["bar"]}`, `[{"op":"add","path":"/foo/-","value":null}]`, `{"foo":["bar",null]}`, 0},
+		{`{"baz":"qux","foo":"bar"}`, `[{"op":"remove","path":"/baz"}]`, `{"foo":"bar"}`, 0},
+		{`{"foo":["bar","qux","baz"]}`, `[{"op":"remove","path":"/foo/1"}]`, `{"foo":["bar","baz"]}`, 0},
+		{`{"baz":"qux"}`, `[{"op":"replace","path":"/baz","value":"boo"}]`, `{"baz":"boo"}`, 0},
+		{`{"foo":{"bar":"baz","waldo":"fred"},"qux":{"corge":"grault"}}`,
+			`[{"op":"move","from":"/foo/waldo","path":"/qux/thud"}]`,
+			`{"foo":{"bar":"baz"},"qux":{"corge":"grault","thud":"fred"}}`, 0},
+		{`{"a/b":{"m~n":1}}`, `[{"op":"copy","from":"/a~1b/m~0n","path":"/c"}]`, `{"a/b":{"m~n":1},"c":1}`, 0},
+		{`{"baz":"qux","foo":["a",2,"c"]}`,
+			`[{"op":"test","path":"/baz","value":"qux"},{"op":"test","path":"/foo/1","value":2.0}]`,
+			`{"baz":"qux","foo":["a",2,"c"]}`, 0},
+		{`{"baz":"qux"}`, `[{"op":"test","path":"/baz","value":"bar"}]`, "", http.StatusConflict},
+		{`{"foo":"bar"}`, `[{"op":"add","path":"/baz/bat","value":"qux"}]`, "", http.StatusUnprocessableEntity},
+		{`{"foo":[1]}`, `[{"op":"remove","path":"/foo/01"}]`, "", http.StatusUnprocessableEntity},
+		{`{"foo":{}}`, `[{"op":"move","from":"/foo","path":"/foo/bar"}]`, "", http.StatusUnprocessableEntity},
+		{`{}`, `[{"op":"add","path":"/a"}]`, "", http.StatusBadRequest},
+		{`{}`, `[{"op":"lol","path":"/a"}]`, "", http.StatusBadRequest},
+		{`{}`, `{"op":"add"}`, "", http.StatusBadRequest},
+	}
+	for _, c := range table {
+		p, err := ParseJSONPatch([]byte(c.patch))
+		var got []byte
+		if err == nil {
+			got, err = p.Apply([]byte(c.doc))
+		}
+		if c.status != 0 {
+			var pe *PatchError
+			if !errors.As(err, &pe) || pe.Status != c.status {
+				t.Errorf("%s: expected status %d got %v", c.patch, c.status, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", c.patch, err)
+			continue
+		}
+		if string(got) != c.expect {
+			t.Errorf("%s: expected %s got %s", c.patch, c.expect, got)
+		}
+	}
+}
+
+func TestMergePatch(t *testing.T) {
+	doc := `{"title":"Goodbye!","author":{"givenName":"John","familyName":"Doe"},"tags":["example","sample"],"content":"This will be unchanged"}`
+	patch := `{"title":"Hello!","phoneNumber":"+01-123-456-7890","author":{"familyName":null},"tags":["example"]}`
+	expect := `{"author":{"givenName":"John"},"content":"This will be unchanged","phoneNumber":"+01-123-456-7890","tags":["example"],"title":"Hello!"}`
+	got, err := MergePatch(patch).Apply([]byte(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != expect {
+		t.Errorf("expected %s got %s", expect, got)
+	}
+}
+
+func TestReadPatch(t *testing.T) {
+	type user struct {
+		Name string   `json:"name"`
+		Age  int      `json:"age"`
+		Tags []string `json:"tags"`
+	}
+	req := func(ct, body string) *http.Request {
+		r := httptest.NewRequest(http.MethodPatch, "/u/1", strings.NewReader(body))
+		r.Header.Set("Content-Type", ct)
+		return r
+	}
+	u := user{Name: "bob", Age: 9, Tags: []string{"a"}}
+	w := httptest.NewRecorder()
+	err := ReadPatch(w, req(JSONPatchType, `[{"op":"replace","path":"/age","value":10},{"op":"add","path":"/tags/0","value":"z"}]`), &u, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Age != 10 || len(u.Tags) != 2 || u.Tags[0] != "z" {
+		t.Errorf("unexpected patched value %+v", u)
+	}
+	err = ReadPatch(w, req(MergePatchType+"; charset=utf-8", `{"name":"joe","tags":null}`), &u, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Name != "joe" || u.Tags != nil || u.Age != 10 {
+		t.Errorf("unexpected merged value %+v", u)
+	}
+	err = ReadPatch(w, req(MergePatchType, `{"unknown":1}`), &u, 0)
+	PatchErr(w, err)
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected %d got %d", http.StatusUnprocessableEntity, w.Code)
+	}
+	w = httptest.NewRecorder()
+	err = ReadPatch(w, req("application/json", `{}`), &u, 0)
+	PatchErr(w, err)
+	if w.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("expected %d got %d", http.StatusUnsupportedMediaType, w.Code)
+	}
+}
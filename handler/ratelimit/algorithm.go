@@ -0,0 +1,261 @@
+package ratelimit
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Limiter decides whether the next request of a key is allowed
+type Limiter interface {
+	Allow(ctx context.Context, key string) (Result, error)
+}
+
+// Algorithm builds the Limiter of a rule keeping its state in store
+type Algorithm func(rule Rule, store Store) Limiter
+
+// algorithm names of router.Config.Limiter.Algorithm
+var algorithms = map[string]Algorithm{
+	"":               TokenBucket,
+	"token_bucket":   TokenBucket,
+	"sliding_log":    SlidingLog,
+	"sliding_window": SlidingWindow,
+	"gcra":           GCRA,
+}
+
+// ParseAlgorithm returns the Algorithm of a name: token_bucket,
+// sliding_log, sliding_window or gcra
+func ParseAlgorithm(name string) (Algorithm, error) {
+	a, ok := algorithms[name]
+	if !ok {
+		return nil, fmt.Errorf("ratelimit: unknown algorithm %q", name)
+	}
+	return a, nil
+}
+
+// now is the clock of the algorithms
+var now = time.Now
+
+// tokenBucket keeps golang.org/x/time/rate limiters in process, its
+// state can't go through a Store
+type tokenBucket struct {
+	rule    Rule
+	mu      sync.Mutex
+	buckets map[string]*rate.Limiter
+	stop    chan struct{}
+	once    sync.Once
+}
+
+// TokenBucket is an in process golang.org/x/time/rate token bucket,
+// the store is not used. A goroutine drops full buckets until Close.
+func TokenBucket(rule Rule, _ Store) Limiter {
+	b := &tokenBucket{rule: rule, buckets: make(map[string]*rate.Limiter), stop: make(chan struct{})}
+	go b.cleanup()
+	return b
+}
+
+// cleanup drop full buckets, they are the same as new ones
+func (b *tokenBucket) cleanup() {
+	tick := time.NewTicker(time.Minute)
+	defer tick.Stop()
+	for {
+		select {
+		case <-b.stop:
+			return
+		case <-tick.C:
+			t := now()
+			b.mu.Lock()
+			for k, l := range b.buckets {
+				if l.TokensAt(t) >= float64(b.rule.Burst) {
+					delete(b.buckets, k)
+				}
+			}
+			b.mu.Unlock()
+		}
+	}
+}
+
+// Close stop the cleanup goroutine
+func (b *tokenBucket) Close() error {
+	b.once.Do(func() { close(b.stop) })
+	return nil
+}
+
+func (b *tokenBucket) Allow(_ context.Context, key string) (Result, error) {
+	t := now()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	l, ok := b.buckets[key]
+	if !ok {
+		l = rate.NewLimiter(rate.Limit(b.rule.Rps), b.rule.Burst)
+		b.buckets[key] = l
+	}
+	res := Result{Allowed: l.AllowN(t, 1), Limit: b.rule.Burst}
+	tokens := l.TokensAt(t)
+	res.Remaining = int(math.Max(0, math.Floor(tokens)))
+	res.Reset = seconds((float64(b.rule.Burst) - tokens) / b.rule.Rps)
+	if !res.Allowed {
+		res.RetryAfter = seconds((1 - tokens) / b.rule.Rps)
+	}
+	return res, nil
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(math.Max(0, s) * float64(time.Second))
+}
+
+func putInt64s(vs ...int64) []byte {
+	b := make([]byte, 8*len(vs))
+	for i, v := range vs {
+		binary.BigEndian.PutUint64(b[8*i:], uint64(v))
+	}
+	return b
+}
+
+func int64s(b []byte) []int64 {
+	vs := make([]int64, len(b)/8)
+	for i := range vs {
+		vs[i] = int64(binary.BigEndian.Uint64(b[8*i:]))
+	}
+	return vs
+}
+
+// slidingLog keeps the time of every allowed request of the window
+type slidingLog struct {
+	rule  Rule
+	store Store
+}
+
+// SlidingLog allows Burst requests in any Burst/Rps window, exact but
+// it stores a timestamp per request
+func SlidingLog(rule Rule, store Store) Limiter {
+	return &slidingLog{rule: rule, store: store}
+}
+
+func (s *slidingLog) Allow(ctx context.Context, key string) (Result, error) {
+	window := s.rule.window()
+	res := Result{Limit: s.rule.Burst}
+	err := s.store.Update(ctx, key, window, func(state []byte) ([]byte, error) {
+		t := now().UnixNano()
+		log := int64s(state)
+		i := 0
+		for i < len(log) && log[i] <= t-int64(window) {
+			i++
+		}
+		log = log[i:]
+		if len(log) < s.rule.Burst {
+			log = append(log, t)
+			res.Allowed = true
+		} else {
+			res.RetryAfter = time.Duration(log[0] + int64(window) - t)
+		}
+		res.Remaining = s.rule.Burst - len(log)
+		res.Reset = time.Duration(log[len(log)-1] + int64(window) - t)
+		return putInt64s(log...), nil
+	})
+	return res, err
+}
+
+// slidingWindow weights the count of the previous fixed window
+type slidingWindow struct {
+	rule  Rule
+	store Store
+}
+
+// SlidingWindow approximates SlidingLog with two counters, the count
+// of the previous window weighted by its overlap with the sliding one
+func SlidingWindow(rule Rule, store Store) Limiter {
+	return &slidingWindow{rule: rule, store: store}
+}
+
+func (s *slidingWindow) Allow(ctx context.Context, key string) (Result, error) {
+	window := int64(s.rule.window())
+	limit := float64(s.rule.Burst)
+	res := Result{Limit: s.rule.Burst}
+	err := s.store.Update(ctx, key, 2*time.Duration(window), func(state []byte) ([]byte, error) {
+		t := now().UnixNano()
+		start := t - t%window
+		var prev, curr int64
+		if vs := int64s(state); len(vs) == 3 {
+			switch vs[0] {
+			case start:
+				prev, curr = vs[1], vs[2]
+			case start - window:
+				prev = vs[2]
+			}
+		}
+		elapsed := t - start
+		weight := float64(window-elapsed) / float64(window)
+		estimate := float64(prev)*weight + float64(curr)
+		if estimate+1 <= limit {
+			curr++
+			estimate++
+			res.Allowed = true
+		} else {
+			res.RetryAfter = s.retryAfter(prev, curr, elapsed, window)
+		}
+		res.Remaining = int(math.Max(0, math.Floor(limit-estimate)))
+		res.Reset = time.Duration(2*window - elapsed)
+		return putInt64s(start, prev, curr), nil
+	})
+	return res, err
+}
+
+// retryAfter solve when the estimate leaves room for one request
+func (s *slidingWindow) retryAfter(prev, curr, elapsed, window int64) time.Duration {
+	room := float64(s.rule.Burst) - 1 - float64(curr)
+	if room >= 0 && prev > 0 {
+		// prev * (window - e) / window <= room
+		e := float64(window) - room*float64(window)/float64(prev)
+		return time.Duration(math.Ceil(e)) - time.Duration(elapsed)
+	}
+	// wait for the next window, where curr becomes prev
+	next := window - elapsed
+	if curr > 0 {
+		room = float64(s.rule.Burst) - 1
+		e := float64(window) - room*float64(window)/float64(curr)
+		next += time.Duration(math.Max(0, e)).Nanoseconds()
+	}
+	return time.Duration(next)
+}
+
+// gcra keeps the theoretical arrival time of the next request
+type gcra struct {
+	rule  Rule
+	store Store
+}
+
+// GCRA is the generic cell rate algorithm, a token bucket of Burst
+// refilled at Rps storing a single timestamp per key
+func GCRA(rule Rule, store Store) Limiter {
+	return &gcra{rule: rule, store: store}
+}
+
+func (g *gcra) Allow(ctx context.Context, key string) (Result, error) {
+	interval := int64(float64(time.Second) / g.rule.Rps)
+	tolerance := interval * int64(g.rule.Burst)
+	res := Result{Limit: g.rule.Burst}
+	err := g.store.Update(ctx, key, time.Duration(tolerance), func(state []byte) ([]byte, error) {
+		t := now().UnixNano()
+		tat := t
+		if vs := int64s(state); len(vs) == 1 && vs[0] > t {
+			tat = vs[0]
+		}
+		next := tat + interval
+		if next-t <= tolerance {
+			tat = next
+			res.Allowed = true
+		} else {
+			res.RetryAfter = time.Duration(next - t - tolerance)
+		}
+		res.Remaining = int((tolerance - (tat - t)) / interval)
+		res.Reset = time.Duration(tat - t)
+		return putInt64s(tat), nil
+	})
+	return res, err
+}
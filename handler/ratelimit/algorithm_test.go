@@ -0,0 +1,131 @@
+package ratelimit
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func fakeClock(t *testing.T) func(time.Duration) {
+	c := time.Unix(1_700_000_000, 0)
+	now = func() time.Time { return c }
+	t.Cleanup(func() { now = time.Now })
+	return func(d time.Duration) { c = c.Add(d) }
+}
+
+func TestAlgorithms(t *testing.T) {
+	file, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	rule := Rule{Rps: 1, Burst: 3} // 3 per 3s window
+	for name, alg := range map[string]Algorithm{"sliding_log": SlidingLog, "sliding_window": SlidingWindow, "gcra": GCRA} {
+		for _, store := range []Store{NewMemoryStore(4), file} {
+			advance := fakeClock(t)
+			lim := alg(rule, prefixStore{prefix: name, Store: store})
+			for i := 0; i < 3; i++ {
+				res, err := lim.Allow(ctx, "k")
+				if err != nil || !res.Allowed || res.Remaining != 2-i {
+					t.Fatalf("%s %T: expected request %d allowed got %+v %v", name, store, i, res, err)
+				}
+			}
+			res, _ := lim.Allow(ctx, "k")
+			if res.Allowed || res.RetryAfter <= 0 || res.RetryAfter > 3*time.Second {
+				t.Errorf("%s %T: expected limited with a retry after got %+v", name, store, res)
+			}
+			if res, _ := lim.Allow(ctx, "other"); !res.Allowed {
+				t.Errorf("%s %T: expected keys apart", name, store)
+			}
+			advance(res.RetryAfter)
+			if res, _ := lim.Allow(ctx, "k"); !res.Allowed {
+				t.Errorf("%s %T: expected allowed after retry after %+v", name, store, res)
+			}
+			advance(10 * time.Second)
+			if res, _ := lim.Allow(ctx, "k"); !res.Allowed || res.Remaining != 2 {
+				t.Errorf("%s %T: expected a full limit after idle got %+v", name, store, res)
+			}
+		}
+	}
+	if _, err := ParseAlgorithm("leaky"); err == nil {
+		t.Error("expected unknown algorithm error")
+	}
+}
+
+func TestFileStoreConcurrent(t *testing.T) {
+	store, _ := NewFileStore(t.TempDir())
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			store.Update(context.Background(), "n", time.Minute, func(s []byte) ([]byte, error) {
+				v := int64(0)
+				if vs := int64s(s); len(vs) == 1 {
+					v = vs[0]
+				}
+				return putInt64s(v + 1), nil
+			})
+		}()
+	}
+	wg.Wait()
+	store.Update(context.Background(), "n", time.Minute, func(s []byte) ([]byte, error) {
+		if vs := int64s(s); len(vs) != 1 || vs[0] != 20 {
+			t.Errorf("expected 20 serialized updates got %v", vs)
+		}
+		return s, nil
+	})
+	if n, _ := store.RemoveExpired(); n != 0 {
+		t.Errorf("expected nothing expired got %d", n)
+	}
+}
+
+func TestFileStoreLock(t *testing.T) {
+	store, _ := NewFileStore(t.TempDir())
+	sum := sha256.Sum256([]byte("n"))
+	l, err := store.lock(context.Background(), hex.EncodeToString(sum[:16]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err = store.Update(ctx, "n", time.Minute, func(s []byte) ([]byte, error) { return s, nil })
+	if err != context.DeadlineExceeded {
+		t.Errorf("expected the update waiting for the lock got %v", err)
+	}
+	l.Close()
+	if err := store.Update(context.Background(), "n", time.Minute, func(s []byte) ([]byte, error) { return s, nil }); err != nil {
+		t.Errorf("expected the released lock taken got %v", err)
+	}
+}
+
+func TestStoreCleanup(t *testing.T) {
+	dir := t.TempDir()
+	store, _ := NewFileStore(dir)
+	l := New(Config{Store: store, CleanupInterval: 10 * time.Millisecond})
+	defer l.Close()
+	store.Update(context.Background(), "n", time.Millisecond, func(s []byte) ([]byte, error) { return []byte("x"), nil })
+	states := func() int {
+		n := 0
+		entries, _ := os.ReadDir(dir)
+		for _, e := range entries {
+			if len(e.Name()) == 32 {
+				n++
+			}
+		}
+		return n
+	}
+	if states() != 1 {
+		t.Fatal("expected a state file")
+	}
+	for i := 0; i < 50 && states() > 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if states() != 0 {
+		t.Error("expected the expired key removed by the cleanup goroutine")
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd)
+
+package ratelimit
+
+import (
+	"errors"
+	"os"
+)
+
+// flock is not available, FileStore updates fail
+func flock(*os.File) (bool, error) {
+	return false, errors.New("ratelimit: FileStore needs flock")
+}
@@ -0,0 +1,110 @@
+package ratelimit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+
+	"github.com/datewu/gtea/handler"
+	"github.com/datewu/gtea/handler/apikey"
+	"github.com/datewu/gtea/handler/jwt"
+)
+
+// KeyFunc returns the bucket key of a request, the client IP is used
+// when the key is empty
+type KeyFunc func(r *http.Request) string
+
+// IP keys on the client IP of handler.ClientIP
+func IP(r *http.Request) string {
+	return handler.ClientIP(r)
+}
+
+func hash(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:16])
+}
+
+// verified reports whether the jwt or apikey middleware authenticated
+// r, the credentials of the other requests are whatever clients send
+func verified(r *http.Request) bool {
+	if _, ok := jwt.FromContext(r.Context()); ok {
+		return true
+	}
+	_, ok := apikey.FromContext(r.Context())
+	return ok
+}
+
+// Header keys on the hash of the request header carrying the verified
+// credential, e.g. "X-API-Key" of apikey. Like User it needs the jwt
+// or apikey middleware to run before: the key is empty, falling back to the
+// client IP, for the requests not authenticated.
+func Header(name string) KeyFunc {
+	return func(r *http.Request) string {
+		v := r.Header.Get(name)
+		if v == "" || !verified(r) {
+			return ""
+		}
+		return hash(v)
+	}
+}
+
+// Token keys on the hash of the token of handler.GetToken, once
+// verified by the jwt or apikey middleware as for Header
+func Token(r *http.Request) string {
+	t, err := handler.GetToken(r, "")
+	if err != nil || !verified(r) {
+		return ""
+	}
+	return hash(t)
+}
+
+// User keys on the user id of resolve, e.g. the ID of the principal
+// of authz.DefaultResolver. The auth middleware must run before, so
+// User only works in the Middleware of a RateLimiter used by a route
+// group after its auth middleware: the router level limiter runs
+// before any group middleware and its User keys are always empty.
+func User(resolve func(*http.Request) (string, bool)) KeyFunc {
+	return func(r *http.Request) string {
+		id, ok := resolve(r)
+		if !ok {
+			return ""
+		}
+		return id
+	}
+}
+
+// Route keys on the route pattern, limiting a route for all clients
+func Route(r *http.Request) string {
+	if p := handler.RoutePattern(r); p != "" {
+		return r.Method + " " + p
+	}
+	return r.Method + " " + r.URL.Path
+}
+
+// Combine keys on every key, e.g. Combine(Route, IP) for a bucket per
+// client and route. The key is empty if any is.
+func Combine(fns ...KeyFunc) KeyFunc {
+	return func(r *http.Request) string {
+		keys := make([]string, len(fns))
+		for i, fn := range fns {
+			if keys[i] = fn(r); keys[i] == "" {
+				return ""
+			}
+		}
+		return strings.Join(keys, "|")
+	}
+}
+
+// First keys on the first non empty key, e.g. First(User(resolve),
+// Route) for anonymous clients to share a bucket per route
+func First(fns ...KeyFunc) KeyFunc {
+	return func(r *http.Request) string {
+		for _, fn := range fns {
+			if k := fn(r); k != "" {
+				return k
+			}
+		}
+		return ""
+	}
+}
@@ -0,0 +1,205 @@
+// Package ratelimit limits requests per key, the client IP, token,
+// user or route, with a rule per route and the RateLimit-* headers.
+package ratelimit
+
+import (
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/datewu/gtea/handler"
+	"github.com/datewu/gtea/jsonlog"
+)
+
+// Rule allows Burst requests at once refilled at Rps, window
+// algorithms allow Burst requests per Burst/Rps window
+type Rule struct {
+	Rps   float64
+	Burst int
+	Key   KeyFunc // default IP
+}
+
+// window of the window algorithms
+func (r Rule) window() time.Duration {
+	return time.Duration(float64(r.Burst) / r.Rps * float64(time.Second))
+}
+
+// Config of a RateLimiter
+type Config struct {
+	// Rule of every request without a route rule
+	Rule
+	// Routes rules replacing the default one, by route pattern
+	// "/login" or method and pattern "POST /login"
+	Routes map[string]Rule
+	// Algorithm of every rule, default TokenBucket
+	Algorithm Algorithm
+	// Store of the algorithms state, default a MemoryStore. The
+	// RemoveExpired method of a FileStore is called every
+	// CleanupInterval, default 1 minute.
+	Store           Store
+	CleanupInterval time.Duration
+	// Exceeded responds to limited requests, default a 429 json error
+	Exceeded http.HandlerFunc
+}
+
+// Result of a decision
+type Result struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	Reset      time.Duration // until the full limit is available again
+	RetryAfter time.Duration // until the next request is allowed
+}
+
+// RateLimiter limits requests by rules
+type RateLimiter struct {
+	conf     Config
+	limiters map[string]Limiter // by rule name, "" the default rule
+	stop     chan struct{}
+	once     sync.Once
+	wg       sync.WaitGroup
+}
+
+// expirer is a Store removing its expired keys, e.g. a FileStore
+type expirer interface {
+	RemoveExpired() (int, error)
+}
+
+// New returns a RateLimiter, Close it to stop the background work
+// of its limiters
+func New(conf Config) *RateLimiter {
+	if conf.Exceeded == nil {
+		conf.Exceeded = func(w http.ResponseWriter, _ *http.Request) {
+			handler.RateLimitExceede(w)
+		}
+	}
+	if conf.Algorithm == nil {
+		conf.Algorithm = TokenBucket
+	}
+	if conf.Store == nil {
+		conf.Store = NewMemoryStore(0)
+	}
+	if conf.CleanupInterval <= 0 {
+		conf.CleanupInterval = time.Minute
+	}
+	l := &RateLimiter{conf: conf, limiters: make(map[string]Limiter), stop: make(chan struct{})}
+	l.add("", conf.Rule)
+	for name, rule := range conf.Routes {
+		l.add(name, rule)
+	}
+	if s, ok := conf.Store.(expirer); ok {
+		l.wg.Add(1)
+		go l.cleanup(s)
+	}
+	return l
+}
+
+// cleanup remove the expired keys of the store until Close
+func (l *RateLimiter) cleanup(s expirer) {
+	defer l.wg.Done()
+	tick := time.NewTicker(l.conf.CleanupInterval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-tick.C:
+			if _, err := s.RemoveExpired(); err != nil {
+				jsonlog.Err(err, map[string]any{"ratelimit": "cleanup"})
+			}
+		}
+	}
+}
+
+func (l *RateLimiter) add(name string, rule Rule) {
+	if rule.Burst <= 0 || rule.Rps <= 0 {
+		return
+	}
+	l.limiters[name] = l.conf.Algorithm(rule, prefixStore{prefix: name + "\x00", Store: l.conf.Store})
+}
+
+// Close stop the background work of the limiters and the store
+// cleanup, App.AddClearFn accepts it
+func (l *RateLimiter) Close() {
+	l.once.Do(func() { close(l.stop) })
+	l.wg.Wait()
+	for _, lim := range l.limiters {
+		if c, ok := lim.(io.Closer); ok {
+			c.Close()
+		}
+	}
+}
+
+// rule returns the rule of r and its name
+func (l *RateLimiter) rule(r *http.Request) (string, Rule) {
+	if len(l.conf.Routes) > 0 {
+		pattern := handler.RoutePattern(r)
+		if rule, ok := l.conf.Routes[r.Method+" "+pattern]; ok {
+			return r.Method + " " + pattern, rule
+		}
+		if rule, ok := l.conf.Routes[pattern]; ok {
+			return pattern, rule
+		}
+	}
+	return "", l.conf.Rule
+}
+
+// ceilSeconds format d as the whole seconds of the headers
+func ceilSeconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
+
+// SetHeaders set the RateLimit-Limit, RateLimit-Remaining,
+// RateLimit-Reset and, when limited, Retry-After headers
+func SetHeaders(w http.ResponseWriter, res Result) {
+	h := w.Header()
+	h.Set("RateLimit-Limit", strconv.Itoa(res.Limit))
+	h.Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+	h.Set("RateLimit-Reset", ceilSeconds(res.Reset))
+	if !res.Allowed {
+		h.Set("Retry-After", ceilSeconds(res.RetryAfter))
+	}
+}
+
+// Middleware limit requests by the rule of their route, requests are
+// let through when the store fails
+func (l *RateLimiter) Middleware(next http.HandlerFunc) http.HandlerFunc {
+	middle := func(w http.ResponseWriter, r *http.Request) {
+		name, rule := l.rule(r)
+		lim, ok := l.limiters[name]
+		if !ok {
+			next(w, r)
+			return
+		}
+		keyFn := rule.Key
+		if keyFn == nil {
+			keyFn = IP
+		}
+		key := keyFn(r)
+		if strings.TrimSpace(key) == "" {
+			// e.g. no token, such requests mustn't escape the limit
+			key = IP(r)
+		}
+		if key == "" {
+			next(w, r)
+			return
+		}
+		res, err := lim.Allow(r.Context(), key)
+		if err != nil {
+			jsonlog.Err(err, map[string]any{"ratelimit": name})
+			next(w, r)
+			return
+		}
+		SetHeaders(w, res)
+		if !res.Allowed {
+			l.conf.Exceeded(w, r)
+			return
+		}
+		next(w, r)
+	}
+	return middle
+}
@@ -0,0 +1,130 @@
+package ratelimit_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/datewu/gtea/handler"
+	"github.com/datewu/gtea/handler/jwt"
+	"github.com/datewu/gtea/handler/ratelimit"
+	"github.com/datewu/gtea/router"
+)
+
+func TestRouterLimits(t *testing.T) {
+	conf := &router.Config{}
+	conf.Limiter.Enabled = true
+	conf.Limiter.Rps = 1
+	conf.Limiter.Burst = 3
+	conf.Limiter.Key = ratelimit.First(ratelimit.Header("X-API-Key"), ratelimit.IP)
+	conf.Limiter.Routes = map[string]ratelimit.Rule{
+		"POST /login": {Rps: 0.1, Burst: 1, Key: ratelimit.Combine(ratelimit.Route, ratelimit.IP)},
+	}
+	g, _ := router.NewRoutesGroup(conf)
+	g.Post("/login", handler.HealthCheck)
+	g.Get("/users/:id", handler.HealthCheck)
+	h := g.Handler()
+	defer h.Close()
+
+	do := func(method, path, apiKey string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, nil)
+		if apiKey != "" {
+			r.Header.Set("X-API-Key", apiKey)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+	for i := 0; i < 3; i++ {
+		w := do(http.MethodGet, "/users/1", "")
+		if w.Code != http.StatusOK || w.Header().Get("RateLimit-Limit") != "3" {
+			t.Fatalf("expected request %d allowed got %d %v", i, w.Code, w.Header())
+		}
+	}
+	w := do(http.MethodGet, "/users/2", "")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "1" ||
+		w.Header().Get("RateLimit-Remaining") != "0" || w.Header().Get("RateLimit-Reset") != "3" {
+		t.Errorf("expected limited with headers got %d %v", w.Code, w.Header())
+	}
+	if w := do(http.MethodGet, "/users/2", "key1"); w.Code != http.StatusTooManyRequests {
+		t.Errorf("expected an unverified api key limited by IP got %d", w.Code)
+	}
+	if w := do(http.MethodPost, "/login", ""); w.Code != http.StatusOK {
+		t.Errorf("expected the route rule in its own bucket got %d", w.Code)
+	}
+	w = do(http.MethodPost, "/login", "")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "10" {
+		t.Errorf("expected the login rule got %d %v", w.Code, w.Header())
+	}
+}
+
+func TestKeys(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/x", nil)
+	r.RemoteAddr = "10.0.0.1:1234"
+	if k := ratelimit.Combine(ratelimit.Route, ratelimit.IP)(r); k != "GET /x|10.0.0.1" {
+		t.Errorf("unexpected combined key %q", k)
+	}
+	if k := ratelimit.Combine(ratelimit.Token, ratelimit.IP)(r); k != "" {
+		t.Errorf("expected no key without token got %q", k)
+	}
+	r.Header.Set("X-API-Key", "key1")
+	if k := ratelimit.Header("X-API-Key")(r); k != "" {
+		t.Errorf("expected no key for an unverified header got %q", k)
+	}
+	ar := r.WithContext(jwt.NewContext(r.Context(), &jwt.Claims{Subject: "u1"}))
+	if k := ratelimit.Header("X-API-Key")(ar); k == "" {
+		t.Error("expected a key for a verified header")
+	}
+	user := ratelimit.User(func(r *http.Request) (string, bool) {
+		id := r.Header.Get("X-User")
+		return id, id != ""
+	})
+	if k := ratelimit.First(user, ratelimit.IP)(r); k != "10.0.0.1" {
+		t.Errorf("expected the IP of anonymous clients got %q", k)
+	}
+	r.Header.Set("X-User", "u1")
+	if k := ratelimit.First(user, ratelimit.IP)(r); k != "u1" {
+		t.Errorf("expected the user key got %q", k)
+	}
+	l := ratelimit.New(ratelimit.Config{})
+	l.Close()
+	l.Close()
+}
+
+func TestRouterAlgorithm(t *testing.T) {
+	conf := &router.Config{}
+	conf.Limiter.Enabled = true
+	conf.Limiter.Rps = 1
+	conf.Limiter.Burst = 2
+	conf.Limiter.Algorithm = "sliding_log"
+	conf.Limiter.Store = ratelimit.NewMemoryStore(0)
+	g, _ := router.NewRoutesGroup(conf)
+	h := g.Handler()
+	defer h.Close()
+	codes := []int{}
+	for i := 0; i < 3; i++ {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/healthcheck", nil))
+		codes = append(codes, w.Code)
+	}
+	if codes[1] != http.StatusOK || codes[2] != http.StatusTooManyRequests {
+		t.Errorf("expected the third request limited got %v", codes)
+	}
+}
+
+func TestEmptyKeyFallsBackToIP(t *testing.T) {
+	l := ratelimit.New(ratelimit.Config{Rule: ratelimit.Rule{Rps: 1, Burst: 1, Key: ratelimit.Token}})
+	defer l.Close()
+	h := l.Middleware(handler.HealthCheck)
+	codes := []int{}
+	for i := 0; i < 2; i++ {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = "10.0.0.1:1234"
+		w := httptest.NewRecorder()
+		h(w, r)
+		codes = append(codes, w.Code)
+	}
+	if codes[0] != http.StatusOK || codes[1] != http.StatusTooManyRequests {
+		t.Errorf("expected requests without token limited by IP got %v", codes)
+	}
+}
@@ -0,0 +1,210 @@
+package ratelimit
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"hash/fnv"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Store keeps the state of the algorithms, it could be shared by
+// replicas
+type Store interface {
+	// Update replace the state of key with what fn returns, atomically
+	// per key. state is nil for a new or expired key, the new state
+	// expires after ttl.
+	Update(ctx context.Context, key string, ttl time.Duration, fn func(state []byte) ([]byte, error)) error
+}
+
+// prefixStore separates the keys of the rules of a RateLimiter
+type prefixStore struct {
+	prefix string
+	Store
+}
+
+func (p prefixStore) Update(ctx context.Context, key string, ttl time.Duration, fn func([]byte) ([]byte, error)) error {
+	return p.Store.Update(ctx, p.prefix+key, ttl, fn)
+}
+
+type memEntry struct {
+	state  []byte
+	expire time.Time
+}
+
+type shard struct {
+	mu      sync.Mutex
+	entries map[string]memEntry
+	updates int
+}
+
+// MemoryStore is an in process Store sharded to reduce lock contention
+type MemoryStore struct {
+	shards []*shard
+}
+
+// NewMemoryStore returns a MemoryStore of n shards, default 32
+func NewMemoryStore(n int) *MemoryStore {
+	if n <= 0 {
+		n = 32
+	}
+	m := &MemoryStore{shards: make([]*shard, n)}
+	for i := range m.shards {
+		m.shards[i] = &shard{entries: make(map[string]memEntry)}
+	}
+	return m
+}
+
+// Update the state of key
+func (m *MemoryStore) Update(_ context.Context, key string, ttl time.Duration, fn func([]byte) ([]byte, error)) error {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	s := m.shards[h.Sum32()%uint32(len(m.shards))]
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t := now()
+	var state []byte
+	if e, ok := s.entries[key]; ok && t.Before(e.expire) {
+		state = e.state
+	}
+	state, err := fn(state)
+	if err != nil {
+		return err
+	}
+	s.entries[key] = memEntry{state: state, expire: t.Add(ttl)}
+	// sweep the expired keys every 1024 updates of a shard
+	if s.updates++; s.updates%1024 == 0 {
+		for k, e := range s.entries {
+			if !t.Before(e.expire) {
+				delete(s.entries, k)
+			}
+		}
+	}
+	return nil
+}
+
+// FileStore is a Store of a file per key in a directory, processes of
+// a host share it. The updates of a key are serialized by a flock on
+// one of 256 lock files, the locks of a dead process are released by
+// the kernel.
+type FileStore struct {
+	dir string
+}
+
+// NewFileStore returns a FileStore in dir, dir is created if missing
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+// lock the stripe of the state file name, closing the returned file
+// unlocks it. Lock files are never removed, so every process locks
+// the same file.
+func (f *FileStore) lock(ctx context.Context, name string) (*os.File, error) {
+	l, err := os.OpenFile(filepath.Join(f.dir, ".lock-"+name[:2]), os.O_CREATE|os.O_RDWR, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		ok, err := flock(l)
+		if ok {
+			return l, nil
+		}
+		if err != nil {
+			l.Close()
+			return nil, err
+		}
+		select {
+		case <-ctx.Done():
+			l.Close()
+			return nil, ctx.Err()
+		case <-time.After(time.Millisecond):
+		}
+	}
+}
+
+// Update the state of key
+func (f *FileStore) Update(ctx context.Context, key string, ttl time.Duration, fn func([]byte) ([]byte, error)) error {
+	sum := sha256.Sum256([]byte(key))
+	base := hex.EncodeToString(sum[:16])
+	l, err := f.lock(ctx, base)
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+	name := filepath.Join(f.dir, base)
+	t := now()
+	var state []byte
+	data, err := os.ReadFile(name)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if len(data) >= 8 && t.UnixNano() < int64(binary.BigEndian.Uint64(data)) {
+		state = data[8:]
+	}
+	state, err = fn(state)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(f.dir, ".state-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(putInt64s(t.Add(ttl).UnixNano()), state...)); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
+
+// RemoveExpired delete the files of expired keys
+func (f *FileStore) RemoveExpired() (int, error) {
+	entries, err := os.ReadDir(f.dir)
+	if err != nil {
+		return 0, err
+	}
+	t := now().UnixNano()
+	n := 0
+	for _, e := range entries {
+		// state files are named by 32 hex digits
+		if e.IsDir() || len(e.Name()) != 32 || strings.Trim(e.Name(), "0123456789abcdef") != "" {
+			continue
+		}
+		if f.removeExpired(e.Name(), t) {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// removeExpired delete the state file name if it expired before t,
+// under the lock of its key
+func (f *FileStore) removeExpired(name string, t int64) bool {
+	l, err := f.lock(context.Background(), name)
+	if err != nil {
+		return false
+	}
+	defer l.Close()
+	path := filepath.Join(f.dir, name)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false
+	}
+	if err != nil || len(data) >= 8 && t < int64(binary.BigEndian.Uint64(data)) {
+		return false
+	}
+	return os.Remove(path) == nil
+}
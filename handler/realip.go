@@ -0,0 +1,152 @@
+package handler
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+type clientIPKey struct{}
+
+// ClientIP returns the client IP found by the RealIP middleware, or
+// the RemoteAddr host without it. Built-in middlewares use it.
+func ClientIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(clientIPKey{}).(string); ok {
+		return ip
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// IsHTTPS reports whether r came over TLS, to the server or to a proxy
+// setting X-Forwarded-Proto
+func IsHTTPS(r *http.Request) bool {
+	return r.TLS != nil || strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https")
+}
+
+// RealIPConfig of the RealIP middleware
+type RealIPConfig struct {
+	// TrustedProxies are the CIDRs or IPs of the proxies in front
+	// of the server, the headers of other peers are ignored
+	TrustedProxies []string
+	// Headers read in order, the first present one is used, default
+	// Forwarded, X-Forwarded-For, X-Real-IP
+	Headers []string
+}
+
+// RealIP returns a middleware putting the client IP in the request
+// context for ClientIP. The hops of the header are walked from the
+// right, the first one not a trusted proxy is the client, so a client
+// can't spoof its IP by sending the header itself.
+func RealIP(conf RealIPConfig) (Middleware, error) {
+	var trusted []netip.Prefix
+	for _, s := range conf.TrustedProxies {
+		if !strings.Contains(s, "/") {
+			addr, err := netip.ParseAddr(s)
+			if err != nil {
+				return nil, fmt.Errorf("realip: invalid trusted proxy %q", s)
+			}
+			trusted = append(trusted, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			return nil, fmt.Errorf("realip: invalid trusted proxy %q", s)
+		}
+		trusted = append(trusted, p.Masked())
+	}
+	headers := conf.Headers
+	if len(headers) == 0 {
+		headers = []string{"Forwarded", "X-Forwarded-For", "X-Real-IP"}
+	}
+	isTrusted := func(a netip.Addr) bool {
+		for _, p := range trusted {
+			if p.Contains(a) {
+				return true
+			}
+		}
+		return false
+	}
+	mid := func(next http.HandlerFunc) http.HandlerFunc {
+		realIP := func(w http.ResponseWriter, r *http.Request) {
+			ip := ClientIP(r)
+			if remote, err := netip.ParseAddr(ip); err == nil && isTrusted(remote.Unmap()) {
+				ip = clientIP(r, headers, remote.Unmap(), isTrusted)
+			}
+			next(w, SetValue(r, clientIPKey{}, ip))
+		}
+		return realIP
+	}
+	return mid, nil
+}
+
+// clientIP walk the hops of the first present header from the right
+func clientIP(r *http.Request, headers []string, remote netip.Addr, trusted func(netip.Addr) bool) string {
+	for _, h := range headers {
+		values := r.Header.Values(h)
+		if len(values) == 0 {
+			continue
+		}
+		var hops []string
+		switch http.CanonicalHeaderKey(h) {
+		case "Forwarded":
+			hops = forwardedFor(values)
+		case "X-Real-Ip":
+			hops = []string{strings.TrimSpace(values[len(values)-1])}
+		default:
+			for _, v := range values {
+				for _, hop := range strings.Split(v, ",") {
+					hops = append(hops, strings.TrimSpace(hop))
+				}
+			}
+		}
+		client := remote
+		for i := len(hops) - 1; i >= 0; i-- {
+			addr, ok := parseHop(hops[i])
+			if !ok {
+				// unknown or obfuscated hop, keep the last trusted one
+				break
+			}
+			client = addr
+			if !trusted(addr) {
+				break
+			}
+		}
+		return client.String()
+	}
+	return remote.String()
+}
+
+// forwardedFor returns the for= params of RFC 7239 Forwarded headers
+func forwardedFor(values []string) []string {
+	var hops []string
+	for _, v := range values {
+		for _, elem := range strings.Split(v, ",") {
+			for _, pair := range strings.Split(elem, ";") {
+				k, val, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if ok && strings.EqualFold(k, "for") {
+					hops = append(hops, strings.Trim(val, `"`))
+				}
+			}
+		}
+	}
+	return hops
+}
+
+// parseHop parse an IP with an optional port, IPv6 may be in brackets
+func parseHop(s string) (netip.Addr, bool) {
+	if ap, err := netip.ParseAddrPort(s); err == nil {
+		return ap.Addr().Unmap(), true
+	}
+	s = strings.TrimSuffix(strings.TrimPrefix(s, "["), "]")
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap(), true
+}
@@ -0,0 +1,46 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRealIP(t *testing.T) {
+	mid, err := RealIP(RealIPConfig{TrustedProxies: []string{"10.0.0.0/8", "2001:db8::1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got string
+	h := mid(func(w http.ResponseWriter, r *http.Request) { got = ClientIP(r) })
+	cases := []struct {
+		name    string
+		remote  string
+		headers map[string]string
+		expect  string
+	}{
+		{"untrusted peer", "203.0.113.9:1234", map[string]string{"X-Forwarded-For": "1.2.3.4"}, "203.0.113.9"},
+		{"no header", "10.0.0.1:1234", nil, "10.0.0.1"},
+		{"spoofed left hop", "10.0.0.1:1234", map[string]string{"X-Forwarded-For": "6.6.6.6, 198.51.100.7, 10.0.0.2"}, "198.51.100.7"},
+		{"all trusted", "10.0.0.1:1234", map[string]string{"X-Forwarded-For": "10.0.0.3, 10.0.0.2"}, "10.0.0.3"},
+		{"forwarded", "[2001:db8::1]:443", map[string]string{"Forwarded": `for=6.6.6.6, for="[2001:db8:cafe::17]:4711";proto=https`}, "2001:db8:cafe::17"},
+		{"obfuscated hop", "10.0.0.1:1234", map[string]string{"Forwarded": "for=_hidden, for=10.0.0.2"}, "10.0.0.2"},
+		{"forwarded first", "10.0.0.1:1234", map[string]string{"Forwarded": "for=198.51.100.1", "X-Forwarded-For": "198.51.100.2"}, "198.51.100.1"},
+		{"real ip", "10.0.0.1:1234", map[string]string{"X-Real-IP": "198.51.100.3"}, "198.51.100.3"},
+		{"invalid", "10.0.0.1:1234", map[string]string{"X-Forwarded-For": "nonsense"}, "10.0.0.1"},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = c.remote
+		for k, v := range c.headers {
+			r.Header.Set(k, v)
+		}
+		h(httptest.NewRecorder(), r)
+		if got != c.expect {
+			t.Errorf("%s: expected %s got %s", c.name, c.expect, got)
+		}
+	}
+	if _, err := RealIP(RealIPConfig{TrustedProxies: []string{"10.0.0.0/33"}}); err == nil {
+		t.Error("expected an invalid CIDR error")
+	}
+}
@@ -0,0 +1,74 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/datewu/gtea/jsonlog"
+)
+
+// RecoverConfig of the Recover middleware
+type RecoverConfig struct {
+	// Logger of the panics, default the jsonlog default logger
+	Logger *jsonlog.Logger
+	// Development send the panic value and stack to the client,
+	// never enable it in production
+	Development bool
+	// Report the panic to an error tracker
+	Report func(r *http.Request, p any, stack []byte)
+	// Render the response, default a 500 json error
+	Render func(w http.ResponseWriter, r *http.Request, p any, stack []byte)
+}
+
+// Recover returns a middleware recovering handler panics, the panic,
+// stack and request are logged. http.ErrAbortHandler is panicked
+// again so the server aborts the connection.
+func Recover(conf RecoverConfig) Middleware {
+	logErr := jsonlog.Err
+	if conf.Logger != nil {
+		logErr = conf.Logger.Err
+	}
+	if conf.Render == nil {
+		conf.Render = func(w http.ResponseWriter, r *http.Request, p any, stack []byte) {
+			if conf.Development {
+				errResponse(w, http.StatusInternalServerError, map[string]any{
+					"error":  "the server encountered a problem and could not process your request",
+					"detail": fmt.Sprint(p),
+					"stack":  string(stack),
+				})
+				return
+			}
+			errResponse(w, http.StatusInternalServerError,
+				"the server encountered a problem and could not process your request")
+		}
+	}
+	mid := func(next http.HandlerFunc) http.HandlerFunc {
+		middle := func(w http.ResponseWriter, r *http.Request) {
+			defer func() {
+				p := recover()
+				if p == nil {
+					return
+				}
+				if p == http.ErrAbortHandler {
+					panic(p)
+				}
+				stack := debug.Stack()
+				logErr(fmt.Errorf("panic: %v", p), map[string]any{
+					"method": r.Method,
+					"uri":    r.URL.RequestURI(),
+					"ip":     ClientIP(r),
+					"stack":  string(stack),
+				})
+				if conf.Report != nil {
+					conf.Report(r, p, stack)
+				}
+				w.Header().Set("Connection", "close")
+				conf.Render(w, r, p, stack)
+			}()
+			next(w, r)
+		}
+		return middle
+	}
+	return mid
+}
@@ -0,0 +1,50 @@
+package handler
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/datewu/gtea/jsonlog"
+)
+
+func TestRecover(t *testing.T) {
+	boom := func(w http.ResponseWriter, r *http.Request) {
+		panic("db password is hunter2")
+	}
+	var logs bytes.Buffer
+	var reported any
+	conf := RecoverConfig{
+		Logger: jsonlog.New(&logs, jsonlog.LevelDebug),
+		Report: func(r *http.Request, p any, stack []byte) { reported = p },
+	}
+	w := httptest.NewRecorder()
+	Recover(conf)(boom)(w, httptest.NewRequest(http.MethodGet, "/x", nil))
+	if w.Code != http.StatusInternalServerError || strings.Contains(w.Body.String(), "hunter2") {
+		t.Errorf("expected a 500 hiding the panic got %d %s", w.Code, w.Body)
+	}
+	if !strings.Contains(logs.String(), "hunter2") || !strings.Contains(logs.String(), "stack") {
+		t.Errorf("expected the panic and stack logged got %s", logs.String())
+	}
+	if reported != "db password is hunter2" {
+		t.Errorf("expected the panic reported got %v", reported)
+	}
+
+	conf.Development = true
+	w = httptest.NewRecorder()
+	Recover(conf)(boom)(w, httptest.NewRequest(http.MethodGet, "/x", nil))
+	if !strings.Contains(w.Body.String(), "hunter2") {
+		t.Errorf("expected the panic detail in development got %s", w.Body)
+	}
+
+	defer func() {
+		if p := recover(); p != http.ErrAbortHandler {
+			t.Errorf("expected %v panicked again got %v", http.ErrAbortHandler, p)
+		}
+	}()
+	RecoverPanicMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	})(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+}
@@ -8,6 +8,7 @@ import (
 	"io"
 	"net/http"
 	"os"
+	"path"
 	"path/filepath"
 	"strconv"
 	"strings"
@@ -36,18 +37,24 @@ func parseFormfile(r *http.Request, name string) (string, io.ReadCloser, error)
 
 }
 
-// SaveFormFile write file to a dir with upload filename.
+// SaveFormFile write file to a dir with the base name of upload filename.
+// Use the upload package for large or untrusted uploads.
 func SaveFormFile(r *http.Request, name, dir string) (string, error) {
 	fn, src, err := parseFormfile(r, name)
 	if err != nil {
 		return "", err
 	}
 	defer src.Close()
+	fn = path.Base(path.Clean("/" + strings.ReplaceAll(fn, `\`, "/")))
+	if fn == "/" || fn == "." || fn == ".." {
+		return "", errors.New("invalid upload filename")
+	}
 	fullPath := filepath.Join(dir, fn)
 	dst, err := os.Create(fullPath)
 	if err != nil {
 		return "", err
 	}
+	defer dst.Close()
 	_, err = io.Copy(dst, src)
 	if err != nil {
 		return "", err
@@ -120,8 +127,16 @@ type PathRegs string
 const (
 	ParamsCtxKey   PathRegs = "path_param_names"
 	ParamsCtxValue PathRegs = "path_param_values"
+	RouteCtxKey    PathRegs = "route_pattern"
 )
 
+// RoutePattern returns the registered route of the request,
+// e.g. "/users/:id", empty when the request matched no route
+func RoutePattern(r *http.Request) string {
+	p, _ := r.Context().Value(RouteCtxKey).(string)
+	return p
+}
+
 // ReadPathParam returns the string param value in the request path
 func ReadPathParam(r *http.Request, name string) string {
 	keys := r.Context().Value(ParamsCtxKey)
@@ -219,19 +234,28 @@ func ReadInt64Query(r *http.Request, key string, defaultValue int64) int64 {
 	return i
 }
 
-// ReadJSON reads the request body up to the max size and unmarshal it to the given struct
+// ReadMaxJSON reads the request body up to the max size and unmarshal
+// it to the given struct, it returns the errors of ReadJSON
 func ReadMaxJSON(w http.ResponseWriter, r *http.Request, dst any, max int64) error {
 	if max == 0 {
 		max = 8 * 1_048_576 // 8MB for max readJSON body
 	}
 	r.Body = http.MaxBytesReader(w, r.Body, max)
-	err := decodeJSON(r.Body, dst)
-	if err != nil {
-		if err.Error() == "http: request body too large" {
-			return fmt.Errorf("body must not be larger than %d bytes", max)
-		}
-	}
-	return nil
+	return decodeJSON(r.Body, dst)
+}
+
+// tooLargeError is a *http.MaxBytesError with the message of the 413
+// envelope, BadRequestErr answers it with BodyTooLarge
+type tooLargeError struct {
+	*http.MaxBytesError
+}
+
+func (e tooLargeError) Error() string {
+	return fmt.Sprintf("body must not be larger than %d bytes", e.Limit)
+}
+
+func (e tooLargeError) Unwrap() error {
+	return e.MaxBytesError
 }
 
 // ReadJSON reads the request body and unmarshal it to the given struct
@@ -247,8 +271,11 @@ func decodeJSON(r io.Reader, dst any) error {
 		var syntaxErr *json.SyntaxError
 		var unmarshalErr *json.UnmarshalTypeError
 		var invalidUnmarshalErr *json.InvalidUnmarshalError
+		var maxErr *http.MaxBytesError
 
 		switch {
+		case errors.As(err, &maxErr):
+			return tooLargeError{maxErr}
 		case errors.As(err, &syntaxErr):
 			return fmt.Errorf("body contains badly-formed JSON (at character %d)", syntaxErr.Offset)
 		case errors.Is(err, io.ErrUnexpectedEOF):
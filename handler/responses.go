@@ -1,6 +1,7 @@
 package handler
 
 import (
+	"errors"
 	"net/http"
 	"time"
 )
@@ -13,20 +14,24 @@ func ClearSimpleCookie(w http.ResponseWriter, name string) {
 		Path:     "/",
 		Value:    "",
 		MaxAge:   -1,
+		SameSite: http.SameSiteLaxMode,
 	}
 	http.SetCookie(w, &cookie)
 }
 
-// SetSimpleCookie set key value within a week
+// SetSimpleCookie set key value within a week, the cookie is host only,
+// SameSite=Lax and Secure when the request came over https.
+// Use the cookie package for signed or encrypted values.
 func SetSimpleCookie(w http.ResponseWriter, r *http.Request, k, v string) {
 	du := 7 * 24 * time.Hour
 	expire := time.Now().Add(du)
 	cookie := http.Cookie{
 		Name: k, Value: v,
 		Path:    "/",
-		Domain:  r.URL.Host,
 		Expires: expire, MaxAge: int(du.Seconds()),
 		HttpOnly: true,
+		Secure:   IsHTTPS(r),
+		SameSite: http.SameSiteLaxMode,
 	}
 	http.SetCookie(w, &cookie)
 }
@@ -59,6 +64,12 @@ func RateLimitExceede(w http.ResponseWriter) {
 		"rate limit exceeded")
 }
 
+// ServiceUnavailable 503 response, the server is overloaded
+func ServiceUnavailable(w http.ResponseWriter) {
+	errResponse(w, http.StatusServiceUnavailable,
+		"the server is temporarily unable to handle the request, please try later")
+}
+
 // InvalidCredentials 400 response for bad reruest
 func InvalidCredentials(w http.ResponseWriter) {
 	errResponse(w, http.StatusBadRequest,
@@ -99,8 +110,14 @@ func BadRequestMsg(w http.ResponseWriter, msg string) {
 	errResponse(w, http.StatusBadRequest, msg)
 }
 
-// BadRequestErr 400 response with a error
+// BadRequestErr 400 response with a error, a *http.MaxBytesError of
+// a limited body gets the 413 of BodyTooLarge
 func BadRequestErr(w http.ResponseWriter, err error) {
+	var maxErr *http.MaxBytesError
+	if errors.As(err, &maxErr) {
+		BodyTooLarge(w, maxErr.Limit)
+		return
+	}
 	BadRequestMsg(w, err.Error())
 }
 
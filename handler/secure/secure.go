@@ -0,0 +1,247 @@
+// Package secure sets the security headers of the responses, with a
+// per request Content-Security-Policy nonce for the templates and an
+// endpoint logging the CSP violation reports.
+package secure
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/datewu/gtea/handler"
+	"github.com/datewu/gtea/jsonlog"
+)
+
+// NoncePlaceholder in Config.CSP is replaced by the 'nonce-...' source
+// of the request
+const NoncePlaceholder = "{nonce}"
+
+// HSTS is the Strict-Transport-Security header, sent on HTTPS requests
+type HSTS struct {
+	// MaxAge of the policy, 0 disables the header
+	MaxAge            time.Duration
+	IncludeSubdomains bool
+	Preload           bool
+}
+
+// String returns the header value, "" when disabled
+func (h HSTS) String() string {
+	if h.MaxAge <= 0 {
+		return ""
+	}
+	v := "max-age=" + strconv.Itoa(int(h.MaxAge.Seconds()))
+	if h.IncludeSubdomains {
+		v += "; includeSubDomains"
+	}
+	if h.Preload {
+		v += "; preload"
+	}
+	return v
+}
+
+// Config of the headers, an empty value skips its header. API and HTML
+// return the presets.
+type Config struct {
+	HSTS HSTS
+	// CSP is the Content-Security-Policy, NoncePlaceholder is
+	// replaced by a new nonce every request
+	CSP string
+	// ReportOnly sends the CSP as Content-Security-Policy-Report-Only,
+	// violations are reported but not blocked
+	ReportOnly bool
+	// ReportURI of the CSP violation reports, see ReportHandler
+	ReportURI          string
+	ContentTypeOptions string
+	FrameOptions       string
+	ReferrerPolicy     string
+	PermissionsPolicy  string
+	// CrossOriginOpenerPolicy, CrossOriginEmbedderPolicy and
+	// CrossOriginResourcePolicy, the COOP, COEP and CORP headers
+	CrossOriginOpenerPolicy   string
+	CrossOriginEmbedderPolicy string
+	CrossOriginResourcePolicy string
+}
+
+// API preset of json routes, nothing of a response is to be rendered
+// as a document
+func API() Config {
+	return Config{
+		HSTS:                      HSTS{MaxAge: 365 * 24 * time.Hour, IncludeSubdomains: true},
+		CSP:                       "default-src 'none'; frame-ancestors 'none'",
+		ContentTypeOptions:        "nosniff",
+		FrameOptions:              "DENY",
+		ReferrerPolicy:            "no-referrer",
+		PermissionsPolicy:         "camera=(), microphone=(), geolocation=(), payment=()",
+		CrossOriginOpenerPolicy:   "same-origin",
+		CrossOriginResourcePolicy: "same-origin",
+	}
+}
+
+// HTML preset of server rendered pages, scripts need the nonce of
+// Nonce, e.g. <script nonce="{{.Nonce}}">
+func HTML() Config {
+	return Config{
+		HSTS: HSTS{MaxAge: 365 * 24 * time.Hour, IncludeSubdomains: true},
+		CSP: "default-src 'self'; script-src 'self' " + NoncePlaceholder + " 'strict-dynamic'; " +
+			"style-src 'self' " + NoncePlaceholder + "; img-src 'self' data:; object-src 'none'; " +
+			"base-uri 'self'; form-action 'self'; frame-ancestors 'self'",
+		ContentTypeOptions:        "nosniff",
+		FrameOptions:              "SAMEORIGIN",
+		ReferrerPolicy:            "strict-origin-when-cross-origin",
+		PermissionsPolicy:         "camera=(), microphone=(), geolocation=(), payment=()",
+		CrossOriginOpenerPolicy:   "same-origin",
+		CrossOriginEmbedderPolicy: "require-corp",
+		CrossOriginResourcePolicy: "same-origin",
+	}
+}
+
+type nonceKey struct{}
+
+// Nonce returns the CSP nonce of the request, "" without the
+// middleware or a CSP without NoncePlaceholder
+func Nonce(r *http.Request) string {
+	n, _ := r.Context().Value(nonceKey{}).(string)
+	return n
+}
+
+// Headers returns a middleware setting the headers of conf
+func Headers(conf Config) handler.Middleware {
+	hsts := conf.HSTS.String()
+	csp := conf.CSP
+	if csp != "" && conf.ReportURI != "" {
+		csp += "; report-uri " + conf.ReportURI + "; report-to csp-endpoint"
+	}
+	cspHeader := "Content-Security-Policy"
+	if conf.ReportOnly {
+		cspHeader = "Content-Security-Policy-Report-Only"
+	}
+	withNonce := strings.Contains(csp, NoncePlaceholder)
+	static := [][2]string{
+		{"X-Content-Type-Options", conf.ContentTypeOptions},
+		{"X-Frame-Options", conf.FrameOptions},
+		{"Referrer-Policy", conf.ReferrerPolicy},
+		{"Permissions-Policy", conf.PermissionsPolicy},
+		{"Cross-Origin-Opener-Policy", conf.CrossOriginOpenerPolicy},
+		{"Cross-Origin-Embedder-Policy", conf.CrossOriginEmbedderPolicy},
+		{"Cross-Origin-Resource-Policy", conf.CrossOriginResourcePolicy},
+	}
+	if csp != "" && conf.ReportURI != "" {
+		static = append(static, [2]string{"Reporting-Endpoints", `csp-endpoint="` + conf.ReportURI + `"`})
+	}
+	mid := func(next http.HandlerFunc) http.HandlerFunc {
+		middle := func(w http.ResponseWriter, r *http.Request) {
+			h := w.Header()
+			for _, kv := range static {
+				if kv[1] != "" {
+					h.Set(kv[0], kv[1])
+				}
+			}
+			if hsts != "" && handler.IsHTTPS(r) {
+				h.Set("Strict-Transport-Security", hsts)
+			}
+			if csp != "" {
+				policy := csp
+				if withNonce {
+					nonce, err := newNonce()
+					if err != nil {
+						handler.ServerErr(w, err)
+						return
+					}
+					policy = strings.ReplaceAll(policy, NoncePlaceholder, "'nonce-"+nonce+"'")
+					r = handler.SetValue(r, nonceKey{}, nonce)
+				}
+				h.Set(cspHeader, policy)
+			}
+			next(w, r)
+		}
+		return middle
+	}
+	return mid
+}
+
+func newNonce() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(b), nil
+}
+
+// maxReport size of a violation report body
+const maxReport = 64 << 10
+
+// ReportHandler returns the handler of Config.ReportURI logging the
+// violations with logger, default the jsonlog default logger. Both the
+// report-uri (application/csp-report) and the Reporting API
+// (application/reports+json) formats are accepted.
+func ReportHandler(logger *jsonlog.Logger) http.HandlerFunc {
+	logInfo := jsonlog.Info
+	if logger != nil {
+		logInfo = logger.Info
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			handler.MethodNotAllow(w)
+			return
+		}
+		data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxReport))
+		if err != nil {
+			handler.BadRequestErr(w, err)
+			return
+		}
+		reports, err := parseReports(r.Header.Get("Content-Type"), data)
+		if err != nil {
+			handler.BadRequestErr(w, err)
+			return
+		}
+		for _, report := range reports {
+			report["ip"] = handler.ClientIP(r)
+			report["user_agent"] = r.UserAgent()
+			logInfo("csp violation", report)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func parseReports(contentType string, data []byte) ([]map[string]any, error) {
+	mt, _, _ := mime.ParseMediaType(contentType)
+	switch mt {
+	case "application/csp-report", "application/json":
+		var v struct {
+			Report map[string]any `json:"csp-report"`
+		}
+		if err := json.Unmarshal(data, &v); err != nil {
+			return nil, err
+		}
+		if v.Report == nil {
+			return nil, errors.New("missing csp-report")
+		}
+		return []map[string]any{v.Report}, nil
+	case "application/reports+json":
+		var vs []struct {
+			Type string         `json:"type"`
+			URL  string         `json:"url"`
+			Body map[string]any `json:"body"`
+		}
+		if err := json.Unmarshal(data, &vs); err != nil {
+			return nil, err
+		}
+		var reports []map[string]any
+		for _, v := range vs {
+			if v.Type != "csp-violation" || v.Body == nil {
+				continue
+			}
+			v.Body["url"] = v.URL
+			reports = append(reports, v.Body)
+		}
+		return reports, nil
+	}
+	return nil, errors.New("unsupported report content type " + strconv.Quote(contentType))
+}
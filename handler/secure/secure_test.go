@@ -0,0 +1,99 @@
+package secure_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/datewu/gtea/handler/secure"
+	"github.com/datewu/gtea/jsonlog"
+)
+
+func TestHeaders(t *testing.T) {
+	var nonce string
+	h := secure.Headers(secure.HTML())(func(w http.ResponseWriter, r *http.Request) {
+		nonce = secure.Nonce(r)
+	})
+	serve := func(https bool) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if https {
+			r.Header.Set("X-Forwarded-Proto", "https")
+		}
+		w := httptest.NewRecorder()
+		h(w, r)
+		return w
+	}
+	w := serve(true)
+	csp := w.Header().Get("Content-Security-Policy")
+	if nonce == "" || !strings.Contains(csp, "'nonce-"+nonce+"'") || strings.Contains(csp, secure.NoncePlaceholder) {
+		t.Errorf("expected the nonce %q in the policy %q", nonce, csp)
+	}
+	for k, v := range map[string]string{
+		"Strict-Transport-Security":    "max-age=31536000; includeSubDomains",
+		"X-Content-Type-Options":       "nosniff",
+		"X-Frame-Options":              "SAMEORIGIN",
+		"Referrer-Policy":              "strict-origin-when-cross-origin",
+		"Cross-Origin-Opener-Policy":   "same-origin",
+		"Cross-Origin-Embedder-Policy": "require-corp",
+		"Cross-Origin-Resource-Policy": "same-origin",
+	} {
+		if got := w.Header().Get(k); got != v {
+			t.Errorf("expected %s %q got %q", k, v, got)
+		}
+	}
+	first := nonce
+	if w = serve(false); nonce == first || w.Header().Get("Strict-Transport-Security") != "" {
+		t.Errorf("expected a new nonce and no HSTS over http got %q %v", nonce, w.Header())
+	}
+}
+
+func TestReportOnly(t *testing.T) {
+	conf := secure.API()
+	conf.ReportOnly = true
+	conf.ReportURI = "/csp-report"
+	var nonce string
+	h := secure.Headers(conf)(func(w http.ResponseWriter, r *http.Request) {
+		nonce = secure.Nonce(r)
+	})
+	w := httptest.NewRecorder()
+	h(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Header().Get("Content-Security-Policy") != "" ||
+		w.Header().Get("Content-Security-Policy-Report-Only") !=
+			"default-src 'none'; frame-ancestors 'none'; report-uri /csp-report; report-to csp-endpoint" ||
+		w.Header().Get("Reporting-Endpoints") != `csp-endpoint="/csp-report"` {
+		t.Errorf("unexpected report only headers %v", w.Header())
+	}
+	if nonce != "" || w.Header().Get("Cross-Origin-Embedder-Policy") != "" {
+		t.Errorf("expected no nonce nor COEP for the API preset got %q %v", nonce, w.Header())
+	}
+}
+
+func TestReportHandler(t *testing.T) {
+	var buf bytes.Buffer
+	h := secure.ReportHandler(jsonlog.New(&buf, jsonlog.LevelInfo))
+	cases := []struct {
+		contentType, body string
+		code              int
+	}{
+		{"application/csp-report", `{"csp-report":{"document-uri":"https://a.com/","violated-directive":"script-src"}}`, http.StatusNoContent},
+		{"application/reports+json", `[{"type":"csp-violation","url":"https://a.com/x","body":{"effectiveDirective":"img-src"}},{"type":"deprecation","body":{}}]`, http.StatusNoContent},
+		{"application/csp-report", `{}`, http.StatusBadRequest},
+		{"text/plain", `hi`, http.StatusBadRequest},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest(http.MethodPost, "/csp-report", strings.NewReader(c.body))
+		r.Header.Set("Content-Type", c.contentType)
+		w := httptest.NewRecorder()
+		h(w, r)
+		if w.Code != c.code {
+			t.Errorf("%s: expected %d got %d", c.body, c.code, w.Code)
+		}
+	}
+	logs := buf.String()
+	if strings.Count(logs, "csp violation") != 2 || !strings.Contains(logs, "script-src") ||
+		!strings.Contains(logs, "https://a.com/x") {
+		t.Errorf("unexpected logs %s", logs)
+	}
+}
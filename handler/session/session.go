@@ -0,0 +1,357 @@
+// Package session keeps server side sessions in a Store, the client
+// only holds the session id in a signed cookie.
+package session
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/datewu/gtea/handler"
+	"github.com/datewu/gtea/handler/cookie"
+	"github.com/datewu/gtea/jsonlog"
+)
+
+// Flash is a one time message shown on the next page
+type Flash struct {
+	Kind    string `json:"kind"`
+	Message string `json:"message"`
+}
+
+// record is the stored state of a session
+type record struct {
+	Values  map[string]json.RawMessage `json:"values,omitempty"`
+	Flashes []Flash                    `json:"flashes,omitempty"`
+	Created time.Time                  `json:"created"`
+	Touched time.Time                  `json:"touched"`
+}
+
+// Session of a request, safe for concurrent use
+type Session struct {
+	mu        sync.Mutex
+	id        string
+	oldID     string // to delete after Regenerate
+	rec       record
+	isNew     bool
+	dirty     bool
+	destroyed bool
+}
+
+func newID() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// ID of the session, empty until the session is saved the first time
+func (s *Session) ID() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.id
+}
+
+// Set v marshaled as json under key
+func (s *Session) Set(key string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.rec.Values == nil {
+		s.rec.Values = make(map[string]json.RawMessage)
+	}
+	s.rec.Values[key] = data
+	s.dirty = true
+	return nil
+}
+
+// Delete key
+func (s *Session) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.rec.Values[key]; ok {
+		delete(s.rec.Values, key)
+		s.dirty = true
+	}
+}
+
+// Get the value of key as T, false if key is not set or not a T
+func Get[T any](s *Session, key string) (T, bool) {
+	var v T
+	s.mu.Lock()
+	data, ok := s.rec.Values[key]
+	s.mu.Unlock()
+	if !ok {
+		return v, false
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return v, false
+	}
+	return v, true
+}
+
+// AddFlash add a message for the next page
+func (s *Session) AddFlash(kind, msg string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rec.Flashes = append(s.rec.Flashes, Flash{Kind: kind, Message: msg})
+	s.dirty = true
+}
+
+// Flashes returns and removes the flash messages
+func (s *Session) Flashes() []Flash {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fs := s.rec.Flashes
+	if len(fs) > 0 {
+		s.rec.Flashes = nil
+		s.dirty = true
+	}
+	return fs
+}
+
+// Regenerate the session id keeping the values, call it on login and
+// privilege changes against session fixation, the absolute timeout
+// still runs from the session creation.
+func (s *Session) Regenerate() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.isNew && s.oldID == "" {
+		s.oldID = s.id
+	}
+	s.id = ""
+	s.isNew = true
+	s.dirty = true
+}
+
+// Destroy the session on logout
+func (s *Session) Destroy() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.destroyed = true
+	s.rec = record{}
+}
+
+type ctxKey struct{}
+
+// FromContext returns the session put by the middleware
+func FromContext(ctx context.Context) (*Session, bool) {
+	s, ok := ctx.Value(ctxKey{}).(*Session)
+	return s, ok
+}
+
+// FromRequest returns the session of r, it panics when the
+// middleware is not in the chain
+func FromRequest(r *http.Request) *Session {
+	s, ok := FromContext(r.Context())
+	if !ok {
+		panic("session: no session middleware")
+	}
+	return s
+}
+
+// Config of a Manager
+type Config struct {
+	Store Store
+	// Name of the cookie, default "session"
+	Name string
+	// Codec signs the id cookie, default a codec with a random key,
+	// sessions in a persistent Store then don't survive a restart.
+	Codec *cookie.Codec
+	// IdleTimeout expires a session not used for it, default 30 minutes
+	IdleTimeout time.Duration
+	// AbsoluteTimeout expires a session this long after its creation
+	// or last Regenerate, default 24 hours
+	AbsoluteTimeout time.Duration
+	Now             func() time.Time
+}
+
+// Manager loads and saves sessions
+type Manager struct {
+	conf Config
+}
+
+// New returns a Manager
+func New(conf Config) (*Manager, error) {
+	if conf.Store == nil {
+		return nil, errors.New("session: no store")
+	}
+	if conf.Name == "" {
+		conf.Name = "session"
+	}
+	if conf.IdleTimeout <= 0 {
+		conf.IdleTimeout = 30 * time.Minute
+	}
+	if conf.AbsoluteTimeout <= 0 {
+		conf.AbsoluteTimeout = 24 * time.Hour
+	}
+	if conf.Now == nil {
+		conf.Now = time.Now
+	}
+	if conf.Codec == nil {
+		key, err := cookie.GenerateKey()
+		if err != nil {
+			return nil, err
+		}
+		keys, err := cookie.NewKeyring(key)
+		if err != nil {
+			return nil, err
+		}
+		conf.Codec = cookie.New(keys, cookie.Options{HTTPOnly: true})
+	}
+	return &Manager{conf: conf}, nil
+}
+
+// touchInterval throttle the store writes of unchanged sessions
+const touchInterval = time.Minute
+
+// load the session of r, a missing, invalid or timed out session
+// gives a new empty one
+func (m *Manager) load(r *http.Request) (*Session, error) {
+	fresh := &Session{isNew: true}
+	id, err := m.conf.Codec.Get(r, m.conf.Name)
+	if err != nil {
+		return fresh, nil
+	}
+	data, err := m.conf.Store.Load(r.Context(), string(id))
+	if errors.Is(err, ErrNotFound) {
+		return fresh, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	s := &Session{id: string(id)}
+	if err := json.Unmarshal(data, &s.rec); err != nil {
+		return nil, err
+	}
+	now := m.conf.Now()
+	if now.Sub(s.rec.Touched) > m.conf.IdleTimeout || now.Sub(s.rec.Created) > m.conf.AbsoluteTimeout {
+		fresh.oldID = s.id
+		return fresh, nil
+	}
+	return s, nil
+}
+
+// save persist the session and set or clear the cookie
+func (m *Manager) save(ctx context.Context, w http.ResponseWriter, s *Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.oldID != "" {
+		if err := m.conf.Store.Delete(ctx, s.oldID); err != nil && !errors.Is(err, ErrNotFound) {
+			return err
+		}
+		s.oldID = ""
+	}
+	if s.destroyed {
+		if s.id != "" {
+			if err := m.conf.Store.Delete(ctx, s.id); err != nil && !errors.Is(err, ErrNotFound) {
+				return err
+			}
+		}
+		m.conf.Codec.Clear(w, m.conf.Name)
+		return nil
+	}
+	now := m.conf.Now()
+	if !s.dirty && now.Sub(s.rec.Touched) < touchInterval {
+		return nil
+	}
+	if s.isNew && !s.dirty {
+		// don't store empty sessions of anonymous visitors
+		return nil
+	}
+	if s.id == "" {
+		id, err := newID()
+		if err != nil {
+			return err
+		}
+		s.id = id
+	}
+	if s.rec.Created.IsZero() {
+		s.rec.Created = now
+	}
+	s.rec.Touched = now
+	data, err := json.Marshal(s.rec)
+	if err != nil {
+		return err
+	}
+	expire := now.Add(m.conf.IdleTimeout)
+	if abs := s.rec.Created.Add(m.conf.AbsoluteTimeout); abs.Before(expire) {
+		expire = abs
+	}
+	if err := m.conf.Store.Save(ctx, s.id, data, expire); err != nil {
+		return err
+	}
+	if s.isNew {
+		if err := m.conf.Codec.Set(w, m.conf.Name, []byte(s.id)); err != nil {
+			return err
+		}
+		s.isNew = false
+	}
+	s.dirty = false
+	return nil
+}
+
+// sessionWriter saves the session before the headers are written
+type sessionWriter struct {
+	http.ResponseWriter
+	commit func()
+	done   bool
+}
+
+func (w *sessionWriter) before() {
+	if !w.done {
+		w.done = true
+		w.commit()
+	}
+}
+
+func (w *sessionWriter) WriteHeader(status int) {
+	w.before()
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *sessionWriter) Write(b []byte) (int, error) {
+	w.before()
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush for streaming handlers
+func (w *sessionWriter) Flush() {
+	w.before()
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// Unwrap for http.ResponseController
+func (w *sessionWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Middleware load the session into the request context and save it
+// once the handler starts writing the response
+func (m *Manager) Middleware(next http.HandlerFunc) http.HandlerFunc {
+	middle := func(w http.ResponseWriter, r *http.Request) {
+		s, err := m.load(r)
+		if err != nil {
+			handler.ServerErr(w, err)
+			return
+		}
+		ctx := r.Context()
+		sw := &sessionWriter{ResponseWriter: w}
+		sw.commit = func() {
+			if err := m.save(ctx, w, s); err != nil {
+				jsonlog.Err(err, map[string]any{"session": m.conf.Name})
+			}
+		}
+		next(sw, handler.SetValue(r, ctxKey{}, s))
+		sw.before()
+	}
+	return middle
+}
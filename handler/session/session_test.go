@@ -0,0 +1,165 @@
+package session
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type client struct {
+	t      *testing.T
+	h      http.HandlerFunc
+	cookie string
+}
+
+func (c *client) do(path string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, path, nil)
+	if c.cookie != "" {
+		r.Header.Set("Cookie", c.cookie)
+	}
+	w := httptest.NewRecorder()
+	c.h(w, r)
+	if set := w.Header().Get("Set-Cookie"); set != "" {
+		c.cookie = strings.Split(set, ";")[0]
+	}
+	return w
+}
+
+func TestMiddleware(t *testing.T) {
+	now := time.Now()
+	store := NewMemory(10)
+	m, err := New(Config{Store: store, IdleTimeout: time.Hour, Now: func() time.Time { return now }})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lastID string
+	app := func(w http.ResponseWriter, r *http.Request) {
+		s := FromRequest(r)
+		switch r.URL.Path {
+		case "/login":
+			s.Regenerate()
+			s.Set("user", "alice")
+			s.AddFlash("success", "welcome")
+		case "/logout":
+			s.Destroy()
+		}
+		user, _ := Get[string](s, "user")
+		var msgs []string
+		for _, f := range s.Flashes() {
+			msgs = append(msgs, f.Message)
+		}
+		w.Write([]byte(user + "|" + strings.Join(msgs, ",")))
+		lastID = s.ID()
+	}
+	c := &client{t: t, h: m.Middleware(app)}
+
+	if body := c.do("/").Body.String(); body != "|" || c.cookie != "" || store.Len() != 0 {
+		t.Errorf("expected no stored anonymous session got %q %q", body, c.cookie)
+	}
+	c.do("/")
+	c.do("/login")
+	first := lastID
+	if body := c.do("/").Body.String(); body != "alice|" {
+		t.Errorf("expected the flash consumed and the user kept got %q", body)
+	}
+	if lastID != first {
+		t.Errorf("expected a stable session id")
+	}
+	c.do("/login")
+	if lastID == first || store.Len() != 1 {
+		t.Errorf("expected a regenerated id and the old session dropped, %d sessions", store.Len())
+	}
+	if _, err := store.Load(context.Background(), first); err != ErrNotFound {
+		t.Errorf("expected old session removed got %v", err)
+	}
+
+	now = now.Add(2 * time.Hour)
+	if body := c.do("/").Body.String(); body != "|" {
+		t.Errorf("expected idle session expired got %q", body)
+	}
+	c.do("/login")
+	c.do("/logout")
+	if body := c.do("/").Body.String(); body != "|" || store.Len() != 0 {
+		t.Errorf("expected session destroyed got %q", body)
+	}
+}
+
+func TestAbsoluteTimeout(t *testing.T) {
+	now := time.Now()
+	m, _ := New(Config{Store: NewMemory(0), IdleTimeout: time.Hour, AbsoluteTimeout: 3 * time.Hour,
+		Now: func() time.Time { return now }})
+	app := func(w http.ResponseWriter, r *http.Request) {
+		s := FromRequest(r)
+		switch r.URL.Path {
+		case "/login":
+			s.Set("user", "bob")
+		case "/regenerate":
+			s.Regenerate()
+		}
+		user, _ := Get[string](s, "user")
+		w.Write([]byte(user))
+	}
+	c := &client{t: t, h: m.Middleware(app)}
+	c.do("/login")
+	for i, path := range []string{"/", "/regenerate", "/"} {
+		now = now.Add(50 * time.Minute)
+		if body := c.do(path).Body.String(); body != "bob" {
+			t.Fatalf("expected session alive at %d got %q", i, body)
+		}
+	}
+	now = now.Add(50 * time.Minute)
+	if body := c.do("/").Body.String(); body != "" {
+		t.Errorf("expected absolute timeout got %q", body)
+	}
+}
+
+func TestStores(t *testing.T) {
+	ctx := context.Background()
+	file, err := NewFile(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	mem := NewMemory(2)
+	now := time.Now()
+	for _, s := range []Store{mem, file} {
+		if err := s.Save(ctx, "aa", []byte("1"), now.Add(time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+		s.Save(ctx, "bb", []byte("2"), now.Add(-time.Second))
+		if data, err := s.Load(ctx, "aa"); err != nil || string(data) != "1" {
+			t.Errorf("%T unexpected load %q %v", s, data, err)
+		}
+		if _, err := s.Load(ctx, "bb"); err != ErrNotFound {
+			t.Errorf("%T expected expired session got %v", s, err)
+		}
+		if _, err := s.Load(ctx, "../x"); err != ErrNotFound {
+			t.Errorf("%T expected invalid id not found got %v", s, err)
+		}
+		if err := s.Delete(ctx, "aa"); err != nil {
+			t.Errorf("%T delete %v", s, err)
+		}
+	}
+	file.Save(ctx, "cc", nil, now.Add(-time.Second))
+	file.Save(ctx, "dd", nil, now.Add(-time.Second))
+	file.Save(ctx, "ee", nil, now.Add(time.Hour))
+	if n, err := file.RemoveExpired(); n != 2 || err != nil {
+		t.Errorf("expected 2 expired sessions removed got %d %v", n, err)
+	}
+	if n, _ := file.RemoveExpired(); n != 0 {
+		t.Errorf("expected nothing left to remove got %d", n)
+	}
+	if _, err := file.Load(ctx, "ee"); err != nil {
+		t.Errorf("expected the live session kept got %v", err)
+	}
+
+	mem.Save(ctx, "aa", nil, now.Add(time.Hour))
+	mem.Save(ctx, "bb", nil, now.Add(time.Hour))
+	mem.Load(ctx, "aa")
+	mem.Save(ctx, "cc", nil, now.Add(time.Hour))
+	if _, err := mem.Load(ctx, "bb"); err != ErrNotFound {
+		t.Errorf("expected least recently used evicted got %v", err)
+	}
+}
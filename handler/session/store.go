@@ -0,0 +1,256 @@
+package session
+
+import (
+	"container/list"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/datewu/gtea"
+)
+
+// ErrNotFound is returned by a Store for an unknown or expired session
+var ErrNotFound = errors.New("session not found")
+
+// Store keeps the encoded sessions by id until they expire
+type Store interface {
+	Load(ctx context.Context, id string) ([]byte, error)
+	Save(ctx context.Context, id string, data []byte, expire time.Time) error
+	Delete(ctx context.Context, id string) error
+}
+
+type entry struct {
+	id     string
+	data   []byte
+	expire time.Time
+}
+
+// Memory is an in memory Store evicting the least recently used
+// session once it holds its capacity
+type Memory struct {
+	mu       sync.Mutex
+	capacity int
+	ll       *list.List // front is the most recently used
+	items    map[string]*list.Element
+	now      func() time.Time
+}
+
+// NewMemory returns a Memory store of capacity sessions, 0 unlimited
+func NewMemory(capacity int) *Memory {
+	return &Memory{
+		capacity: capacity,
+		ll:       list.New(),
+		items:    make(map[string]*list.Element),
+		now:      time.Now,
+	}
+}
+
+// Load a session
+func (m *Memory) Load(_ context.Context, id string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	el, ok := m.items[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	e := el.Value.(*entry)
+	if !m.now().Before(e.expire) {
+		m.remove(el)
+		return nil, ErrNotFound
+	}
+	m.ll.MoveToFront(el)
+	return append([]byte(nil), e.data...), nil
+}
+
+// Save a session
+func (m *Memory) Save(_ context.Context, id string, data []byte, expire time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	data = append([]byte(nil), data...)
+	if el, ok := m.items[id]; ok {
+		e := el.Value.(*entry)
+		e.data, e.expire = data, expire
+		m.ll.MoveToFront(el)
+		return nil
+	}
+	m.items[id] = m.ll.PushFront(&entry{id: id, data: data, expire: expire})
+	for m.capacity > 0 && m.ll.Len() > m.capacity {
+		m.remove(m.ll.Back())
+	}
+	return nil
+}
+
+// Delete a session
+func (m *Memory) Delete(_ context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	el, ok := m.items[id]
+	if !ok {
+		return ErrNotFound
+	}
+	m.remove(el)
+	return nil
+}
+
+// Len returns the number of sessions, expired ones included
+func (m *Memory) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.ll.Len()
+}
+
+// RemoveExpired drop the expired sessions
+func (m *Memory) RemoveExpired() (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := m.now()
+	n := 0
+	for el := m.ll.Back(); el != nil; {
+		prev := el.Prev()
+		if !now.Before(el.Value.(*entry).expire) {
+			m.remove(el)
+			n++
+		}
+		el = prev
+	}
+	return n, nil
+}
+
+func (m *Memory) remove(el *list.Element) {
+	m.ll.Remove(el)
+	delete(m.items, el.Value.(*entry).id)
+}
+
+// File is a Store writing a file per session in a directory, so
+// sessions survive a restart
+type File struct {
+	dir string
+	now func() time.Time
+}
+
+// NewFile returns a File store in dir, dir is created if missing
+func NewFile(dir string) (*File, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	return &File{dir: dir, now: time.Now}, nil
+}
+
+const fileExt = ".session"
+
+// path of a session, ids are base64url so they can't escape dir
+func (f *File) path(id string) (string, error) {
+	if _, err := base64.RawURLEncoding.DecodeString(id); err != nil || id == "" {
+		return "", ErrNotFound
+	}
+	return filepath.Join(f.dir, id+fileExt), nil
+}
+
+// read returns the data of a session file, its first 8 bytes are the
+// unix expire time
+func (f *File) read(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if f.expired(data) {
+		os.Remove(path)
+		return nil, ErrNotFound
+	}
+	return data[8:], nil
+}
+
+// expired reports whether the data of a session file is expired or
+// too short to hold its expire time
+func (f *File) expired(data []byte) bool {
+	if len(data) < 8 {
+		return true
+	}
+	expire := time.Unix(int64(binary.BigEndian.Uint64(data)), 0)
+	return !f.now().Before(expire)
+}
+
+// Load a session
+func (f *File) Load(_ context.Context, id string) ([]byte, error) {
+	path, err := f.path(id)
+	if err != nil {
+		return nil, err
+	}
+	return f.read(path)
+}
+
+// Save a session atomically
+func (f *File) Save(_ context.Context, id string, data []byte, expire time.Time) error {
+	path, err := f.path(id)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(f.dir, ".session-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	head := make([]byte, 8)
+	binary.BigEndian.PutUint64(head, uint64(expire.Unix()))
+	if _, err := tmp.Write(append(head, data...)); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Delete a session
+func (f *File) Delete(_ context.Context, id string) error {
+	path, err := f.path(id)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// RemoveExpired delete the expired session files
+func (f *File) RemoveExpired() (int, error) {
+	entries, err := os.ReadDir(f.dir)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), fileExt) {
+			continue
+		}
+		path := filepath.Join(f.dir, e.Name())
+		data, err := os.ReadFile(path)
+		if err != nil || !f.expired(data) {
+			continue
+		}
+		if os.Remove(path) == nil {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// ExpireJob returns a background job for App.AddBGJob removing the
+// expired sessions of a Memory or File store every interval until
+// the app shuts down.
+func ExpireJob(s interface{ RemoveExpired() (int, error) }, interval time.Duration) func(context.Context, chan<- gtea.Message) {
+	return gtea.Periodic(interval, func(context.Context) { s.RemoveExpired() })
+}
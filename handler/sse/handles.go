@@ -2,6 +2,9 @@ package sse
 
 import (
 	"net/http"
+	"time"
+
+	"github.com/datewu/gtea/jsonlog"
 )
 
 // SSE proxy to Streamer
@@ -12,6 +15,11 @@ func SSE(w http.ResponseWriter, r *http.Request, h Streamer) {
 	w.Header().Set("Transfer-Encoding", "chunked")
 	w.Header().Set("Access-Control-Allow-Credentials", "true")
 
+	// lift the server write timeout and the Timeout middleware, the
+	// stream is cut at the write timeout when that fails
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil {
+		jsonlog.Err(err, map[string]any{"sse": "lift write deadline", "path": r.URL.Path})
+	}
 	w.WriteHeader(http.StatusOK)
 
 	f, ok := w.(http.Flusher)
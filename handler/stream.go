@@ -0,0 +1,330 @@
+package handler
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// ErrStreamClosed is returned when writing to a closed stream writer
+var ErrStreamClosed = errors.New("stream writer closed")
+
+const defaultFlushEvery = 100
+
+// StreamOption config a streaming response writer
+type StreamOption func(*streamConf)
+
+type streamConf struct {
+	ctx      context.Context
+	every    int
+	filename string
+	header   []string
+}
+
+// FlushEvery flush the response every n records, n < 1 flush every record
+func FlushEvery(n int) StreamOption {
+	return func(c *streamConf) {
+		if n < 1 {
+			n = 1
+		}
+		c.every = n
+	}
+}
+
+// StreamContext stop writing once the ctx is done, default the request
+// context, so a client disconnect stops the producer
+func StreamContext(ctx context.Context) StreamOption {
+	return func(c *streamConf) {
+		c.ctx = ctx
+	}
+}
+
+// Attachment set Content-Disposition to download the stream as filename
+func Attachment(filename string) StreamOption {
+	return func(c *streamConf) {
+		c.filename = filename
+	}
+}
+
+// CSVHeader write the header record before any other csv records
+func CSVHeader(header ...string) StreamOption {
+	return func(c *streamConf) {
+		c.header = header
+	}
+}
+
+// streamWriter is the shared plumbing of all streaming writers.
+type streamWriter struct {
+	w           http.ResponseWriter
+	rc          *http.ResponseController
+	conf        streamConf
+	contentType string
+	wroteHeader bool
+	closed      bool
+	count       int
+	err         error
+}
+
+func newStreamWriter(w http.ResponseWriter, r *http.Request, contentType string, opts []StreamOption) *streamWriter {
+	s := &streamWriter{
+		w:           w,
+		rc:          http.NewResponseController(w),
+		contentType: contentType,
+		conf:        streamConf{ctx: r.Context(), every: defaultFlushEvery},
+	}
+	for _, o := range opts {
+		o(&s.conf)
+	}
+	return s
+}
+
+func (s *streamWriter) writeHeader() {
+	if s.wroteHeader {
+		return
+	}
+	s.wroteHeader = true
+	h := s.w.Header()
+	h.Set("Content-Type", s.contentType)
+	h.Set("X-Content-Type-Options", "nosniff")
+	h.Del("Content-Length")
+	if s.conf.filename != "" {
+		h.Set("Content-Disposition", contentDisposition(s.conf.filename))
+	}
+	s.w.WriteHeader(http.StatusOK)
+}
+
+// check return the sticky error or the ctx error
+func (s *streamWriter) check() error {
+	if s.err != nil {
+		return s.err
+	}
+	if s.closed {
+		return ErrStreamClosed
+	}
+	if err := s.conf.ctx.Err(); err != nil {
+		s.err = err
+		return err
+	}
+	return nil
+}
+
+func (s *streamWriter) write(p []byte) error {
+	s.writeHeader()
+	if _, err := s.w.Write(p); err != nil {
+		s.err = err
+	}
+	return s.err
+}
+
+// record count a written record and flush every n records
+func (s *streamWriter) record() error {
+	s.count++
+	if s.count%s.conf.every == 0 {
+		return s.Flush()
+	}
+	return nil
+}
+
+// Flush send the buffered records to the client
+func (s *streamWriter) Flush() error {
+	if s.err != nil {
+		return s.err
+	}
+	s.writeHeader()
+	err := s.rc.Flush()
+	if err != nil && !errors.Is(err, http.ErrNotSupported) {
+		s.err = err
+	}
+	return s.err
+}
+
+// Count returns how many records have been written
+func (s *streamWriter) Count() int {
+	return s.count
+}
+
+func (s *streamWriter) close() error {
+	if s.closed {
+		return s.err
+	}
+	s.closed = true
+	if s.err != nil {
+		return s.err
+	}
+	return s.Flush()
+}
+
+// NDJSONWriter streams newline delimited JSON records
+type NDJSONWriter struct {
+	*streamWriter
+}
+
+// NDJSON returns a writer streams application/x-ndjson records of r to w
+func NDJSON(w http.ResponseWriter, r *http.Request, opts ...StreamOption) *NDJSONWriter {
+	return &NDJSONWriter{newStreamWriter(w, r, "application/x-ndjson", opts)}
+}
+
+// Encode write v as one line
+func (n *NDJSONWriter) Encode(v any) error {
+	if err := n.check(); err != nil {
+		return err
+	}
+	js, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if err := n.write(append(js, '\n')); err != nil {
+		return err
+	}
+	return n.record()
+}
+
+// Close flush the remaining records
+func (n *NDJSONWriter) Close() error {
+	return n.close()
+}
+
+// JSONArrayWriter streams elements of one JSON array
+type JSONArrayWriter struct {
+	*streamWriter
+}
+
+// JSONArray returns a writer streams a application/json array to w,
+// Close must be called to terminate the array
+func JSONArray(w http.ResponseWriter, r *http.Request, opts ...StreamOption) *JSONArrayWriter {
+	return &JSONArrayWriter{newStreamWriter(w, r, "application/json", opts)}
+}
+
+// Encode write v as the next element of the array
+func (a *JSONArrayWriter) Encode(v any) error {
+	if err := a.check(); err != nil {
+		return err
+	}
+	js, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	sep := byte(',')
+	if a.count == 0 {
+		sep = '['
+	}
+	if err := a.write(append([]byte{sep}, js...)); err != nil {
+		return err
+	}
+	return a.record()
+}
+
+// Close terminate the array and flush the remaining elements
+func (a *JSONArrayWriter) Close() error {
+	if a.closed {
+		return a.err
+	}
+	end := "]"
+	if a.count == 0 {
+		end = "[]"
+	}
+	if a.err == nil {
+		a.write([]byte(end))
+	}
+	return a.close()
+}
+
+// CSVWriter streams csv or tsv records
+type CSVWriter struct {
+	*streamWriter
+	cw *csv.Writer
+}
+
+// CSV returns a writer streams text/csv records to w
+func CSV(w http.ResponseWriter, r *http.Request, opts ...StreamOption) *CSVWriter {
+	return newCSVWriter(w, r, "text/csv; charset=utf-8", ',', opts)
+}
+
+// TSV returns a writer streams text/tab-separated-values records to w
+func TSV(w http.ResponseWriter, r *http.Request, opts ...StreamOption) *CSVWriter {
+	return newCSVWriter(w, r, "text/tab-separated-values; charset=utf-8", '\t', opts)
+}
+
+func newCSVWriter(w http.ResponseWriter, r *http.Request, contentType string, comma rune, opts []StreamOption) *CSVWriter {
+	s := newStreamWriter(w, r, contentType, opts)
+	c := &CSVWriter{streamWriter: s}
+	c.cw = csv.NewWriter(writerFunc(c.write))
+	c.cw.Comma = comma
+	return c
+}
+
+type writerFunc func([]byte) error
+
+func (f writerFunc) Write(p []byte) (int, error) {
+	if err := f(p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (c *CSVWriter) writeRecord(record []string) error {
+	if err := c.cw.Write(record); err != nil {
+		if c.err == nil {
+			c.err = err
+		}
+		return c.err
+	}
+	return nil
+}
+
+// Write write one record, the header is written before the first record
+func (c *CSVWriter) Write(record []string) error {
+	if err := c.check(); err != nil {
+		return err
+	}
+	if err := c.header(); err != nil {
+		return err
+	}
+	if err := c.writeRecord(record); err != nil {
+		return err
+	}
+	return c.record()
+}
+
+func (c *CSVWriter) header() error {
+	h := c.conf.header
+	if h == nil {
+		return nil
+	}
+	c.conf.header = nil
+	return c.writeRecord(h)
+}
+
+// Flush send the buffered records to the client
+func (c *CSVWriter) Flush() error {
+	c.cw.Flush()
+	if err := c.cw.Error(); err != nil && c.err == nil {
+		c.err = err
+	}
+	return c.streamWriter.Flush()
+}
+
+func (c *CSVWriter) record() error {
+	c.count++
+	if c.count%c.conf.every == 0 {
+		return c.Flush()
+	}
+	return nil
+}
+
+// Close write the header if there is no record and flush the remaining records
+func (c *CSVWriter) Close() error {
+	if c.closed {
+		return c.err
+	}
+	if c.err == nil {
+		c.header()
+		c.cw.Flush()
+		if err := c.cw.Error(); err != nil && c.err == nil {
+			c.err = err
+		}
+	}
+	return c.close()
+}
@@ -0,0 +1,119 @@
+package handler
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNDJSON(t *testing.T) {
+	w := httptest.NewRecorder()
+	s := NDJSON(w, httptest.NewRequest(http.MethodGet, "/", nil), FlushEvery(2))
+	for i := 0; i < 3; i++ {
+		if err := s.Encode(Envelope{"id": i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !w.Flushed {
+		t.Error("expected flushed after 2 records")
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	expect := "{\"id\":0}\n{\"id\":1}\n{\"id\":2}\n"
+	if w.Body.String() != expect {
+		t.Errorf("expected %q got %q", expect, w.Body.String())
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/x-ndjson" {
+		t.Errorf("expected ndjson content type got %q", ct)
+	}
+	if err := s.Encode(1); err != ErrStreamClosed {
+		t.Errorf("expected %v got %v", ErrStreamClosed, err)
+	}
+}
+
+func TestJSONArray(t *testing.T) {
+	w := httptest.NewRecorder()
+	s := JSONArray(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	s.Close()
+	if w.Body.String() != "[]" {
+		t.Errorf("expected %q got %q", "[]", w.Body.String())
+	}
+	w = httptest.NewRecorder()
+	s = JSONArray(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	s.Encode("a")
+	s.Encode(2)
+	s.Close()
+	if w.Body.String() != `["a",2]` {
+		t.Errorf("expected %q got %q", `["a",2]`, w.Body.String())
+	}
+}
+
+func TestCSV(t *testing.T) {
+	w := httptest.NewRecorder()
+	s := TSV(w, httptest.NewRequest(http.MethodGet, "/", nil), CSVHeader("name", "age"), Attachment("report 2023.tsv"))
+	s.Write([]string{"bob", "9"})
+	s.Close()
+	expect := "name\tage\nbob\t9\n"
+	if w.Body.String() != expect {
+		t.Errorf("expected %q got %q", expect, w.Body.String())
+	}
+	cd := w.Header().Get("Content-Disposition")
+	if cd != `attachment; filename="report 2023.tsv"` {
+		t.Errorf("unexpected Content-Disposition %q", cd)
+	}
+}
+
+func TestStreamCanceled(t *testing.T) {
+	// the request context by default, a client disconnect
+	ctx, cancel := context.WithCancel(context.Background())
+	r := httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx)
+	w := httptest.NewRecorder()
+	s := CSV(w, r)
+	if err := s.Write([]string{"a"}); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	if err := s.Write([]string{"b"}); err != context.Canceled {
+		t.Errorf("expected %v got %v", context.Canceled, err)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	s = CSV(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil), StreamContext(ctx))
+	cancel()
+	if err := s.Write([]string{"a"}); err != context.Canceled {
+		t.Errorf("expected the StreamContext ctx got %v", err)
+	}
+}
+
+func TestStreamGzip(t *testing.T) {
+	h := GzipMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		s := NDJSON(w, r, FlushEvery(1))
+		s.Encode("hi")
+		s.Encode("you")
+		s.Close()
+	})
+	ts := httptest.NewServer(h)
+	defer ts.Close()
+	req, _ := http.NewRequest(http.MethodGet, ts.URL, nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	res, err := http.DefaultTransport.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	gz, err := gzip.NewReader(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := io.ReadAll(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "\"hi\"\n\"you\"\n" {
+		t.Errorf("unexpected body %q", string(b))
+	}
+}
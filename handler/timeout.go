@@ -0,0 +1,239 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/datewu/gtea/jsonlog"
+)
+
+// TimeoutConfig of the Timeout middleware
+type TimeoutConfig struct {
+	// Timeout of every request without a route timeout, 0 for none
+	Timeout time.Duration
+	// Routes timeouts by route pattern "/report" or method and
+	// pattern "POST /report", 0 for none
+	Routes map[string]time.Duration
+	// Status of the timeout response, default 503, 504 suits servers
+	// behind a gateway
+	Status int
+	// Render the timeout response, default a json error of Status
+	Render http.HandlerFunc
+}
+
+// Timeout returns a middleware cancelling the request context after
+// the timeout of the route, and responding with a timeout error when
+// the handler has not written its response yet. Writes of the handler
+// after the timeout fail with http.ErrHandlerTimeout.
+//
+// Streaming requests are exempt: upgrade requests, requests accepting
+// text/event-stream, and handlers clearing the write deadline with
+// http.ResponseController.SetWriteDeadline(time.Time{}), as sse.SSE.
+func Timeout(conf TimeoutConfig) Middleware {
+	if conf.Status == 0 {
+		conf.Status = http.StatusServiceUnavailable
+	}
+	if conf.Render == nil {
+		conf.Render = func(w http.ResponseWriter, _ *http.Request) {
+			errResponse(w, conf.Status, "the request timed out, please try later")
+		}
+	}
+	timeout := func(r *http.Request) time.Duration {
+		if len(conf.Routes) > 0 {
+			pattern := RoutePattern(r)
+			if d, ok := conf.Routes[r.Method+" "+pattern]; ok {
+				return d
+			}
+			if d, ok := conf.Routes[pattern]; ok {
+				return d
+			}
+		}
+		return conf.Timeout
+	}
+	mid := func(next http.HandlerFunc) http.HandlerFunc {
+		middle := func(w http.ResponseWriter, r *http.Request) {
+			d := timeout(r)
+			if d <= 0 || r.Header.Get("Upgrade") != "" ||
+				strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+				next(w, r)
+				return
+			}
+			ctx, cancel := context.WithCancelCause(r.Context())
+			defer cancel(context.Canceled)
+			tw := &timeoutWriter{w: w, h: w.Header().Clone(), timer: time.NewTimer(d)}
+			defer tw.timer.Stop()
+			done := make(chan any, 1)
+			go func() {
+				defer func() {
+					p := recover()
+					if p != nil {
+						p = panicked{p, debug.Stack()}
+					}
+					tw.finish()
+					done <- p
+				}()
+				next(tw, r.WithContext(ctx))
+			}()
+			select {
+			case p := <-done:
+				rethrow(p)
+				return
+			case <-tw.timer.C:
+			}
+			rendered, exempt := tw.timeout(func() { conf.Render(w, r) })
+			if !exempt {
+				cancel(http.ErrHandlerTimeout)
+			}
+			if !rendered {
+				// already responding, wait for the handler
+				rethrow(<-done)
+				return
+			}
+			go func() {
+				// the handler outlives the request, don't crash on a
+				// late panic
+				if p, ok := (<-done).(panicked); ok && p.p != http.ErrAbortHandler {
+					jsonlog.Err(fmt.Errorf("panic after timeout: %v", p.p), map[string]any{
+						"method": r.Method,
+						"uri":    r.URL.RequestURI(),
+						"stack":  string(p.stack),
+					})
+				}
+			}()
+		}
+		return middle
+	}
+	return mid
+}
+
+type panicked struct {
+	p     any
+	stack []byte
+}
+
+// rethrow a panic of the handler goroutine in the request goroutine,
+// the Recover middleware then sees it
+func rethrow(p any) {
+	if p, ok := p.(panicked); ok {
+		panic(p.p)
+	}
+}
+
+// timeoutWriter serialize the writes of the handler with the timeout
+// response. The handler has its own header map, copied on WriteHeader.
+type timeoutWriter struct {
+	w     http.ResponseWriter
+	h     http.Header
+	timer *time.Timer
+
+	mu       sync.Mutex
+	wrote    bool
+	exempt   bool
+	timedOut bool
+}
+
+func (tw *timeoutWriter) Header() http.Header {
+	return tw.h
+}
+
+func (tw *timeoutWriter) writeHeader(status int) {
+	if tw.wrote {
+		return
+	}
+	tw.copyHeader()
+	tw.w.WriteHeader(status)
+}
+
+// copyHeader replace the header of the response with the handler one
+func (tw *timeoutWriter) copyHeader() {
+	tw.wrote = true
+	dst := tw.w.Header()
+	for k := range dst {
+		delete(dst, k)
+	}
+	for k, v := range tw.h {
+		dst[k] = v
+	}
+}
+
+func (tw *timeoutWriter) WriteHeader(status int) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut {
+		return
+	}
+	tw.writeHeader(status)
+}
+
+func (tw *timeoutWriter) Write(b []byte) (int, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	tw.writeHeader(http.StatusOK)
+	return tw.w.Write(b)
+}
+
+// Flush for http.Flusher
+func (tw *timeoutWriter) Flush() {
+	tw.FlushError()
+}
+
+// FlushError for http.ResponseController
+func (tw *timeoutWriter) FlushError() error {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut {
+		return http.ErrHandlerTimeout
+	}
+	tw.writeHeader(http.StatusOK)
+	return http.NewResponseController(tw.w).Flush()
+}
+
+// SetWriteDeadline for http.ResponseController, the zero time exempts
+// the request from the timeout
+func (tw *timeoutWriter) SetWriteDeadline(t time.Time) error {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut {
+		return http.ErrHandlerTimeout
+	}
+	if t.IsZero() {
+		tw.exempt = true
+		tw.timer.Stop()
+	}
+	return http.NewResponseController(tw.w).SetWriteDeadline(t)
+}
+
+// SetReadDeadline for http.ResponseController
+func (tw *timeoutWriter) SetReadDeadline(t time.Time) error {
+	return http.NewResponseController(tw.w).SetReadDeadline(t)
+}
+
+// timeout render the timeout response unless the handler is exempt or
+// has written its header
+func (tw *timeoutWriter) timeout(render func()) (rendered, exempt bool) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.exempt || tw.wrote {
+		return false, tw.exempt
+	}
+	tw.timedOut = true
+	render()
+	return true, false
+}
+
+// finish copy the headers of a handler returning without a write
+func (tw *timeoutWriter) finish() {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if !tw.timedOut && !tw.wrote {
+		tw.copyHeader()
+	}
+}
@@ -0,0 +1,92 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTimeout(t *testing.T) {
+	mid := Timeout(TimeoutConfig{
+		Timeout: 20 * time.Millisecond,
+		Routes:  map[string]time.Duration{"/report": 0, "POST /slow": time.Second},
+		Status:  http.StatusGatewayTimeout,
+	})
+	serve := func(method, pattern string, h http.HandlerFunc) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, pattern, nil)
+		r = SetValue(r, RouteCtxKey, pattern)
+		w := httptest.NewRecorder()
+		w.Header().Set("Vary", "Origin")
+		mid(h)(w, r)
+		return w
+	}
+
+	w := serve(http.MethodGet, "/fast", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Done", "1")
+	})
+	if w.Code != http.StatusOK || w.Header().Get("X-Done") != "1" || w.Header().Get("Vary") != "Origin" {
+		t.Errorf("expected the handler headers got %d %v", w.Code, w.Header())
+	}
+
+	errs := make(chan error, 1)
+	start := time.Now()
+	w = serve(http.MethodGet, "/stuck", func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+		time.Sleep(10 * time.Millisecond)
+		_, err := w.Write([]byte("late"))
+		errs <- err
+	})
+	if w.Code != http.StatusGatewayTimeout || time.Since(start) > time.Second {
+		t.Errorf("expected a 504 on time got %d in %s", w.Code, time.Since(start))
+	}
+	if err := <-errs; !errors.Is(err, http.ErrHandlerTimeout) {
+		t.Errorf("expected late writes to fail got %v", err)
+	}
+	if w.Body.String() == "late" {
+		t.Error("expected the late write discarded")
+	}
+
+	var cause error
+	w = serve(http.MethodGet, "/started", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+		<-r.Context().Done()
+		cause = context.Cause(r.Context())
+		w.Write([]byte("partial"))
+	})
+	if w.Code != http.StatusAccepted || w.Body.String() != "partial" || cause != http.ErrHandlerTimeout {
+		t.Errorf("expected the started response kept and the context cancelled got %d %q %v",
+			w.Code, w.Body.String(), cause)
+	}
+
+	for _, c := range []struct{ method, pattern string }{{http.MethodGet, "/report"}, {http.MethodPost, "/slow"}} {
+		w = serve(c.method, c.pattern, func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(40 * time.Millisecond)
+			OKText(w, "done")
+		})
+		if w.Code != http.StatusOK {
+			t.Errorf("expected the route timeout of %s got %d", c.pattern, w.Code)
+		}
+	}
+
+	w = serve(http.MethodGet, "/events", func(w http.ResponseWriter, r *http.Request) {
+		http.NewResponseController(w).SetWriteDeadline(time.Time{})
+		time.Sleep(40 * time.Millisecond)
+		if r.Context().Err() != nil {
+			t.Error("expected the streaming context not cancelled")
+		}
+		OKText(w, "done")
+	})
+	if w.Code != http.StatusOK {
+		t.Errorf("expected a streaming handler exempt got %d", w.Code)
+	}
+
+	defer func() {
+		if p := recover(); p != "boom" {
+			t.Errorf("expected the handler panic in the request goroutine got %v", p)
+		}
+	}()
+	serve(http.MethodGet, "/panic", func(http.ResponseWriter, *http.Request) { panic("boom") })
+}
@@ -0,0 +1,159 @@
+package tus
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Upload is the persisted state of an upload
+type Upload struct {
+	ID        string            `json:"id"`
+	Size      int64             `json:"size"`
+	Offset    int64             `json:"offset"`
+	Metadata  map[string]string `json:"metadata,omitempty"`
+	CreatedAt time.Time         `json:"created_at"`
+	ExpiresAt time.Time         `json:"expires_at"`
+	// Path is the local file holding the upload data
+	Path string `json:"-"`
+}
+
+// Done reports whether all bytes have been received
+func (u *Upload) Done() bool {
+	return u.Offset == u.Size
+}
+
+// Expired reports whether an unfinished upload passed its expiration
+func (u *Upload) Expired(now time.Time) bool {
+	return !u.Done() && !u.ExpiresAt.IsZero() && now.After(u.ExpiresAt)
+}
+
+var errNotFound = errors.New("upload not found")
+
+// store keeps every upload as a data file and a json info file in dir,
+// so uploads survive a restart.
+type store struct {
+	dir string
+}
+
+func newID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func validID(id string) bool {
+	if len(id) != 32 {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
+
+func (s store) dataPath(id string) string {
+	return filepath.Join(s.dir, id+".bin")
+}
+
+func (s store) infoPath(id string) string {
+	return filepath.Join(s.dir, id+".info")
+}
+
+func (s store) create(u *Upload) error {
+	f, err := os.OpenFile(s.dataPath(u.ID), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	u.Path = s.dataPath(u.ID)
+	return s.save(u)
+}
+
+// save write the info file atomically
+func (s store) save(u *Upload) error {
+	data, err := json.Marshal(u)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(s.dir, ".info-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.infoPath(u.ID))
+}
+
+// get load the info, the offset is always the data file size in case
+// the process died before the info was saved.
+func (s store) get(id string) (*Upload, error) {
+	if !validID(id) {
+		return nil, errNotFound
+	}
+	data, err := os.ReadFile(s.infoPath(id))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, errNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	u := &Upload{}
+	if err := json.Unmarshal(data, u); err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(s.dataPath(id))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, errNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	u.Offset = info.Size()
+	u.Path = s.dataPath(id)
+	return u, nil
+}
+
+func (s store) remove(id string) error {
+	err := os.Remove(s.infoPath(id))
+	if errors.Is(err, fs.ErrNotExist) {
+		return errNotFound
+	}
+	if err != nil {
+		return err
+	}
+	err = os.Remove(s.dataPath(id))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func (s store) ids() ([]string, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for _, e := range entries {
+		id, ok := strings.CutSuffix(e.Name(), ".info")
+		if ok && validID(id) {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
@@ -0,0 +1,425 @@
+// Package tus implements a tus 1.0.0 resumable upload server
+// with the creation, creation-with-upload, termination and
+// expiration extensions. See https://tus.io/protocols/resumable-upload
+package tus
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/datewu/gtea"
+	"github.com/datewu/gtea/handler"
+	"github.com/datewu/gtea/router"
+)
+
+// protocol constants
+const (
+	Version    = "1.0.0"
+	Extensions = "creation,creation-with-upload,termination,expiration"
+	OffsetType = "application/offset+octet-stream"
+)
+
+// Config of a tus Server
+type Config struct {
+	Dir        string        // where uploads are persisted
+	MaxSize    int64         // max upload length, 0 no limit
+	Expiration time.Duration // unfinished uploads expire, default 24h
+	// OnComplete is called once all bytes of an upload have been
+	// received, it should not block, use BGJob to run a background job.
+	OnComplete func(Upload)
+}
+
+// Server serve tus requests
+type Server struct {
+	conf  Config
+	store store
+	mu    sync.Mutex
+	busy  map[string]bool // ids of the uploads being written
+}
+
+// New returns a Server, conf.Dir is created if not exists
+func New(conf Config) (*Server, error) {
+	if conf.Dir == "" {
+		return nil, errors.New("tus: no upload dir")
+	}
+	if conf.Expiration <= 0 {
+		conf.Expiration = 24 * time.Hour
+	}
+	if err := os.MkdirAll(conf.Dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &Server{conf: conf, store: store{dir: conf.Dir}, busy: make(map[string]bool)}, nil
+}
+
+// Mount register the tus routes at path of the group
+func (s *Server) Mount(g *router.RoutesGroup, path string) {
+	path = strings.TrimSuffix(path, "/")
+	g.HandleFunc(http.MethodOptions, path, s.Options)
+	g.Post(path, s.Create)
+	g.HandleFunc(http.MethodOptions, path+"/:id", s.Options)
+	g.HandleFunc(http.MethodHead, path+"/:id", s.Head)
+	g.Patch(path+"/:id", s.Patch)
+	g.Delete(path+"/:id", s.Terminate)
+}
+
+// Get load an upload by id
+func (s *Server) Get(id string) (*Upload, error) {
+	return s.store.get(id)
+}
+
+func fail(w http.ResponseWriter, r *http.Request, code int, msg string) {
+	if r.Method == http.MethodHead {
+		w.WriteHeader(code)
+		return
+	}
+	handler.WriteJSON(w, code, handler.Envelope{"error": msg}, nil)
+}
+
+// resumable check the Tus-Resumable header
+func resumable(w http.ResponseWriter, r *http.Request) bool {
+	w.Header().Set("Tus-Resumable", Version)
+	if r.Header.Get("Tus-Resumable") != Version {
+		w.Header().Set("Tus-Version", Version)
+		fail(w, r, http.StatusPreconditionFailed, "unsupported tus version")
+		return false
+	}
+	return true
+}
+
+// Options response the server capabilities
+func (s *Server) Options(w http.ResponseWriter, r *http.Request) {
+	h := w.Header()
+	h.Set("Tus-Resumable", Version)
+	h.Set("Tus-Version", Version)
+	h.Set("Tus-Extension", Extensions)
+	if s.conf.MaxSize > 0 {
+		h.Set("Tus-Max-Size", strconv.FormatInt(s.conf.MaxSize, 10))
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Create a new upload, the body is written when it is an OffsetType
+func (s *Server) Create(w http.ResponseWriter, r *http.Request) {
+	if !resumable(w, r) {
+		return
+	}
+	size, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || size < 0 {
+		fail(w, r, http.StatusBadRequest, "invalid Upload-Length header")
+		return
+	}
+	if s.conf.MaxSize > 0 && size > s.conf.MaxSize {
+		fail(w, r, http.StatusRequestEntityTooLarge, "upload is too large")
+		return
+	}
+	meta, err := parseMetadata(r.Header.Get("Upload-Metadata"))
+	if err != nil {
+		fail(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	id, err := newID()
+	if err != nil {
+		handler.ServerErr(w, err)
+		return
+	}
+	now := time.Now().UTC()
+	u := &Upload{
+		ID:        id,
+		Size:      size,
+		Metadata:  meta,
+		CreatedAt: now,
+		ExpiresAt: now.Add(s.conf.Expiration),
+	}
+	s.tryLock(id)
+	defer s.unlock(id)
+	if err := s.store.create(u); err != nil {
+		handler.ServerErr(w, err)
+		return
+	}
+	if size == 0 {
+		s.complete(u)
+	}
+	w.Header().Set("Location", strings.TrimSuffix(r.URL.Path, "/")+"/"+id)
+	w.Header().Set("Upload-Expires", u.ExpiresAt.Format(http.TimeFormat))
+	if isOffsetType(r) {
+		if !s.write(w, r, u) {
+			return
+		}
+		w.Header().Set("Upload-Offset", strconv.FormatInt(u.Offset, 10))
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+// Head response the upload offset
+func (s *Server) Head(w http.ResponseWriter, r *http.Request) {
+	if !resumable(w, r) {
+		return
+	}
+	u, ok := s.load(w, r)
+	if !ok {
+		return
+	}
+	h := w.Header()
+	h.Set("Cache-Control", "no-store")
+	h.Set("Upload-Offset", strconv.FormatInt(u.Offset, 10))
+	h.Set("Upload-Length", strconv.FormatInt(u.Size, 10))
+	if len(u.Metadata) > 0 {
+		h.Set("Upload-Metadata", formatMetadata(u.Metadata))
+	}
+	if !u.Done() {
+		h.Set("Upload-Expires", u.ExpiresAt.Format(http.TimeFormat))
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// Patch append the body at Upload-Offset
+func (s *Server) Patch(w http.ResponseWriter, r *http.Request) {
+	if !resumable(w, r) {
+		return
+	}
+	if !isOffsetType(r) {
+		fail(w, r, http.StatusUnsupportedMediaType, "content type must be "+OffsetType)
+		return
+	}
+	id := handler.ReadPathParam(r, "id")
+	if !validID(id) {
+		fail(w, r, http.StatusNotFound, "the requested upload could not be found")
+		return
+	}
+	if !s.tryLock(id) {
+		fail(w, r, http.StatusLocked, "upload is being written by another request")
+		return
+	}
+	defer s.unlock(id)
+	u, ok := s.load(w, r)
+	if !ok {
+		return
+	}
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		fail(w, r, http.StatusBadRequest, "invalid Upload-Offset header")
+		return
+	}
+	if offset != u.Offset {
+		fail(w, r, http.StatusConflict, "Upload-Offset does not match the upload offset")
+		return
+	}
+	if !s.write(w, r, u) {
+		return
+	}
+	w.Header().Set("Upload-Offset", strconv.FormatInt(u.Offset, 10))
+	if !u.Done() {
+		w.Header().Set("Upload-Expires", u.ExpiresAt.Format(http.TimeFormat))
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Terminate delete an upload
+func (s *Server) Terminate(w http.ResponseWriter, r *http.Request) {
+	if !resumable(w, r) {
+		return
+	}
+	id := handler.ReadPathParam(r, "id")
+	if !validID(id) {
+		fail(w, r, http.StatusNotFound, "the requested upload could not be found")
+		return
+	}
+	if !s.tryLock(id) {
+		fail(w, r, http.StatusLocked, "upload is being written by another request")
+		return
+	}
+	defer s.unlock(id)
+	err := s.store.remove(id)
+	if errors.Is(err, errNotFound) {
+		fail(w, r, http.StatusNotFound, "the requested upload could not be found")
+		return
+	}
+	if err != nil {
+		handler.ServerErr(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// tryLock marks the upload id busy, false when another request holds
+// it. Only the ids of the requests in flight are kept, unknown and
+// abandoned uploads leave nothing behind.
+func (s *Server) tryLock(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.busy[id] {
+		return false
+	}
+	s.busy[id] = true
+	return true
+}
+
+func (s *Server) unlock(id string) {
+	s.mu.Lock()
+	delete(s.busy, id)
+	s.mu.Unlock()
+}
+
+func (s *Server) load(w http.ResponseWriter, r *http.Request) (*Upload, bool) {
+	u, err := s.store.get(handler.ReadPathParam(r, "id"))
+	if errors.Is(err, errNotFound) {
+		fail(w, r, http.StatusNotFound, "the requested upload could not be found")
+		return nil, false
+	}
+	if err != nil {
+		handler.ServerErr(w, err)
+		return nil, false
+	}
+	if u.Expired(time.Now()) {
+		fail(w, r, http.StatusGone, "the upload has expired")
+		return nil, false
+	}
+	return u, true
+}
+
+// write append the request body to the upload, the written bytes are
+// kept even if the client goes away so the upload could be resumed.
+func (s *Server) write(w http.ResponseWriter, r *http.Request, u *Upload) bool {
+	remain := u.Size - u.Offset
+	if r.ContentLength > remain {
+		fail(w, r, http.StatusRequestEntityTooLarge, "body exceeds the upload length")
+		return false
+	}
+	f, err := os.OpenFile(u.Path, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		handler.ServerErr(w, err)
+		return false
+	}
+	n, copyErr := io.Copy(f, io.LimitReader(r.Body, remain))
+	if copyErr == nil && r.ContentLength < 0 && overflows(r.Body) {
+		// a chunked body longer than the upload, drop its bytes
+		f.Truncate(u.Offset)
+		f.Close()
+		fail(w, r, http.StatusRequestEntityTooLarge, "body exceeds the upload length")
+		return false
+	}
+	closeErr := f.Close()
+	u.Offset += n
+	if err := s.store.save(u); err != nil {
+		handler.ServerErr(w, err)
+		return false
+	}
+	if copyErr != nil {
+		fail(w, r, http.StatusBadRequest, "failed reading the request body")
+		return false
+	}
+	if closeErr != nil {
+		handler.ServerErr(w, closeErr)
+		return false
+	}
+	if n > 0 && u.Done() {
+		s.complete(u)
+	}
+	return true
+}
+
+// overflows reports whether body has bytes left
+func overflows(body io.Reader) bool {
+	n, _ := io.ReadFull(body, make([]byte, 1))
+	return n > 0
+}
+
+func (s *Server) complete(u *Upload) {
+	if s.conf.OnComplete != nil {
+		s.conf.OnComplete(*u)
+	}
+}
+
+func isOffsetType(r *http.Request) bool {
+	mt, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mt == OffsetType
+}
+
+// parseMetadata parse the "key base64value,key2" Upload-Metadata header
+func parseMetadata(header string) (map[string]string, error) {
+	if strings.TrimSpace(header) == "" {
+		return nil, nil
+	}
+	meta := make(map[string]string)
+	for _, pair := range strings.Split(header, ",") {
+		kv := strings.Fields(pair)
+		if len(kv) == 0 || len(kv) > 2 {
+			return nil, fmt.Errorf("invalid Upload-Metadata pair %q", pair)
+		}
+		var value string
+		if len(kv) == 2 {
+			v, err := base64.StdEncoding.DecodeString(kv[1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid Upload-Metadata value for %q", kv[0])
+			}
+			value = string(v)
+		}
+		meta[kv[0]] = value
+	}
+	return meta, nil
+}
+
+func formatMetadata(meta map[string]string) string {
+	pairs := make([]string, 0, len(meta))
+	for k, v := range meta {
+		if v == "" {
+			pairs = append(pairs, k)
+			continue
+		}
+		pairs = append(pairs, k+" "+base64.StdEncoding.EncodeToString([]byte(v)))
+	}
+	return strings.Join(pairs, ",")
+}
+
+// RemoveExpired delete all expired uploads
+func (s *Server) RemoveExpired() (int, error) {
+	ids, err := s.store.ids()
+	if err != nil {
+		return 0, err
+	}
+	now := time.Now()
+	removed := 0
+	for _, id := range ids {
+		u, err := s.store.get(id)
+		if err != nil || !u.Expired(now) {
+			continue
+		}
+		if !s.tryLock(id) {
+			continue
+		}
+		if s.store.remove(id) == nil {
+			removed++
+		}
+		s.unlock(id)
+	}
+	return removed, nil
+}
+
+// ExpireJob returns a background job for App.AddBGJob removing
+// expired uploads every interval until the app shuts down.
+func (s *Server) ExpireJob(interval time.Duration) func(context.Context, chan<- gtea.Message) {
+	return gtea.Periodic(interval, func(context.Context) { s.RemoveExpired() })
+}
+
+// BGJob returns an OnComplete callback firing fn as a background job
+// named "tus:<upload id>" through App.AddBGJob.
+func BGJob(app *gtea.App, fn func(context.Context, Upload)) func(Upload) {
+	return func(u Upload) {
+		err := app.AddBGJob("tus:"+u.ID, func(ctx context.Context, _ chan<- gtea.Message) {
+			fn(ctx, u)
+		})
+		if err != nil {
+			app.Logger.Err(err, map[string]any{"upload": u.ID})
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package tus
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/datewu/gtea/router"
+)
+
+func newTestHandler(t *testing.T, conf Config) (*Server, http.Handler) {
+	s, err := New(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, err := router.NewRoutesGroup(&router.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Mount(g, "/files")
+	return s, g.Handler()
+}
+
+func do(h http.Handler, method, path string, body string, headers map[string]string) *httptest.ResponseRecorder {
+	var rd io.Reader
+	if body != "" {
+		rd = strings.NewReader(body)
+	}
+	r := httptest.NewRequest(method, path, rd)
+	r.Header.Set("Tus-Resumable", Version)
+	for k, v := range headers {
+		r.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestUpload(t *testing.T) {
+	dir := t.TempDir()
+	var done []Upload
+	conf := Config{Dir: dir, OnComplete: func(u Upload) { done = append(done, u) }}
+	_, h := newTestHandler(t, conf)
+
+	w := do(h, http.MethodPost, "/files", "", map[string]string{
+		"Upload-Length":   "11",
+		"Upload-Metadata": "filename aGVsbG8udHh0,private",
+	})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected %d got %d %s", http.StatusCreated, w.Code, w.Body)
+	}
+	loc := w.Header().Get("Location")
+	if !strings.HasPrefix(loc, "/files/") {
+		t.Fatalf("unexpected Location %q", loc)
+	}
+	patch := func(h http.Handler, offset, body string) *httptest.ResponseRecorder {
+		return do(h, http.MethodPatch, loc, body, map[string]string{
+			"Upload-Offset": offset,
+			"Content-Type":  OffsetType,
+		})
+	}
+	w = patch(h, "0", "hello")
+	if w.Code != http.StatusNoContent || w.Header().Get("Upload-Offset") != "5" {
+		t.Fatalf("unexpected patch response %d %v", w.Code, w.Header())
+	}
+	w = patch(h, "0", "hello")
+	if w.Code != http.StatusConflict {
+		t.Errorf("expected %d got %d", http.StatusConflict, w.Code)
+	}
+
+	// uploads survive a restart
+	_, h = newTestHandler(t, conf)
+	w = do(h, http.MethodHead, loc, "", nil)
+	if w.Header().Get("Upload-Offset") != "5" || w.Header().Get("Upload-Length") != "11" {
+		t.Fatalf("unexpected head response %d %v", w.Code, w.Header())
+	}
+	w = patch(h, "5", " world")
+	if w.Code != http.StatusNoContent || w.Header().Get("Upload-Offset") != "11" {
+		t.Fatalf("unexpected patch response %d %v", w.Code, w.Header())
+	}
+	if len(done) != 1 || done[0].Metadata["filename"] != "hello.txt" {
+		t.Fatalf("unexpected completed uploads %+v", done)
+	}
+	data, _ := os.ReadFile(done[0].Path)
+	if string(data) != "hello world" {
+		t.Errorf("unexpected upload data %q", data)
+	}
+	w = do(h, http.MethodDelete, loc, "", nil)
+	if w.Code != http.StatusNoContent {
+		t.Errorf("expected %d got %d", http.StatusNoContent, w.Code)
+	}
+	w = do(h, http.MethodHead, loc, "", nil)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected %d got %d", http.StatusNotFound, w.Code)
+	}
+}
+
+func TestProtocolErrors(t *testing.T) {
+	s, h := newTestHandler(t, Config{Dir: t.TempDir(), MaxSize: 10, Expiration: time.Millisecond})
+	w := do(h, http.MethodOptions, "/files", "", nil)
+	if w.Header().Get("Tus-Extension") != Extensions || w.Header().Get("Tus-Max-Size") != "10" {
+		t.Errorf("unexpected options headers %v", w.Header())
+	}
+	w = do(h, http.MethodPost, "/files", "", map[string]string{"Upload-Length": "11"})
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected %d got %d", http.StatusRequestEntityTooLarge, w.Code)
+	}
+	w = do(h, http.MethodPost, "/files", "", map[string]string{"Upload-Length": "5", "Tus-Resumable": "0.2.2"})
+	if w.Code != http.StatusPreconditionFailed {
+		t.Errorf("expected %d got %d", http.StatusPreconditionFailed, w.Code)
+	}
+	w = do(h, http.MethodPost, "/files", "", map[string]string{"Upload-Length": "5"})
+	loc := w.Header().Get("Location")
+	time.Sleep(5 * time.Millisecond)
+	w = do(h, http.MethodHead, loc, "", nil)
+	if w.Code != http.StatusGone {
+		t.Errorf("expected %d got %d", http.StatusGone, w.Code)
+	}
+	n, err := s.RemoveExpired()
+	if err != nil || n != 1 {
+		t.Errorf("expected 1 expired upload removed got %d %v", n, err)
+	}
+}
+
+func TestChunkedOverflow(t *testing.T) {
+	var done []Upload
+	s, h := newTestHandler(t, Config{Dir: t.TempDir(), OnComplete: func(u Upload) { done = append(done, u) }})
+	w := do(h, http.MethodPost, "/files", "", map[string]string{"Upload-Length": "5"})
+	loc := w.Header().Get("Location")
+	patch := func(body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPatch, loc, io.MultiReader(strings.NewReader(body)))
+		r.ContentLength = -1
+		r.Header.Set("Tus-Resumable", Version)
+		r.Header.Set("Upload-Offset", "0")
+		r.Header.Set("Content-Type", OffsetType)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+	if w := patch("hello world"); w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected %d got %d", http.StatusRequestEntityTooLarge, w.Code)
+	}
+	w = do(h, http.MethodHead, loc, "", nil)
+	if w.Header().Get("Upload-Offset") != "0" || len(done) != 0 {
+		t.Fatalf("expected the overflowing body dropped got %v %+v", w.Header(), done)
+	}
+	if w := patch("hello"); w.Code != http.StatusNoContent || len(done) != 1 {
+		t.Fatalf("unexpected patch response %d %+v", w.Code, done)
+	}
+	w = do(h, http.MethodPatch, "/files/"+strings.Repeat("ab", 16), "x", map[string]string{
+		"Upload-Offset": "0",
+		"Content-Type":  OffsetType,
+	})
+	if w.Code != http.StatusNotFound || len(s.busy) != 0 {
+		t.Errorf("expected no lock left behind got %d %v", w.Code, s.busy)
+	}
+	data, _ := os.ReadFile(done[0].Path)
+	if string(data) != "hello" {
+		t.Errorf("unexpected upload data %q", data)
+	}
+}
@@ -0,0 +1,165 @@
+package upload
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Storage persists uploaded files by a generated name
+type Storage interface {
+	// Save write everything read from r as name, a partial file must
+	// not be left behind when r returns an error.
+	Save(ctx context.Context, name string, r io.Reader) (int64, error)
+	Open(ctx context.Context, name string) (io.ReadCloser, error)
+	Remove(ctx context.Context, name string) error
+}
+
+var errBadName = errors.New("invalid storage name")
+
+// validName only accept a plain file name
+func validName(name string) bool {
+	return name != "" && name != "." && name != ".." &&
+		!strings.ContainsAny(name, `/\`) && filepath.Base(name) == name
+}
+
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c ctxReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
+
+// Disk store files in a local directory
+type Disk struct {
+	Dir  string
+	Perm fs.FileMode
+}
+
+// NewDisk create the dir if not exists
+func NewDisk(dir string) (*Disk, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &Disk{Dir: dir, Perm: 0o644}, nil
+}
+
+// Path returns the full path of a stored file
+func (d *Disk) Path(name string) string {
+	return filepath.Join(d.Dir, name)
+}
+
+// Save write to a temp file then rename it, so readers never see a partial file
+func (d *Disk) Save(ctx context.Context, name string, r io.Reader) (int64, error) {
+	if !validName(name) {
+		return 0, errBadName
+	}
+	tmp, err := os.CreateTemp(d.Dir, ".upload-*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+	n, err := io.Copy(tmp, ctxReader{ctx: ctx, r: r})
+	if err != nil {
+		tmp.Close()
+		return n, err
+	}
+	if err := tmp.Close(); err != nil {
+		return n, err
+	}
+	perm := d.Perm
+	if perm == 0 {
+		perm = 0o644
+	}
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		return n, err
+	}
+	return n, os.Rename(tmp.Name(), d.Path(name))
+}
+
+// Open a stored file
+func (d *Disk) Open(_ context.Context, name string) (io.ReadCloser, error) {
+	if !validName(name) {
+		return nil, errBadName
+	}
+	return os.Open(d.Path(name))
+}
+
+// Remove a stored file
+func (d *Disk) Remove(_ context.Context, name string) error {
+	if !validName(name) {
+		return errBadName
+	}
+	return os.Remove(d.Path(name))
+}
+
+// Memory store files in memory, good for tests
+type Memory struct {
+	mu    sync.RWMutex
+	files map[string][]byte
+}
+
+// NewMemory returns an empty memory storage
+func NewMemory() *Memory {
+	return &Memory{files: make(map[string][]byte)}
+}
+
+// Save read all r into memory
+func (m *Memory) Save(ctx context.Context, name string, r io.Reader) (int64, error) {
+	if !validName(name) {
+		return 0, errBadName
+	}
+	var buf bytes.Buffer
+	n, err := io.Copy(&buf, ctxReader{ctx: ctx, r: r})
+	if err != nil {
+		return n, err
+	}
+	m.mu.Lock()
+	m.files[name] = buf.Bytes()
+	m.mu.Unlock()
+	return n, nil
+}
+
+// Open a stored file
+func (m *Memory) Open(_ context.Context, name string) (io.ReadCloser, error) {
+	m.mu.RLock()
+	data, ok := m.files[name]
+	m.mu.RUnlock()
+	if !ok {
+		return nil, fs.ErrNotExist
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+// Remove a stored file
+func (m *Memory) Remove(_ context.Context, name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.files[name]; !ok {
+		return fs.ErrNotExist
+	}
+	delete(m.files, name)
+	return nil
+}
+
+// Names returns all stored file names
+func (m *Memory) Names() []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	names := make([]string, 0, len(m.files))
+	for k := range m.files {
+		names = append(names, k)
+	}
+	return names
+}
@@ -0,0 +1,321 @@
+// Package upload streams multipart uploads to a Storage without
+// buffering whole files in memory or temp files.
+package upload
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/datewu/gtea/handler"
+)
+
+// errors returned by Receive
+var (
+	ErrNotMultipart   = errors.New("request is not a multipart form")
+	ErrFileTooLarge   = errors.New("file is too large")
+	ErrTooLarge       = errors.New("upload is too large")
+	ErrTooManyFiles   = errors.New("too many files")
+	ErrTypeNotAllowed = errors.New("file type is not allowed")
+	ErrUnknownField   = errors.New("unexpected file field")
+	ErrStorage        = errors.New("storage failed")
+)
+
+// Config limits of a multipart upload
+type Config struct {
+	Storage      Storage
+	MaxFileSize  int64    // per file, default 32MB
+	MaxTotalSize int64    // all files, default 4 * MaxFileSize
+	MaxFiles     int      // default 10
+	MaxValueSize int64    // all none file values, default 1MB
+	AllowedTypes []string // sniffed content types, "image/*" for a wildcard, empty allow all
+	Fields       []string // accepted file fields, empty accept all
+	Hash         func() hash.Hash
+}
+
+func (c *Config) defaults() {
+	if c.MaxFileSize < 1 {
+		c.MaxFileSize = 32 << 20
+	}
+	if c.MaxTotalSize < 1 {
+		c.MaxTotalSize = 4 * c.MaxFileSize
+	}
+	if c.MaxFiles < 1 {
+		c.MaxFiles = 10
+	}
+	if c.MaxValueSize < 1 {
+		c.MaxValueSize = 1 << 20
+	}
+	if c.Hash == nil {
+		c.Hash = sha256.New
+	}
+}
+
+// File is a received and stored file
+type File struct {
+	Field       string `json:"field"`
+	Filename    string `json:"filename"` // sanitized client file name, never used for storage
+	Name        string `json:"name"`     // generated storage name
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size"`
+	Checksum    string `json:"checksum"` // hex encoded
+}
+
+// Result of a multipart upload
+type Result struct {
+	Files  []File
+	Values url.Values
+}
+
+// Receive stream every file part of a multipart request into conf.Storage.
+// Stored files are removed when an error occurs.
+func Receive(w http.ResponseWriter, r *http.Request, conf Config) (*Result, error) {
+	if conf.Storage == nil {
+		return nil, errors.New("upload: no storage configured")
+	}
+	conf.defaults()
+	r.Body = http.MaxBytesReader(w, r.Body, conf.MaxTotalSize+conf.MaxValueSize+1<<20)
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, ErrNotMultipart
+	}
+	res := &Result{Values: url.Values{}}
+	var total, values int64
+	cleanup := func() {
+		for _, f := range res.Files {
+			conf.Storage.Remove(r.Context(), f.Name)
+		}
+	}
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return res, nil
+		}
+		if err != nil {
+			cleanup()
+			return nil, bodyErr(err)
+		}
+		if part.FileName() == "" {
+			n, err := readValue(part, res.Values, conf.MaxValueSize-values)
+			values += n
+			part.Close()
+			if err != nil {
+				cleanup()
+				return nil, err
+			}
+			continue
+		}
+		if len(res.Files) >= conf.MaxFiles {
+			part.Close()
+			cleanup()
+			return nil, ErrTooManyFiles
+		}
+		f, err := receiveFile(r, part, &conf, conf.MaxTotalSize-total)
+		part.Close()
+		if err != nil {
+			cleanup()
+			return nil, err
+		}
+		total += f.Size
+		res.Files = append(res.Files, *f)
+	}
+}
+
+func readValue(p part, values url.Values, remain int64) (int64, error) {
+	data, err := io.ReadAll(io.LimitReader(p, remain+1))
+	n := int64(len(data))
+	if err != nil {
+		return n, bodyErr(err)
+	}
+	if n > remain {
+		return n, ErrTooLarge
+	}
+	values.Add(p.FormName(), string(data))
+	return n, nil
+}
+
+type part interface {
+	io.Reader
+	FormName() string
+	FileName() string
+}
+
+func receiveFile(r *http.Request, p part, conf *Config, remain int64) (*File, error) {
+	if !accepted(conf.Fields, p.FormName()) {
+		return nil, ErrUnknownField
+	}
+	br := bufio.NewReaderSize(p, 512)
+	head, err := br.Peek(512)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return nil, bodyErr(err)
+	}
+	ct := http.DetectContentType(head)
+	if !allowedType(conf.AllowedTypes, ct) {
+		return nil, ErrTypeNotAllowed
+	}
+	name, err := generateName(p.FileName(), ct)
+	if err != nil {
+		return nil, err
+	}
+	limit := conf.MaxFileSize
+	tooLarge := ErrFileTooLarge
+	if remain < limit {
+		limit = remain
+		tooLarge = ErrTooLarge
+	}
+	h := conf.Hash()
+	lr := &limitReader{r: io.TeeReader(br, h), n: limit, err: tooLarge}
+	size, err := conf.Storage.Save(r.Context(), name, lr)
+	if lr.failed != nil {
+		return nil, bodyErr(lr.failed)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrStorage, err)
+	}
+	return &File{
+		Field:       p.FormName(),
+		Filename:    Sanitize(p.FileName()),
+		Name:        name,
+		ContentType: ct,
+		Size:        size,
+		Checksum:    hex.EncodeToString(h.Sum(nil)),
+	}, nil
+}
+
+// limitReader fails with err when more than n bytes are read,
+// failed keeps any error not caused by the storage.
+type limitReader struct {
+	r      io.Reader
+	n      int64
+	err    error
+	failed error
+}
+
+func (l *limitReader) Read(p []byte) (int, error) {
+	if l.failed != nil {
+		return 0, l.failed
+	}
+	if int64(len(p)) > l.n+1 {
+		p = p[:l.n+1]
+	}
+	n, err := l.r.Read(p)
+	if int64(n) > l.n {
+		l.failed = l.err
+		return 0, l.err
+	}
+	l.n -= int64(n)
+	if err != nil && err != io.EOF {
+		l.failed = err
+	}
+	return n, err
+}
+
+func bodyErr(err error) error {
+	var maxErr *http.MaxBytesError
+	if errors.As(err, &maxErr) {
+		return ErrTooLarge
+	}
+	return err
+}
+
+func accepted(fields []string, name string) bool {
+	if len(fields) == 0 {
+		return true
+	}
+	for _, f := range fields {
+		if f == name {
+			return true
+		}
+	}
+	return false
+}
+
+func allowedType(allowed []string, ct string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+	mt, _, err := mime.ParseMediaType(ct)
+	if err != nil {
+		return false
+	}
+	for _, a := range allowed {
+		if a == mt || (strings.HasSuffix(a, "/*") && strings.HasPrefix(mt, a[:len(a)-1])) {
+			return true
+		}
+	}
+	return false
+}
+
+// generateName returns a random name with an extension of the sniffed
+// content type, the one of the client file name when it is among them,
+// so e.g. a png named "x.html" is never served as html.
+func generateName(filename, ct string) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	exts, _ := mime.ExtensionsByType(ct)
+	if len(exts) == 0 {
+		return hex.EncodeToString(b), nil
+	}
+	ext := strings.ToLower(path.Ext(Sanitize(filename)))
+	if !safeExt(ext) || !slices.Contains(exts, ext) {
+		ext = exts[0]
+	}
+	return hex.EncodeToString(b) + ext, nil
+}
+
+func safeExt(ext string) bool {
+	if len(ext) < 2 || len(ext) > 10 {
+		return false
+	}
+	for _, c := range ext[1:] {
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// Sanitize returns the base name of a client supplied file name
+// without any directory, returns "" when nothing usable is left.
+func Sanitize(filename string) string {
+	filename = strings.ReplaceAll(filename, `\`, "/")
+	base := path.Base(path.Clean("/" + filename))
+	base = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f {
+			return -1
+		}
+		return r
+	}, base)
+	if base == "/" || base == "." || base == ".." {
+		return ""
+	}
+	return base
+}
+
+// Err response a Receive error with a proper status code
+func Err(w http.ResponseWriter, err error) {
+	status := http.StatusBadRequest
+	switch {
+	case errors.Is(err, ErrFileTooLarge), errors.Is(err, ErrTooLarge):
+		status = http.StatusRequestEntityTooLarge
+	case errors.Is(err, ErrTypeNotAllowed), errors.Is(err, ErrNotMultipart):
+		status = http.StatusUnsupportedMediaType
+	case errors.Is(err, ErrStorage):
+		handler.ServerErr(w, err)
+		return
+	}
+	handler.WriteJSON(w, status, handler.Envelope{"error": err.Error()}, nil)
+}
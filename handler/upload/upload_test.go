@@ -0,0 +1,136 @@
+package upload
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var pngHead = []byte("\x89PNG\x0D\x0A\x1A\x0A" + "lol png body")
+
+func multipartReq(t *testing.T, files map[string][]byte, values map[string]string) *http.Request {
+	body := new(bytes.Buffer)
+	mw := multipart.NewWriter(body)
+	for k, v := range values {
+		mw.WriteField(k, v)
+	}
+	for name, data := range files {
+		fw, err := mw.CreateFormFile("file", name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fw.Write(data)
+	}
+	mw.Close()
+	r := httptest.NewRequest(http.MethodPost, "/upload", body)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	return r
+}
+
+func TestReceive(t *testing.T) {
+	store := NewMemory()
+	conf := Config{Storage: store, AllowedTypes: []string{"image/*"}}
+	r := multipartReq(t, map[string][]byte{"../../etc/x.PNG": pngHead}, map[string]string{"title": "hi"})
+	res, err := Receive(httptest.NewRecorder(), r, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Files) != 1 || res.Values.Get("title") != "hi" {
+		t.Fatalf("unexpected result %+v", res)
+	}
+	f := res.Files[0]
+	sum := sha256.Sum256(pngHead)
+	if f.Checksum != hex.EncodeToString(sum[:]) {
+		t.Errorf("checksum mismatch %s", f.Checksum)
+	}
+	if f.Filename != "x.PNG" || filepath.Ext(f.Name) != ".png" || f.ContentType != "image/png" {
+		t.Errorf("unexpected file %+v", f)
+	}
+	rc, err := store.Open(context.Background(), f.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(rc)
+	if !bytes.Equal(data, pngHead) {
+		t.Errorf("stored data mismatch")
+	}
+}
+
+func TestReceivePolyglot(t *testing.T) {
+	conf := Config{Storage: NewMemory(), AllowedTypes: []string{"image/*"}}
+	r := multipartReq(t, map[string][]byte{"x.html": pngHead}, nil)
+	res, err := Receive(httptest.NewRecorder(), r, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ext := filepath.Ext(res.Files[0].Name); ext != ".png" {
+		t.Errorf("expected the extension of the sniffed type got %q", ext)
+	}
+}
+
+func TestReceiveLimits(t *testing.T) {
+	store := NewMemory()
+	conf := Config{Storage: store, AllowedTypes: []string{"image/png"}, MaxFileSize: 10}
+	r := multipartReq(t, map[string][]byte{"a.png": pngHead}, nil)
+	w := httptest.NewRecorder()
+	_, err := Receive(w, r, conf)
+	if err != ErrFileTooLarge {
+		t.Fatalf("expected %v got %v", ErrFileTooLarge, err)
+	}
+	Err(w, err)
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected %d got %d", http.StatusRequestEntityTooLarge, w.Code)
+	}
+	conf.MaxFileSize = 0
+	r = multipartReq(t, map[string][]byte{"a.png": []byte("<html>")}, nil)
+	_, err = Receive(httptest.NewRecorder(), r, conf)
+	if err != ErrTypeNotAllowed {
+		t.Fatalf("expected %v got %v", ErrTypeNotAllowed, err)
+	}
+	if len(store.Names()) != 0 {
+		t.Errorf("expected no stored files got %v", store.Names())
+	}
+}
+
+func TestDisk(t *testing.T) {
+	dir := t.TempDir()
+	d, err := NewDisk(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := multipartReq(t, map[string][]byte{"a.txt": []byte("hello")}, nil)
+	res, err := Receive(httptest.NewRecorder(), r, Config{Storage: d})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(d.Path(res.Files[0].Name))
+	if err != nil || string(data) != "hello" {
+		t.Errorf("unexpected stored file %q %v", data, err)
+	}
+	if _, err := d.Save(context.Background(), "../x", bytes.NewReader(nil)); err == nil {
+		t.Error("expected bad name error")
+	}
+}
+
+func TestSanitize(t *testing.T) {
+	table := map[string]string{
+		"../../etc/x":     "x",
+		`..\..\win\y.txt`: "y.txt",
+		"..":              "",
+		"/":               "",
+		"ok.png":          "ok.png",
+	}
+	for in, expect := range table {
+		if got := Sanitize(in); got != expect {
+			t.Errorf("%q: expected %q got %q", in, expect, got)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package webhook
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FormatFunc adapts a function to a Format
+type FormatFunc func(r *http.Request, body []byte) (*Signed, error)
+
+// Signed calls f
+func (f FormatFunc) Signed(r *http.Request, body []byte) (*Signed, error) {
+	return f(r, body)
+}
+
+// GitHub signs the body in X-Hub-Signature-256 "sha256=<hex>". GitHub
+// sends no timestamp and the X-GitHub-Delivery header isn't signed,
+// deliveries are told apart by their payload.
+func GitHub() Format {
+	return FormatFunc(func(r *http.Request, body []byte) (*Signed, error) {
+		hexSig, ok := strings.CutPrefix(r.Header.Get("X-Hub-Signature-256"), "sha256=")
+		if !ok {
+			return nil, ErrNoSignature
+		}
+		sig, err := hex.DecodeString(hexSig)
+		if err != nil {
+			return nil, ErrBadSignature
+		}
+		return &Signed{Payload: body, Signatures: [][]byte{sig}}, nil
+	})
+}
+
+// Stripe signs "<t>.<body>" in Stripe-Signature "t=<unix>,v1=<hex>",
+// there is a v1 per active secret.
+func Stripe() Format {
+	return FormatFunc(func(r *http.Request, body []byte) (*Signed, error) {
+		header := r.Header.Get("Stripe-Signature")
+		if header == "" {
+			return nil, ErrNoSignature
+		}
+		s := &Signed{}
+		var ts string
+		for _, part := range strings.Split(header, ",") {
+			k, v, _ := strings.Cut(strings.TrimSpace(part), "=")
+			switch k {
+			case "t":
+				ts = v
+			case "v1":
+				if sig, err := hex.DecodeString(v); err == nil {
+					s.Signatures = append(s.Signatures, sig)
+				}
+			}
+		}
+		t, err := parseUnix(ts)
+		if err != nil {
+			return nil, err
+		}
+		s.Timestamp = t
+		s.Payload = append([]byte(ts+"."), body...)
+		return s, nil
+	})
+}
+
+// GenericConfig of a Generic format
+type GenericConfig struct {
+	// Header of the signature, default "X-Signature"
+	Header string
+	// Prefix before the signature, e.g. "sha256="
+	Prefix string
+	// Base64 signatures instead of hex
+	Base64 bool
+	// TimestampHeader of the unix seconds, the payload is then
+	// "<timestamp>.<body>", empty signs the body only
+	TimestampHeader string
+}
+
+// Generic is a configurable HMAC-SHA256 format
+func Generic(conf GenericConfig) Format {
+	if conf.Header == "" {
+		conf.Header = "X-Signature"
+	}
+	return FormatFunc(func(r *http.Request, body []byte) (*Signed, error) {
+		raw, ok := strings.CutPrefix(r.Header.Get(conf.Header), conf.Prefix)
+		if !ok || raw == "" {
+			return nil, ErrNoSignature
+		}
+		var sig []byte
+		var err error
+		if conf.Base64 {
+			sig, err = base64.StdEncoding.DecodeString(raw)
+		} else {
+			sig, err = hex.DecodeString(raw)
+		}
+		if err != nil {
+			return nil, ErrBadSignature
+		}
+		s := &Signed{Payload: body, Signatures: [][]byte{sig}}
+		if conf.TimestampHeader != "" {
+			ts := r.Header.Get(conf.TimestampHeader)
+			t, err := parseUnix(ts)
+			if err != nil {
+				return nil, err
+			}
+			s.Timestamp = t
+			s.Payload = append([]byte(ts+"."), body...)
+		}
+		return s, nil
+	})
+}
+
+func parseUnix(ts string) (time.Time, error) {
+	sec, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return time.Time{}, ErrTimestamp
+	}
+	return time.Unix(sec, 0), nil
+}
@@ -0,0 +1,225 @@
+// Package webhook verifies the HMAC-SHA256 signature of inbound
+// webhooks over the raw body, with timestamp tolerance and replay
+// protection, and presets for GitHub, Stripe and generic senders.
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/datewu/gtea/handler"
+)
+
+// verification errors
+var (
+	ErrNoSignature  = errors.New("webhook: missing signature")
+	ErrBadSignature = errors.New("webhook: invalid signature")
+	ErrTimestamp    = errors.New("webhook: timestamp out of tolerance")
+	ErrReplayed     = errors.New("webhook: replayed delivery")
+	ErrTooLarge     = errors.New("webhook: body too large")
+)
+
+// Signed is what a Format found in a request
+type Signed struct {
+	// Payload the signatures are computed over
+	Payload []byte
+	// Signatures are candidate MACs, one matching is enough
+	Signatures [][]byte
+	// Timestamp of the delivery, zero if the format has none
+	Timestamp time.Time
+	// ID of the delivery for replay protection, default the sha256 of
+	// Payload. A Format sets it only when the signatures cover it, an
+	// unsigned id would let a captured delivery be replayed under a
+	// new one.
+	ID string
+}
+
+// Format extracts the signatures of a request, GitHub, Stripe and
+// Generic are the presets.
+type Format interface {
+	Signed(r *http.Request, body []byte) (*Signed, error)
+}
+
+// Config of a Verifier
+type Config struct {
+	// Secrets any of which may have signed, for rotation
+	Secrets [][]byte
+	Format  Format
+	// Tolerance of the delivery timestamp, default 5 minutes
+	Tolerance time.Duration
+	// Replay remembers deliveries, default a Memory cache, the window
+	// is the tolerance, or a day for formats without timestamp
+	Replay ReplayCache
+	// MaxBody default 1MB
+	MaxBody int64
+	Now     func() time.Time
+}
+
+// Verifier checks webhook signatures
+type Verifier struct {
+	conf Config
+}
+
+// New returns a Verifier
+func New(conf Config) (*Verifier, error) {
+	if len(conf.Secrets) == 0 {
+		return nil, errors.New("webhook: no secret")
+	}
+	if conf.Format == nil {
+		return nil, errors.New("webhook: no format")
+	}
+	if conf.Tolerance <= 0 {
+		conf.Tolerance = 5 * time.Minute
+	}
+	if conf.Replay == nil {
+		conf.Replay = NewMemory()
+	}
+	if conf.MaxBody <= 0 {
+		conf.MaxBody = 1_048_576
+	}
+	if conf.Now == nil {
+		conf.Now = time.Now
+	}
+	return &Verifier{conf: conf}, nil
+}
+
+func mac(secret, payload []byte) []byte {
+	m := hmac.New(sha256.New, secret)
+	m.Write(payload)
+	return m.Sum(nil)
+}
+
+// Verify read the body of r and check its signature, the body is
+// reset so r could be read again.
+func (v *Verifier) Verify(r *http.Request) ([]byte, error) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, v.conf.MaxBody+1))
+	r.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(body)) > v.conf.MaxBody {
+		return nil, ErrTooLarge
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	r.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(body)), nil
+	}
+	r.ContentLength = int64(len(body))
+
+	s, err := v.conf.Format.Signed(r, body)
+	if err != nil {
+		return nil, err
+	}
+	if len(s.Signatures) == 0 {
+		return nil, ErrNoSignature
+	}
+	if !v.match(s) {
+		return nil, ErrBadSignature
+	}
+	now := v.conf.Now()
+	window := 24 * time.Hour
+	if !s.Timestamp.IsZero() {
+		d := now.Sub(s.Timestamp)
+		if d > v.conf.Tolerance || d < -v.conf.Tolerance {
+			return nil, ErrTimestamp
+		}
+		window = 2 * v.conf.Tolerance
+	}
+	id := s.ID
+	if id == "" {
+		sum := sha256.Sum256(s.Payload)
+		id = hex.EncodeToString(sum[:])
+	}
+	if v.conf.Replay.Seen(id, now.Add(window)) {
+		return nil, ErrReplayed
+	}
+	return body, nil
+}
+
+func (v *Verifier) match(s *Signed) bool {
+	for _, secret := range v.conf.Secrets {
+		want := mac(secret, s.Payload)
+		for _, sig := range s.Signatures {
+			if hmac.Equal(sig, want) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+type ctxKey struct{}
+
+// Body returns the verified body put by the middleware, the request
+// body itself can also be read again, e.g. by handler.ReadJSON
+func Body(r *http.Request) []byte {
+	b, _ := r.Context().Value(ctxKey{}).([]byte)
+	return b
+}
+
+// Middleware reject requests without a valid signature
+func (v *Verifier) Middleware(next http.HandlerFunc) http.HandlerFunc {
+	middle := func(w http.ResponseWriter, r *http.Request) {
+		body, err := v.Verify(r)
+		switch {
+		case errors.Is(err, ErrTooLarge):
+			handler.WriteJSON(w, http.StatusRequestEntityTooLarge, handler.Envelope{"error": err.Error()}, nil)
+			return
+		case errors.Is(err, ErrNoSignature), errors.Is(err, ErrBadSignature),
+			errors.Is(err, ErrTimestamp), errors.Is(err, ErrReplayed):
+			handler.WriteJSON(w, http.StatusUnauthorized, handler.Envelope{"error": err.Error()}, nil)
+			return
+		case err != nil:
+			handler.BadRequestErr(w, err)
+			return
+		}
+		next(w, handler.SetValue(r, ctxKey{}, body))
+	}
+	return middle
+}
+
+// ReplayCache remembers delivery ids
+type ReplayCache interface {
+	// Seen reports whether id was seen, or records it until expire
+	Seen(id string, expire time.Time) bool
+}
+
+// Memory is an in memory ReplayCache
+type Memory struct {
+	mu      sync.Mutex
+	ids     map[string]time.Time
+	inserts int
+	now     func() time.Time
+}
+
+// NewMemory returns an empty Memory cache
+func NewMemory() *Memory {
+	return &Memory{ids: make(map[string]time.Time), now: time.Now}
+}
+
+// Seen reports whether id was seen, or records it until expire
+func (m *Memory) Seen(id string, expire time.Time) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := m.now()
+	if until, ok := m.ids[id]; ok && now.Before(until) {
+		return true
+	}
+	m.inserts++
+	if m.inserts%1024 == 0 {
+		for k, until := range m.ids {
+			if !now.Before(until) {
+				delete(m.ids, k)
+			}
+		}
+	}
+	m.ids[id] = expire
+	return false
+}
@@ -0,0 +1,107 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/datewu/gtea/handler"
+)
+
+func sign(secret, payload string) string {
+	m := hmac.New(sha256.New, []byte(secret))
+	m.Write([]byte(payload))
+	return hex.EncodeToString(m.Sum(nil))
+}
+
+func TestGitHub(t *testing.T) {
+	v, err := New(Config{Secrets: [][]byte{[]byte("new"), []byte("old")}, Format: GitHub()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := v.Middleware(func(w http.ResponseWriter, r *http.Request) {
+		var in struct{ Action string }
+		if err := handler.ReadJSON(r, &in); err != nil {
+			handler.BadRequestErr(w, err)
+			return
+		}
+		handler.OKText(w, in.Action+"|"+string(Body(r)))
+	})
+	body := `{"action":"opened"}`
+	send := func(sig, delivery string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/hooks/github", strings.NewReader(body))
+		if sig != "" {
+			r.Header.Set("X-Hub-Signature-256", "sha256="+sig)
+		}
+		r.Header.Set("X-GitHub-Delivery", delivery)
+		w := httptest.NewRecorder()
+		h(w, r)
+		return w
+	}
+	if w := send(sign("old", body), "1"); w.Code != http.StatusOK || w.Body.String() != "opened|"+body {
+		t.Errorf("expected the body re-readable got %d %q", w.Code, w.Body)
+	}
+	if w := send(sign("old", body), "1"); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected replayed delivery rejected got %d", w.Code)
+	}
+	if w := send(sign("old", body), "4"); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected a replay under a new delivery id rejected got %d", w.Code)
+	}
+	if w := send(sign("other", body), "2"); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected bad signature rejected got %d", w.Code)
+	}
+	if w := send("", "3"); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected missing signature rejected got %d", w.Code)
+	}
+}
+
+func TestStripe(t *testing.T) {
+	now := time.Now()
+	v, _ := New(Config{Secrets: [][]byte{[]byte("whsec")}, Format: Stripe(), Now: func() time.Time { return now }})
+	body := `{"id":"evt_1"}`
+	check := func(ts time.Time) error {
+		t := strconv.FormatInt(ts.Unix(), 10)
+		r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		r.Header.Set("Stripe-Signature", "t="+t+",v1="+sign("other", t+"."+body)+",v1="+sign("whsec", t+"."+body))
+		_, err := v.Verify(r)
+		return err
+	}
+	if err := check(now.Add(-time.Minute)); err != nil {
+		t.Errorf("expected valid signature got %v", err)
+	}
+	if err := check(now.Add(-10 * time.Minute)); err != ErrTimestamp {
+		t.Errorf("expected %v got %v", ErrTimestamp, err)
+	}
+}
+
+func TestGeneric(t *testing.T) {
+	v, _ := New(Config{
+		Secrets: [][]byte{[]byte("s")},
+		Format:  Generic(GenericConfig{Prefix: "v1=", TimestampHeader: "X-Timestamp"}),
+		MaxBody: 8,
+	})
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	deliver := func(id string) ([]byte, error) {
+		r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("ping"))
+		r.Header.Set("X-Timestamp", ts)
+		r.Header.Set("X-Id", id)
+		r.Header.Set("X-Signature", "v1="+sign("s", ts+".ping"))
+		return v.Verify(r)
+	}
+	if body, err := deliver("a"); err != nil || string(body) != "ping" {
+		t.Errorf("unexpected verify %q %v", body, err)
+	}
+	if _, err := deliver("b"); err != ErrReplayed {
+		t.Errorf("expected a replay under a new id rejected got %v", err)
+	}
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("too large body"))
+	if _, err := v.Verify(r); err != ErrTooLarge {
+		t.Errorf("expected %v got %v", ErrTooLarge, err)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"time"
 )
 
 // Message chan feedback
@@ -19,13 +20,15 @@ type JobParam struct {
 }
 
 // AddClearFn add defer func in app.shutdown you may add db.close, redis.close, etc
-// not goroutine safe
+// goroutine safe
 func (app *App) AddClearFn(fn func()) {
 	f := func() {
 		defer app.clearWG.Done()
 		fn()
 	}
+	app.clearLock.Lock()
 	app.clearFns = append(app.clearFns, f)
+	app.clearLock.Unlock()
 }
 
 // FireAnonymousJob start a background job, goroutine safe
@@ -75,6 +78,23 @@ func (app *App) AddBGJob(name string, fn func(context.Context, chan<- Message))
 	return nil
 }
 
+// Periodic returns a background job for AddBGJob calling fn every
+// interval until the app shuts down
+func Periodic(interval time.Duration, fn func(context.Context)) func(context.Context, chan<- Message) {
+	return func(ctx context.Context, _ chan<- Message) {
+		tick := time.NewTicker(interval)
+		defer tick.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-tick.C:
+				fn(ctx)
+			}
+		}
+	}
+}
+
 // GetBGJobParam get backgroud job param
 // goroutine safe
 func (app *App) GetBGJobParam(name string) *JobParam {
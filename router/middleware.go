@@ -5,26 +5,95 @@ import (
 	"net/http"
 
 	"github.com/datewu/gtea/handler"
+	"github.com/datewu/gtea/handler/concurrency"
+	"github.com/datewu/gtea/handler/ratelimit"
 )
 
 func (r *Router) rateLimitMiddleware() handler.Middleware {
-	return handler.RateLimitMiddleware(r.conf.Limiter.Rps, r.conf.Limiter.Burst)
+	alg, err := ratelimit.ParseAlgorithm(r.conf.Limiter.Algorithm)
+	if err != nil {
+		panic(err)
+	}
+	l := ratelimit.New(ratelimit.Config{
+		Rule: ratelimit.Rule{
+			Rps:   r.conf.Limiter.Rps,
+			Burst: r.conf.Limiter.Burst,
+			Key:   r.conf.Limiter.Key,
+		},
+		Routes:    r.conf.Limiter.Routes,
+		Algorithm: alg,
+		Store:     r.conf.Limiter.Store,
+	})
+	r.closers = append(r.closers, l.Close)
+	return l.Middleware
 }
 
 func (r *Router) corsMiddleware() handler.Middleware {
-	return handler.CORSMiddleware(r.conf.CORS.TrustedOrigins)
+	cors, err := handler.CORS(r.conf.CORS)
+	if err != nil {
+		panic(err)
+	}
+	return cors
 }
 
 func (r *Router) aggBuildInMiddlewares() {
 	if r.conf.Limiter.Enabled {
 		r.middleware = handler.Insert(r.middleware, r.rateLimitMiddleware())
 	}
-	if r.conf.CORS.TrustedOrigins != nil {
+	if r.conf.CORS.Enabled() {
 		r.middleware = handler.Insert(r.middleware, r.corsMiddleware())
 	}
-	if r.conf.Metrics {
-		r.Handle(http.MethodGet, "/debug/vars", expvar.Handler())
-		r.middleware = handler.Insert(r.middleware, handler.MetricsMiddleware)
+	var (
+		lim   *concurrency.Limiter
+		hooks []handler.MetricsHook
+	)
+	if c := r.conf.Concurrency; c.Limit > 0 || c.Target > 0 {
+		lim = concurrency.New(concurrency.Config{
+			Limit:   c.Limit,
+			Queue:   c.Queue,
+			Timeout: c.Timeout,
+			Target:  c.Target,
+		})
+		if c.Target > 0 {
+			hooks = append(hooks, lim.Observe)
+		}
+	}
+	if r.conf.Metrics || len(hooks) > 0 {
+		if r.conf.Metrics {
+			r.Handle(http.MethodGet, "/debug/vars", expvar.Handler())
+		}
+		r.middleware = handler.Insert(r.middleware, handler.Metrics(hooks...))
+	}
+	if lim != nil {
+		// outside the metrics, the latency is the one of admitted requests
+		r.middleware = handler.Insert(r.middleware, lim.Middleware)
+	}
+	if r.conf.AccessLog.Enabled {
+		// outside the limiters, shed requests are logged too
+		r.middleware = handler.Insert(r.middleware, handler.AccessLog(r.conf.AccessLog.AccessLogConfig))
+	}
+	if len(r.conf.TrustedProxies) > 0 {
+		realIP, err := handler.RealIP(handler.RealIPConfig{TrustedProxies: r.conf.TrustedProxies})
+		if err != nil {
+			panic(err)
+		}
+		// outermost, so every other middleware sees the client IP
+		r.middleware = handler.Insert(r.middleware, realIP)
+	}
+	if b := r.conf.Body; b.MaxBytes > 0 || len(b.Routes) > 0 || b.Decompress {
+		r.middleware = handler.Append(r.middleware, handler.BodyLimit(handler.BodyConfig{
+			MaxBytes:   b.MaxBytes,
+			Routes:     b.Routes,
+			Decompress: b.Decompress,
+			MaxRatio:   b.MaxRatio,
+		}))
+	}
+	if t := r.conf.Timeout; t.Default > 0 || len(t.Routes) > 0 {
+		r.middleware = handler.Append(r.middleware, handler.Timeout(handler.TimeoutConfig{
+			Timeout: t.Default,
+			Routes:  t.Routes,
+			Status:  t.Status,
+		}))
 	}
-	r.middleware = handler.Append(r.middleware, handler.RecoverPanicMiddleware)
+	r.middleware = handler.Append(r.middleware, handler.Recover(r.conf.Recover))
 }
@@ -11,21 +11,32 @@ type Router struct {
 	conf       *Config
 	trie       *pathTrie
 	middleware handler.Middleware
+	closers    []func()
 }
 
 // Handler serveHTTP
 type Handler struct {
-	trie pathTrie
-	md   handler.Middleware
+	trie    pathTrie
+	md      handler.Middleware
+	closers []func()
+}
+
+// Close stop the background work of the built-in middlewares, e.g. the
+// rate limiter cleanup, App.Serve calls it on shutdown
+func (h Handler) Close() {
+	for _, fn := range h.closers {
+		fn()
+	}
 }
 
 func (h Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	var hf http.HandlerFunc
-	tHandler := h.trie.get(r.Method + r.URL.Path)
+	tHandler, pattern := h.trie.match(r.Method + r.URL.Path)
 	if tHandler == nil {
 		hf = handler.NotFoundMsg("the requested resource could not be found")
 	} else {
 		hf = tHandler.ServeHTTP
+		r = handler.SetValue(r, handler.RouteCtxKey, pattern)
 	}
 	h.md(hf)(w, r)
 }
@@ -36,8 +47,9 @@ func (ro *Router) Handler() Handler {
 	}
 	ro.aggBuildInMiddlewares()
 	h := Handler{
-		trie: *ro.trie,
-		md:   ro.middleware,
+		trie:    *ro.trie,
+		md:      ro.middleware,
+		closers: ro.closers,
 	}
 	if h.md == nil {
 		h.md = handler.VoidMiddleware
@@ -52,7 +64,7 @@ func NewRouter(c *Config) *Router {
 }
 
 func (r *Router) Handle(method, path string, h http.Handler) {
-	r.trie.put(method+path, h)
+	r.trie.put(method+path, h).pattern = path
 }
 
 func (r *Router) HandleFunc(method, path string, hf http.HandlerFunc) {
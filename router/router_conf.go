@@ -1,5 +1,12 @@
 package router
 
+import (
+	"time"
+
+	"github.com/datewu/gtea/handler"
+	"github.com/datewu/gtea/handler/ratelimit"
+)
+
 // Config is the configuration for the router
 type Config struct {
 	Debug   bool
@@ -7,11 +14,68 @@ type Config struct {
 		Rps     float64
 		Burst   int
 		Enabled bool
+		// Key of the buckets, default the client IP, which is also
+		// used when Key returns "". The limiter runs before the group
+		// middlewares, so ratelimit.User keys are always empty here.
+		Key ratelimit.KeyFunc
+		// Routes rules replacing Rps and Burst by route pattern,
+		// "/login" or "POST /login"
+		Routes map[string]ratelimit.Rule
+		// Algorithm token_bucket (default), sliding_log,
+		// sliding_window or gcra
+		Algorithm string
+		// Store of the algorithm state, default in memory
+		Store ratelimit.Store
+	}
+	// CORS config, the middleware is enabled by any origin
+	CORS handler.CORSConfig
+	// Concurrency caps the in flight requests of the router, see the
+	// concurrency package to cap a routes group
+	Concurrency struct {
+		// Limit of the in flight requests, 0 for no limit
+		Limit int
+		// Queue of the requests waiting up to Timeout for a slot
+		Queue   int
+		Timeout time.Duration
+		// Target latency of the adaptive load shedding, 0 disables it
+		Target time.Duration
 	}
-	CORS struct {
-		TrustedOrigins []string
+	// Timeout of the requests, see handler.Timeout to set the timeout
+	// of a routes group
+	Timeout struct {
+		// Default timeout of every route, 0 for none
+		Default time.Duration
+		// Routes timeouts by route pattern, "/report" or
+		// "POST /report", 0 for none
+		Routes map[string]time.Duration
+		// Status of the timeout response, default 503
+		Status int
+	}
+	// Body limits the request bodies, see handler.BodyLimit to limit
+	// the bodies of a routes group
+	Body struct {
+		// MaxBytes of every request body, 0 for no limit
+		MaxBytes int64
+		// Routes limits by route pattern, "/upload" or
+		// "PUT /upload", 0 for no limit
+		Routes map[string]int64
+		// Decompress gzip, deflate and zstd request bodies
+		Decompress bool
+		// MaxRatio of the decompressed size, default 100
+		MaxRatio int64
+	}
+	// AccessLog writes a jsonlog entry per request when Enabled
+	AccessLog struct {
+		Enabled bool
+		handler.AccessLogConfig
 	}
 	Metrics bool
+	// TrustedProxies CIDRs or IPs, the RealIP middleware then reads
+	// the client IP from their Forwarded or X-Forwarded-For headers
+	TrustedProxies []string
+	// Recover config of the panic recovery middleware, see
+	// App.RecoverConfig
+	Recover handler.RecoverConfig
 }
 
 // DefaultConf return the default config
@@ -195,3 +195,70 @@ func TestAggNormalMiddlerWithAbort(t *testing.T) {
 	expect := msg
 	getReqHelper("/", r.Handler(), http.StatusOK, expect, t)
 }
+
+func TestRoutePattern(t *testing.T) {
+	r := NewRouter(&Config{})
+	var seen string
+	r.middleware = func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, req *http.Request) {
+			seen = handler.RoutePattern(req)
+			next(w, req)
+		}
+	}
+	r.Get("/users/:id/posts", func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(handler.RoutePattern(req)))
+	})
+	getReqHelper("/users/7/posts", r.Handler(), http.StatusOK, "/users/:id/posts", t)
+	if seen != "/users/:id/posts" {
+		t.Errorf("expected the pattern before the middlewares got %q", seen)
+	}
+}
+
+func TestConcurrencyLimit(t *testing.T) {
+	conf := &Config{}
+	conf.Concurrency.Limit = 1
+	r := NewRouter(conf)
+	release := make(chan struct{})
+	started := make(chan struct{})
+	r.Get("/slow", func(w http.ResponseWriter, req *http.Request) {
+		close(started)
+		<-release
+	})
+	h := r.Handler()
+	done := make(chan struct{})
+	go func() {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/slow", nil))
+		close(done)
+	}()
+	<-started
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/slow", nil))
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") != "1" {
+		t.Errorf("expected 503 over the limit got %d %v", w.Code, w.Header())
+	}
+	close(release)
+	<-done
+}
+
+func TestCORSPreflight(t *testing.T) {
+	conf := &Config{}
+	conf.CORS.TrustedOrigins = []string{"https://app.example.com"}
+	r := NewRouter(conf)
+	r.Post("/items", func(w http.ResponseWriter, req *http.Request) {
+		t.Error("expected the preflight answered by the middleware")
+	})
+	h := r.Handler()
+	for origin, code := range map[string]int{
+		"https://app.example.com": http.StatusNoContent,
+		"https://evil.com":        http.StatusForbidden,
+	} {
+		req := httptest.NewRequest(http.MethodOptions, "/items", nil)
+		req.Header.Set("Origin", origin)
+		req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != code {
+			t.Errorf("expected %d for %s got %d", code, origin, w.Code)
+		}
+	}
+}
@@ -17,7 +17,7 @@ func (r *Router) ServeFS(path string, root http.FileSystem, mds ...handler.Middl
 		}
 		h = m(h.ServeHTTP)
 	}
-	r.trie.putEnd(http.MethodGet+path, h)
+	r.trie.putEnd(http.MethodGet+path, h, path+"/*")
 }
 
 func (r *Router) ServeFSWithGzip(path string, root http.FileSystem, mds ...handler.Middleware) {
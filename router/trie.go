@@ -28,37 +28,45 @@ func newPathTrie() *pathTrie {
 // suffix '/' counts: path '/a' is diffent from path '/a/'
 type pathTrie struct {
 	value    http.Handler
+	pattern  string // registered route of value
 	children map[string]*pathTrie
 }
 
 // get return immediately when match endChildKey, but do NOT
 // ignore plain child(include regex child) on the same level
 func (p *pathTrie) get(path string) http.Handler {
+	h, _ := p.match(path)
+	return h
+}
+
+// match returns the handler and its registered route pattern
+func (p *pathTrie) match(path string) (http.Handler, string) {
 	path = strings.Trim(strings.TrimSpace(path), pathSeperator)
 	if path == "" || p.children == nil {
-		return p.value
+		return p.value, p.pattern
 	}
 	vs := strings.Split(path, pathSeperator)
 	value := vs[0]
 	child, ok := p.children[value]
 	if ok {
 		if len(vs) == 1 {
-			return child.value
+			return child.value, child.pattern
 		}
-		return child.get(strings.Join(vs[1:], pathSeperator))
+		return child.match(strings.Join(vs[1:], pathSeperator))
 	}
 	regChild, ok := p.children[regKey]
 	if ok {
 		if len(vs) == 1 {
-			return setParamValue(regChild.value, value)
+			return setParamValue(regChild.value, value), regChild.pattern
 		}
-		return setParamValue(regChild.get(strings.Join(vs[1:], pathSeperator)), value)
+		h, pattern := regChild.match(strings.Join(vs[1:], pathSeperator))
+		return setParamValue(h, value), pattern
 	}
 	endChild, ok := p.children[endChildKey]
 	if ok {
-		return endChild.value
+		return endChild.value, endChild.pattern
 	}
-	return nil
+	return nil, ""
 }
 
 func insertCtxValue(v http.Handler, key handler.PathRegs, value string) http.Handler {
@@ -156,10 +164,12 @@ func (p *pathTrie) put(path string, value http.Handler) *pathTrie {
 // putEnd end trie at that level
 // stop look up children pathTrie
 // useful for http.Fileserver wild path
-func (p *pathTrie) putEnd(path string, value http.Handler) {
+func (p *pathTrie) putEnd(path string, value http.Handler, pattern string) {
 	node := p.put(path, value)
+	node.pattern = pattern
 	node.children[endChildKey] = &pathTrie{
-		value: value,
+		value:   value,
+		pattern: pattern,
 		//	children: make(map[string]*pathTrie),
 	}
 }
@@ -13,15 +13,24 @@ import (
 )
 
 func (app *App) httpServer(ctx context.Context, routes http.Handler) *http.Server {
+	if c, ok := routes.(interface{ Close() }); ok {
+		app.AddClearFn(c.Close)
+	}
 	srv := &http.Server{
 		Addr:     fmt.Sprintf(":%d", app.config.Port),
 		Handler:  routes,
 		ErrorLog: log.New(app.Logger, "", 0),
 	}
 	srv.IdleTimeout = time.Minute
-	srv.ReadTimeout = 10 * time.Second
+	srv.ReadTimeout = app.config.ReadTimeout
+	if srv.ReadTimeout == 0 {
+		srv.ReadTimeout = 10 * time.Second
+	}
 	if !app.config.NoWirteTimeout {
-		srv.WriteTimeout = 30 * time.Second
+		srv.WriteTimeout = app.config.WriteTimeout
+		if srv.WriteTimeout == 0 {
+			srv.WriteTimeout = 30 * time.Second
+		}
 	}
 	return srv
 }
@@ -65,12 +74,15 @@ func (app *App) Serve(ctx context.Context, routes http.Handler) error {
 
 // Shutdown call clearFns one by one
 func (app *App) Shutdown() {
-	for _, fn := range app.clearFns {
+	app.clearLock.Lock()
+	fns := app.clearFns
+	app.clearFns = nil
+	app.clearLock.Unlock()
+	for _, fn := range fns {
 		app.clearWG.Add(1)
 		go fn()
 	}
 	app.clearWG.Wait()
-	app.clearFns = nil
 }
 
 func handleOSsignal(ctx context.Context, app *App, srv *http.Server) {